@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"github.com/pkg/errors"
+)
+
+const (
+	// usage describes the subcommands.
+	usage = `usage: pipeline <command> [flags] <file>
+
+commands:
+  run       run a pipeline (the default command: pipeline [flags] <file>)
+  watch     run a pipeline, running nodes again when their watched files change
+  validate  check a pipeline file
+  list      list the nodes of a pipeline
+  graph     print the node tree of a pipeline
+  ctl       control a running pipeline
+  version   print the version
+  help      print this help
+
+The file can be YAML, JSON or TOML ("-" reads the standard input).
+Run "pipeline <command> --help" for the flags of a command.
+`
+	// usageExitCode is returned on invalid arguments.
+	usageExitCode = 2
+)
+
+// version is set at build time, eg: go build -ldflags "-X main.version=v1.2.3".
+var version = "" // nolint:gochecknoglobals // set by the linker
+
+var (
+	// errNothingWatched is returned when watching a pipeline without watch patterns.
+	errNothingWatched = errors.New("no node to watch (set the watch patterns of some nodes)")
+	// errUsage is returned on invalid arguments.
+	errUsage = errors.New("invalid arguments")
+)
+
+type (
+	// listFlag collects comma-separated values, from repeated flags too.
+	listFlag []string
+
+	// varsFlag collects NAME=VALUE variables.
+	varsFlag map[string]string
+
+	// loader holds the flags shared by the commands reading a pipeline file.
+	loader struct {
+		format   string
+		logLevel string
+		from     string
+		only     listFlag
+		skip     listFlag
+		until    string
+		vars     varsFlag
+	}
+)
+
+// Main runs the command line and returns the exit code.
+func Main(args []string, out io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+
+		return usageExitCode
+	}
+
+	switch args[0] {
+	case "ctl":
+		return Ctl(args[1:], out)
+	case "graph":
+		return graph(args[1:], out)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(out, usage)
+
+		return 0
+	case "list":
+		return list(args[1:], out)
+	case "run":
+		return run(args[1:], out)
+	case "validate":
+		return validate(args[1:], out)
+	case "watch":
+		return watch(args[1:])
+	case "version":
+		fmt.Fprintf(out, "pipeline %s (%s %s/%s)\n", versionString(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+
+		return 0
+	default:
+		return run(args, out)
+	}
+}
+
+// validate implements `pipeline validate [flags] <file>`.
+func validate(args []string, out io.Writer) int {
+	flags := newFlagSet("validate", "check a pipeline file (format, policies, durations, plugin types and selection)")
+	load := newLoader(flags, "format")
+
+	node, code := load.parse(flags, args)
+	if node == nil {
+		return code
+	}
+
+	fmt.Fprintf(out, "%s: ok\n", node.ID())
+
+	return 0
+}
+
+// watch implements `pipeline watch [flags] <file>`.
+func watch(args []string) int {
+	flags := newFlagSet("watch", "run a pipeline until interrupted: the nodes with watch patterns run again when "+
+		"their files change (serial steps re-run their group)")
+	load := newLoader(flags, "format")
+	debounce := flags.Duration("debounce", pipeline.DefaultDebounce, "wait for the file changes to settle this long")
+	grace := flags.Duration("grace", pipeline.DefaultGrace, "let the restarted processes exit on SIGTERM this long, "+
+		"before killing them (unless their node sets a stopTimeout)")
+
+	node, code := load.parse(flags, args)
+	if node == nil {
+		return code
+	}
+
+	if !node.Watched() {
+		log.Printf("ERROR: %v", errNothingWatched)
+
+		return 1
+	}
+
+	ctx, stop := runContext()
+	defer stop()
+
+	err := node.WithWatch(*debounce, *grace).Run(ctx)
+	if ctx.Err() != nil {
+		// Interrupted, as expected.
+		return 0
+	}
+
+	return exitCode(err)
+}
+
+// list implements `pipeline list [flags] <file>`.
+func list(args []string, out io.Writer) int {
+	flags := newFlagSet("list", "list the nodes of a pipeline, by path")
+	load := newLoader(flags, "format")
+
+	node, code := load.parse(flags, args)
+	if node == nil {
+		return code
+	}
+
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0) // nolint:gomnd // padding
+	fmt.Fprintln(table, "PATH\tKIND")
+	writeList(table, node, node.ID())
+
+	if err := table.Flush(); err != nil {
+		log.Printf("ERROR: %v", err)
+
+		return 1
+	}
+
+	return 0
+}
+
+// graph implements `pipeline graph [flags] <file>`.
+func graph(args []string, out io.Writer) int {
+	flags := newFlagSet("graph", "print the node tree of a pipeline, as text or as a Graphviz or Mermaid diagram")
+	load := newLoader(flags, "input-format")
+	format := flags.String("format", string(pipeline.GraphTree), "diagram format: tree, dot or mermaid")
+	reportFile := flags.String("report", "", "overlay the statuses of this JSON run report (see run --report)")
+
+	node, code := load.parse(flags, args)
+	if node == nil {
+		return code
+	}
+
+	kind, err := pipeline.ParseGraphFormat(*format)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+
+		return usageExitCode
+	}
+
+	var report *pipeline.Result
+
+	if *reportFile != "" {
+		if report, err = readReport(*reportFile); err != nil {
+			log.Printf("ERROR: %v", err)
+
+			return 1
+		}
+	}
+
+	if err := node.WriteGraph(out, kind, report); err != nil {
+		log.Printf("ERROR: %v", err)
+
+		return 1
+	}
+
+	return 0
+}
+
+// readReport reads a JSON run report.
+func readReport(file string) (*pipeline.Result, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "report")
+	}
+
+	var report pipeline.Result
+
+	return &report, errors.Wrapf(json.Unmarshal(data, &report), "invalid report %s", file)
+}
+
+// writeList writes a row per node: its path and kind (and whether it is skipped).
+func writeList(w io.Writer, node *pipeline.Node, path string) {
+	if node.Skipped() {
+		fmt.Fprintf(w, "%s\t%s (skipped)\n", path, node.Kind())
+	} else {
+		fmt.Fprintf(w, "%s\t%s\n", path, node.Kind())
+	}
+
+	children := node.Children()
+	for i := range children {
+		writeList(w, &children[i], node.ChildPath(path, i))
+	}
+}
+
+// newFlagSet returns a flag set whose usage describes the command.
+func newFlagSet(name, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: pipeline %s [flags] <file>\n\n%s.\n\nflags:\n", name, description)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+// newLoader registers the flags used to load a pipeline file; formatFlag names the pipeline format flag.
+func newLoader(flags *flag.FlagSet, formatFlag string) *loader {
+	load := &loader{format: "", from: "", logLevel: "", only: nil, skip: nil, until: "", vars: make(varsFlag)}
+
+	flags.StringVar(&load.format, formatFlag, "", "pipeline format (json, toml or yaml), detected from the file extension when empty")
+	flags.StringVar(&load.logLevel, "log-level", "", "log level (debug, info, warn or error), overrides log.level")
+	flags.Var(&load.only, "only", "run only these nodes (and their children), by name or path, comma-separated")
+	flags.Var(&load.skip, "skip", "skip these nodes (and their children), by name or path, comma-separated")
+	flags.StringVar(&load.from, "from", "", "skip the serial steps before this node, by name or path")
+	flags.StringVar(&load.until, "until", "", "skip the serial steps after this node, by name or path")
+	flags.Var(load.vars, "set", "set a variable, overriding the vars of the pipeline (eg: --set VERSION=1.2), repeatable")
+
+	return load
+}
+
+// parse parses the command line (flags can follow the file) and loads the pipeline. It returns a nil node, along
+// with the exit code, on failure.
+func (l *loader) parse(flags *flag.FlagSet, args []string, opts ...pipeline.Option) (*pipeline.Node, int) {
+	files, err := parseArgs(flags, args)
+
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return nil, 0
+	case err != nil:
+		return nil, usageExitCode
+	case len(files) != 1:
+		log.Printf("ERROR: %v: expected one pipeline file", errUsage)
+		flags.Usage()
+
+		return nil, usageExitCode
+	}
+
+	node, err := l.load(files[0], opts...)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+
+		return nil, 1
+	}
+
+	return node, 0
+}
+
+// load reads, validates and prunes a pipeline file.
+func (l *loader) load(file string, opts ...pipeline.Option) (*pipeline.Node, error) {
+	kind := pipeline.FormatOf(file)
+
+	if l.format != "" {
+		var err error
+
+		if kind, err = pipeline.ParseFormat(l.format); err != nil {
+			return nil, err // nolint:wrapcheck // already wrapped
+		}
+	}
+
+	opts = append(opts, pipeline.WithVars(l.vars), pipeline.WithLogLevel(l.logLevel))
+
+	node, err := pipeline.NewFromFileWithFormat(file, kind, opts...)
+	if err != nil {
+		return nil, err // nolint:wrapcheck // already wrapped
+	}
+
+	if err := node.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", file)
+	}
+
+	sel := pipeline.Selection{Only: l.only, Skip: l.skip, From: l.from, Until: l.until}
+
+	return node, errors.Wrap(node.Select(sel), "selection")
+}
+
+// parseArgs parses flags interspersed with positional arguments, and returns the latter.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err // nolint:wrapcheck // flag errors are printed by the flag set
+		}
+
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+
+		positional, args = append(positional, flags.Arg(0)), flags.Args()[1:]
+	}
+}
+
+// versionString returns the linker version, or the module version.
+func versionString() string {
+	if version != "" {
+		return version
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+
+	return "(devel)"
+}
+
+// String implements flag.Value.
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set implements flag.Value.
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+
+	return nil
+}
+
+// String implements flag.Value.
+func (v varsFlag) String() string {
+	pairs := make([]string, 0, len(v))
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// Set implements flag.Value.
+func (v varsFlag) Set(value string) error {
+	pair := strings.SplitN(value, "=", 2) // nolint:gomnd // name and value
+	if len(pair) != 2 || pair[0] == "" {
+		return errors.Wrapf(errUsage, "%s: expected NAME=VALUE", value)
+	}
+
+	v[pair[0]] = pair[1]
+
+	return nil
+}
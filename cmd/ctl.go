@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/control"
+	"github.com/pkg/errors"
+)
+
+// ctlUsage describes the ctl subcommands.
+const ctlUsage = `usage: pipeline ctl [flags] <command> [args]
+
+commands:
+  status [node]         show the live node tree (or a node)
+  stop <node>           stop a command until start
+  start <node>          start a stopped command
+  restart <node>        restart a command, regardless of its exit policy
+  pause <node>          prevent a command from being restarted, until resume
+  resume <node>         undo pause
+  signal <node> <sig>   send a signal (eg: HUP) to a command
+  logs <node> [-f]      print the last output of a command (and follow it)
+
+Nodes are identified by path (eg: root/stage1/daemon-1) or by unique name (eg: daemon-1).
+
+flags:
+`
+
+// nextRunFormat is the format of the next runs of the scheduled nodes.
+const nextRunFormat = "2006-01-02 15:04:05"
+
+// errNoSocket is returned when ctl does not know where the pipeline listens.
+var errNoSocket = errors.New("no control address: use --addr, --socket or --file (with control.socket)")
+
+// Ctl implements `pipeline ctl`, writing into out, and returns the exit code.
+func Ctl(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	addr := flags.String("addr", "", "address of the control API (eg: localhost:9101 or unix:/run/pipeline.sock)")
+	socket := flags.String("socket", "", "path of the control socket")
+	file := flags.String("file", "", "pipeline file, to read its control.socket")
+	asJSON := flags.Bool("json", false, "print the node states as JSON")
+
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), ctlUsage)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		flags.Usage()
+
+		return usageExitCode
+	}
+
+	target, err := ctlAddr(*addr, *socket, *file)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+
+		return usageExitCode
+	}
+
+	ctx, stop := runContext()
+	defer stop()
+
+	cmd := ctlCommand{client: control.NewClient(target), json: *asJSON, out: out}
+	if err := cmd.run(ctx, flags.Arg(0), flags.Args()[1:]); err != nil {
+		log.Printf("ERROR: %v", err)
+
+		if errors.Is(err, errUsage) {
+			flags.Usage()
+
+			return usageExitCode
+		}
+
+		return 1
+	}
+
+	return 0
+}
+
+// ctlCommand runs a ctl command against a client.
+type ctlCommand struct {
+	client *control.Client
+	json   bool
+	out    io.Writer
+}
+
+// run dispatches a command.
+func (c ctlCommand) run(ctx context.Context, name string, args []string) error {
+	switch {
+	case name == "status" && len(args) == 0:
+		return c.print(c.client.Tree(ctx))
+	case name == "status" && len(args) == 1:
+		return c.print(c.client.Node(ctx, args[0]))
+	case name == "signal" && len(args) == 2:
+		return c.print(c.client.Signal(ctx, args[0], args[1]))
+	case name == "logs":
+		return c.logs(ctx, args)
+	case len(args) != 1:
+		return errors.Wrap(errUsage, name)
+	}
+
+	switch name {
+	case "pause", "restart", "resume", "start", "stop":
+		return c.print(c.client.Act(ctx, args[0], name))
+	default:
+		return errors.Wrapf(errUsage, "unknown command %s", name)
+	}
+}
+
+// logs implements `ctl logs <node> [-f]` (flags can follow the node).
+func (c ctlCommand) logs(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("logs", flag.ContinueOnError)
+	follow := flags.Bool("f", false, "follow the output")
+
+	nodes, err := parseArgs(flags, args)
+	if err != nil {
+		return errors.Wrap(errUsage, err.Error())
+	}
+
+	if len(nodes) != 1 {
+		return errors.Wrap(errUsage, "logs")
+	}
+
+	return c.client.Logs(ctx, nodes[0], *follow, c.out) // nolint:wrapcheck // already wrapped
+}
+
+// print writes node states, as JSON or as a table.
+func (c ctlCommand) print(state *pipeline.NodeState, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+
+		return errors.Wrap(enc.Encode(state), "json")
+	}
+
+	table := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0) // nolint:gomnd // padding
+	fmt.Fprintln(table, "NODE\tSTATUS\tPID\tRESTARTS\tEXIT\tNEXT RUN")
+	writeState(table, state, 0)
+
+	return errors.Wrap(table.Flush(), "cannot print")
+}
+
+// writeState writes a table row per node, indented by depth.
+func writeState(w io.Writer, state *pipeline.NodeState, depth int) {
+	pid, code, next, status := "-", "-", "-", string(state.Status)
+
+	if state.PID != 0 {
+		pid = strconv.Itoa(state.PID)
+	}
+
+	if state.ExitCode != nil {
+		code = strconv.Itoa(*state.ExitCode)
+	}
+
+	if state.NextRun != nil {
+		next = state.NextRun.Local().Format(nextRunFormat)
+	}
+
+	if state.Paused {
+		status += " (paused)"
+	}
+
+	name := state.Path
+	if depth > 0 {
+		name = strings.Repeat("  ", depth) + state.Name
+	}
+
+	fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", name, status, pid, state.Restarts, code, next)
+
+	for _, child := range state.Children {
+		writeState(w, child, depth+1)
+	}
+}
+
+// ctlAddr returns the control API address, from the flags or from the pipeline file.
+func ctlAddr(addr, socket, file string) (string, error) {
+	switch {
+	case addr != "":
+		return addr, nil
+	case socket != "":
+		return control.UnixPrefix + socket, nil
+	case file == "":
+		return "", errNoSocket
+	}
+
+	node, err := pipeline.NewFromFile(file)
+	if err != nil {
+		return "", err // nolint:wrapcheck // already wrapped
+	}
+
+	if node.ControlConfig.Socket == "" {
+		return "", errors.Wrap(errNoSocket, file)
+	}
+
+	return control.UnixPrefix + node.ControlConfig.Socket, nil
+}
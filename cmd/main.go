@@ -1,28 +1,115 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/control"
+	"bitbucket.org/lucacontini/z6/pipeline/event"
+	"bitbucket.org/lucacontini/z6/pipeline/metrics"
+	"bitbucket.org/lucacontini/z6/pipeline/trace"
 	"github.com/pkg/errors"
 )
 
-// errExitCode is the default exit code.
-const errExitCode = 125
+const (
+	// errExitCode is the default exit code.
+	errExitCode = 125
+	// reportPerm is the permission of report files.
+	reportPerm = 0o644
+	// serviceName is the default OpenTelemetry service name.
+	serviceName = "pipeline"
+	// traceTimeout is how long the exporters can take to flush the spans.
+	traceTimeout = 10 * time.Second
+)
 
-// task represents a task that can run.
-type task interface {
-	Run(context.Context) error
-}
+type (
+	// task represents a task that can run.
+	task interface {
+		Run(context.Context) error
+	}
+
+	// Reports lists the report files to write after a run (empty names are skipped).
+	Reports struct {
+		JSON  string
+		JUnit string
+	}
+)
 
 // Run executes a task and returns any propagated exit code.
 func Run(e task) int {
-	var exErr *exec.ExitError
+	ctx, stop := runContext()
+	defer stop()
+
+	return exitCode(e.Run(ctx))
+}
 
-	err := e.Run(context.Background())
+// RunWithReport executes a pipeline, writes its reports and returns any propagated exit code.
+func RunWithReport(node *pipeline.Node, reports Reports) int {
+	ctx, stop := runContext()
+	defer stop()
+
+	result, err := node.RunWithResult(ctx)
+	code := exitCode(err)
+
+	if err := reports.write(result); err != nil {
+		log.Printf("FATAL: cannot write report: %v", err)
+
+		return errExitCode
+	}
+
+	return code
+}
+
+// Enabled returns whether any report is requested.
+func (r Reports) Enabled() bool {
+	return r.JSON != "" || r.JUnit != ""
+}
+
+// write writes the requested reports.
+func (r Reports) write(result *pipeline.Result) error {
+	if r.JSON != "" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "json")
+		}
+
+		if err := os.WriteFile(r.JSON, data, reportPerm); err != nil {
+			return errors.Wrap(err, "json")
+		}
+	}
+
+	if r.JUnit != "" {
+		var buf bytes.Buffer
+
+		if err := result.WriteJUnit(&buf); err != nil {
+			return errors.Wrap(err, "junit")
+		}
+
+		if err := os.WriteFile(r.JUnit, buf.Bytes(), reportPerm); err != nil {
+			return errors.Wrap(err, "junit")
+		}
+	}
+
+	return nil
+}
+
+// runContext returns a context cancelled by SIGINT and SIGTERM, so that child processes are stopped too.
+func runContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// exitCode converts an error into an exit code.
+func exitCode(err error) int {
+	var exErr *exec.ExitError
 
 	switch {
 	case err == nil:
@@ -37,12 +124,196 @@ func Run(e task) int {
 }
 
 func main() {
-	task, err := pipeline.NewFromFile(os.Args[1])
+	os.Exit(Main(os.Args[1:], os.Stdout))
+}
+
+// run implements `pipeline [run] [flags] <file>` and returns the exit code.
+func run(args []string, out io.Writer) int {
+	flags := newFlagSet("run", "run a pipeline")
+	load := newLoader(flags, "format")
+	reports := Reports{JSON: "", JUnit: ""}
+
+	flags.StringVar(&reports.JSON, "report", "", "write a JSON run report to this file")
+	flags.StringVar(&reports.JUnit, "junit", "", "write a JUnit XML report to this file")
+
+	controlAddr := flags.String("control-addr", "",
+		"serve the control API on this loopback or Unix socket address (eg: localhost:9101 or "+
+			"unix:/run/pipeline.sock), defaults to the control.socket of the pipeline")
+	metricsAddr := flags.String("metrics-addr", "", "serve Prometheus metrics on this address (eg: :9100)")
+	eventsFile := flags.String("events", "", "write the lifecycle events to this file, one JSON object per line")
+	traceFile := flags.String("trace-file", "", "write the trace spans to this file, one JSON object per line")
+	otlpEndpoint := flags.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"send the trace spans to this OTLP/HTTP collector (eg: http://localhost:4318)")
+	timeout := flags.Duration("timeout", 0, "stop the whole run after this duration (eg: 10m), unless the pipeline "+
+		"timeout is shorter")
+	dryRun := flags.Bool("dry-run", false, "print the execution plan (commands, streams, policies and timeouts), "+
+		"without running anything")
+	resume := flags.Bool("resume", false, "skip the serial steps that succeeded in the previous (failed) run, "+
+		"unless their definition changed, and record the nodes that succeed for the next one")
+	noCache := flags.Bool("no-cache", false, "run the commands declaring inputs or outputs even when up to date")
+	cacheDir := flags.String("cache-dir", "", "keep the cache records in this directory, overrides cache.dir")
+	stateFile := flags.String("state-file", "", "record the nodes that succeeded into this file (even without "+
+		"--resume), defaults to .pipeline/state/<name>.json with --resume")
+
+	task, code := load.parse(flags, args)
+	if task == nil {
+		return code
+	}
+
+	if *timeout > 0 && (task.Timeout == 0 || task.Timeout.Duration() > *timeout) {
+		task.Timeout = pipeline.Duration(*timeout)
+	}
+
+	var checkpoint *pipeline.Checkpoint
+
+	// The nodes that succeed are only recorded when resuming, or into an explicit state file.
+	switch {
+	case *stateFile != "":
+		checkpoint = pipeline.NewCheckpoint(*stateFile, task.ID())
+	case *resume:
+		*stateFile = pipeline.CheckpointFile(task.ID())
+	}
+
+	if *resume {
+		var err error
+
+		if checkpoint, err = pipeline.LoadCheckpoint(*stateFile, task.ID()); err != nil {
+			return exitCode(err)
+		}
+
+		task.Resume(checkpoint)
+	}
+
+	// Nothing is started, opened or created on dry runs.
+	if *dryRun {
+		return exitCode(task.WritePlan(out))
+	}
+
+	task.WithCheckpoint(checkpoint)
+
+	if !*noCache {
+		config := task.CacheConfig
+		if *cacheDir != "" {
+			config.Dir = *cacheDir
+		}
+
+		task.WithCache(pipeline.NewCache(config))
+	}
+
+	if *eventsFile != "" {
+		events, err := os.Create(*eventsFile)
+		if err != nil {
+			return exitCode(errors.Wrap(err, "events"))
+		}
+
+		defer events.Close()
+
+		sink := event.NewJSONLines(events)
+		task.WithObserver(sink)
+
+		defer func() {
+			if err := sink.Err(); err != nil {
+				log.Printf("ERROR: events: %v", err)
+			}
+		}()
+	}
+
+	if *metricsAddr != "" {
+		defer ServeMetrics(task, *metricsAddr)()
+	}
+
+	if *controlAddr == "" && task.ControlConfig.Socket != "" {
+		*controlAddr = control.UnixPrefix + task.ControlConfig.Socket
+	}
+
+	if *controlAddr != "" {
+		defer ServeControl(task, *controlAddr)()
+	}
+
+	shutdown, err := StartTracing(task, *traceFile, *otlpEndpoint)
 	if err != nil {
-		log.Fatalf("error %v", err)
+		return exitCode(err)
+	}
+
+	defer shutdown()
+
+	if reports.Enabled() {
+		return RunWithReport(task, reports)
 	}
 
-	code := Run(task)
+	return Run(task)
+}
+
+// ServeMetrics attaches Prometheus collectors to the pipeline and serves them on addr. It returns a function that
+// stops the server.
+func ServeMetrics(node *pipeline.Node, addr string) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	collectors := metrics.New()
+
+	node.WithMetrics(collectors)
+
+	go func() {
+		if err := collectors.Serve(ctx, addr); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+	}()
+
+	return cancel
+}
+
+// ServeControl attaches a supervisor to the pipeline and serves the control API on addr. It returns a function that
+// stops the server.
+func ServeControl(node *pipeline.Node, addr string) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	supervisor := pipeline.NewSupervisor(node)
+
+	node.WithSupervisor(supervisor)
+
+	go func() {
+		if err := control.Serve(ctx, addr, supervisor); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+	}()
+
+	return cancel
+}
+
+// StartTracing attaches a tracer to the pipeline, exporting spans to a JSON-lines file and/or an OTLP collector. The
+// root span joins the trace of the TRACEPARENT variable, if set. It returns a function that flushes the spans.
+func StartTracing(node *pipeline.Node, file, endpoint string) (func(), error) {
+	exporters := make([]trace.Exporter, 0)
+
+	if file != "" {
+		out, err := os.Create(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "trace file")
+		}
+
+		exporters = append(exporters, trace.NewJSONLines(out))
+	}
+
+	if endpoint != "" {
+		service := os.Getenv("OTEL_SERVICE_NAME")
+		if service == "" {
+			service = serviceName
+		}
+
+		exporters = append(exporters, trace.NewOTLP(endpoint, service))
+	}
+
+	if len(exporters) == 0 {
+		return func() {}, nil
+	}
+
+	tracer := trace.New(trace.Multi(exporters...), os.Getenv(trace.EnvTraceParent))
+	node.WithTracer(tracer)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), traceTimeout)
+		defer cancel()
 
-	os.Exit(code)
+		if err := tracer.Shutdown(ctx); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+	}, nil
 }
@@ -1,7 +1,14 @@
 package main_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	main "bitbucket.org/lucacontini/z6/cmd"
 	"bitbucket.org/lucacontini/z6/pipeline"
@@ -32,6 +39,18 @@ func TestFunctional(t *testing.T) {
 			},
 			want: want{code: 0},
 		},
+		"File test-pipeline-001.json": {
+			args: args{
+				file: "../testdata/test-pipeline-001.json",
+			},
+			want: want{code: 0},
+		},
+		"File test-pipeline-001.toml": {
+			args: args{
+				file: "../testdata/test-pipeline-001.toml",
+			},
+			want: want{code: 0},
+		},
 		"File test-pipeline-002.yaml": {
 			args: args{
 				file: "../testdata/test-pipeline-002.yaml",
@@ -62,3 +81,181 @@ func mkPipeline(t *testing.T, file string) *pipeline.Node {
 
 	return p.WithLogger(nil)
 }
+
+func TestRunWithReport(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	reports := main.Reports{
+		JSON:  filepath.Join(dir, "report.json"),
+		JUnit: filepath.Join(dir, "report.xml"),
+	}
+
+	code := main.RunWithReport(mkPipeline(t, "../testdata/test-pipeline-002.yaml"), reports)
+	assert.Equal(t, 67, code)
+	assert.FileExists(t, reports.JUnit)
+
+	data, err := os.ReadFile(reports.JSON)
+	require.NoError(t, err)
+
+	var report pipeline.Result
+
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, "test-pipeline-002", report.Name)
+	assert.Equal(t, pipeline.StatusFailed, report.Status)
+	require.Len(t, report.Children, 2)
+	assert.Equal(t, 64, *report.Children[0].ExitCode)
+}
+
+func TestStartTracing(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "trace.jsonl")
+	node := mkPipeline(t, "../testdata/test-pipeline-002.yaml")
+
+	shutdown, err := main.StartTracing(node, file, "")
+	require.NoError(t, err)
+
+	assert.Equal(t, 67, main.Run(node))
+	shutdown()
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.NotEmpty(t, lines)
+
+	var root map[string]interface{}
+
+	require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &root))
+	assert.Equal(t, "test-pipeline-002", root["name"])
+	assert.NotContains(t, root, "parentSpanId")
+}
+
+func TestCtl(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "pipeline.sock")
+	file := filepath.Join(dir, "pipeline.yml")
+	content := "name: root\ncontrol:\n  socket: " + socket + "\nparallel:\n" +
+		"  - name: daemon-1\n    path: sh\n    args: [-c, \"echo ready; sleep 10\"]\n"
+
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+
+	task := mkPipeline(t, file)
+	stop := main.ServeControl(task, "unix:"+socket)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan error)
+
+	go func() { done <- task.Run(ctx) }()
+
+	defer func() {
+		cancel()
+		<-done
+		stop()
+	}()
+
+	ctl := func(args ...string) (int, string) {
+		var out bytes.Buffer
+
+		code := main.Ctl(args, &out)
+
+		return code, out.String()
+	}
+
+	require.Eventually(t, func() bool {
+		_, out := ctl("--socket", socket, "logs", "daemon-1")
+
+		return out == "ready\n"
+	}, time.Second, 10*time.Millisecond)
+
+	tests := map[string]struct {
+		args []string
+		code int
+		want string
+	}{
+		"status":     {args: []string{"--file", file, "status"}, code: 0, want: "  daemon-1  running"},
+		"node":       {args: []string{"--socket", socket, "status", "daemon-1"}, code: 0, want: "root/daemon-1"},
+		"json":       {args: []string{"--socket", socket, "--json", "status", "daemon-1"}, code: 0, want: `"name": "daemon-1"`},
+		"logs":       {args: []string{"--addr", "unix:" + socket, "logs", "daemon-1"}, code: 0, want: "ready\n"},
+		"unknown":    {args: []string{"--socket", socket, "stop", "nope"}, code: 1, want: ""},
+		"bad usage":  {args: []string{"--socket", socket, "stop"}, code: 2, want: ""},
+		"no command": {args: []string{"--socket", socket}, code: 2, want: ""},
+		"no socket":  {args: []string{"status"}, code: 2, want: ""},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			code, out := ctl(tt.args...)
+
+			assert.Equal(t, tt.code, code)
+			assert.Contains(t, out, tt.want)
+		})
+	}
+}
+
+func TestCLI(t *testing.T) {
+	t.Parallel()
+
+	const file = "../testdata/test-pipeline-001.yaml"
+
+	tests := map[string]struct {
+		args []string
+		code int
+		want string
+	}{
+		"no command":      {args: nil, code: 2, want: ""},
+		"help":            {args: []string{"--help"}, code: 0, want: "commands:"},
+		"version":         {args: []string{"version"}, code: 0, want: "pipeline "},
+		"validate":        {args: []string{"validate", file}, code: 0, want: "test-pipeline-001: ok"},
+		"validate flags":  {args: []string{"validate", file, "--skip", "paral-1"}, code: 0, want: "ok"},
+		"invalid file":    {args: []string{"validate", "../testdata/nope.yaml"}, code: 1, want: ""},
+		"list":            {args: []string{"list", file}, code: 0, want: "test-pipeline-001/parallel/paral-2  command"},
+		"graph":           {args: []string{"graph", "--only", "paral-1", file}, code: 0, want: "    ├── paral-1 (command)\n    └── paral-2 (command, skipped)"},
+		"graph mermaid":   {args: []string{"graph", "--format", "mermaid", file}, code: 0, want: "flowchart TD"},
+		"bad graph":       {args: []string{"graph", "--format", "svg", file}, code: 2, want: ""},
+		"missing report":  {args: []string{"graph", "--report", "nope.json", file}, code: 1, want: ""},
+		"dry run":         {args: []string{"run", "--dry-run", "--skip", "print-0a", file}, code: 0, want: "1. print-0a: command (skipped)"},
+		"default command": {args: []string{"--log-level", "error", "../testdata/test-pipeline-002.yaml"}, code: 67, want: ""},
+		"from until":      {args: []string{"list", "--from", "parallel", "--until", "parallel", file}, code: 0, want: "print-0a          command (skipped)"},
+		"missing file":    {args: []string{"run"}, code: 2, want: ""},
+		"two files":       {args: []string{"list", file, file}, code: 2, want: ""},
+		"bad flag":        {args: []string{"run", "--nope", file}, code: 2, want: ""},
+		"bad variable":    {args: []string{"run", "--set", "nope", file}, code: 2, want: ""},
+		"unknown node":    {args: []string{"run", "--only", "nope", file}, code: 1, want: ""},
+		"bad log level":   {args: []string{"run", "--log-level", "loud", file}, code: 1, want: ""},
+		"nothing watched": {args: []string{"watch", file}, code: 1, want: ""},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var out bytes.Buffer
+
+			assert.Equal(t, tt.code, main.Main(tt.args, &out))
+			assert.Contains(t, out.String(), tt.want)
+		})
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	t.Parallel()
+
+	const file = "../testdata/test-pipeline-002.yaml"
+
+	state := filepath.Join(t.TempDir(), "state.json")
+
+	// The nodes that succeed are only recorded when resuming, or into an explicit state file.
+	assert.Equal(t, 67, main.Main([]string{"--log-level", "error", file}, &bytes.Buffer{}))
+	assert.NoFileExists(t, pipeline.CheckpointFile("test-pipeline-002"))
+
+	assert.Equal(t, 67, main.Main([]string{"--log-level", "error", "--state-file", state, file}, &bytes.Buffer{}))
+	assert.FileExists(t, state)
+}
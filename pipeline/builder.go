@@ -0,0 +1,263 @@
+package pipeline
+
+import (
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+	"github.com/pkg/errors"
+)
+
+// Builder assembles a pipeline in Go, as an alternative to YAML definitions.
+//
+//	root, err := pipeline.Serial(
+//		pipeline.Command("go", "vet", "./..."),
+//		pipeline.Command("go", "test", "./...").Timeout(5 * time.Minute),
+//	).Name("ci").Build()
+type Builder struct {
+	node Node
+}
+
+// Command returns a builder for a node that executes an OS command.
+func Command(path string, args ...string) *Builder {
+	return &Builder{node: Node{Args: args, Command: path}} // nolint:exhaustruct // zero values are defaults
+}
+
+// Parallel returns a builder for a node that runs its children concurrently.
+func Parallel(tasks ...*Builder) *Builder {
+	return &Builder{node: Node{Parallel: nodes(tasks)}} // nolint:exhaustruct // zero values are defaults
+}
+
+// Plugin returns a builder for a node whose task type was registered with loop.Register.
+func Plugin(kind string, with map[string]interface{}) *Builder {
+	return &Builder{node: Node{Type: kind, With: with}} // nolint:exhaustruct // zero values are defaults
+}
+
+// Serial returns a builder for a node that runs its children one after another.
+func Serial(steps ...*Builder) *Builder {
+	return &Builder{node: Node{Steps: nodes(steps)}} // nolint:exhaustruct // zero values are defaults
+}
+
+// Capture stores the trimmed standard output of the command into a variable, for the next nodes to reference as
+// ${NAME} or ${steps.<node name>.stdout}. When parsed as JSON, ${NAME.field} and ${steps.<node name>.json.field}
+// reference its fields.
+func (b *Builder) Capture(name string, asJSON bool) *Builder {
+	b.node.Capture = name
+	b.node.CaptureJSON = asJSON
+
+	return b
+}
+
+// Deadline sets the wall-clock time at which the node is stopped.
+func (b *Builder) Deadline(deadline time.Time) *Builder {
+	b.node.Deadline = &deadline
+
+	return b
+}
+
+// Delay sets the pause between restarts.
+func (b *Builder) Delay(delay time.Duration) *Builder {
+	b.node.Delay = Duration(delay)
+
+	return b
+}
+
+// Every runs the node periodically, at a fixed interval (see Schedule).
+func (b *Builder) Every(interval time.Duration) *Builder {
+	b.node.Every = Duration(interval)
+
+	return b
+}
+
+// Inputs sets the glob patterns of the files read by a command (see Cache).
+func (b *Builder) Inputs(patterns ...string) *Builder {
+	b.node.Inputs = patterns
+
+	return b
+}
+
+// Log sets the logger configuration (only relevant for the root node).
+func (b *Builder) Log(config LogConfig) *Builder {
+	b.node.LogConfig = config
+
+	return b
+}
+
+// Name sets the node name.
+func (b *Builder) Name(name string) *Builder {
+	b.node.Name = name
+
+	return b
+}
+
+// OnExit sets the exit policy.
+func (b *Builder) OnExit(policy loop.ExitPolicy) *Builder {
+	b.node.OnExit = policy
+
+	return b
+}
+
+// OnFailure sets the hook run when the node fails.
+func (b *Builder) OnFailure(hook *Builder) *Builder {
+	b.node.Hooks.OnFailure = hook.ref()
+
+	return b
+}
+
+// OnRestart sets the hook run before every restart.
+func (b *Builder) OnRestart(hook *Builder) *Builder {
+	b.node.Hooks.OnRestart = hook.ref()
+
+	return b
+}
+
+// OnStart sets the hook run when the node starts.
+func (b *Builder) OnStart(hook *Builder) *Builder {
+	b.node.Hooks.OnStart = hook.ref()
+
+	return b
+}
+
+// OnSuccess sets the hook run when the node succeeds.
+func (b *Builder) OnSuccess(hook *Builder) *Builder {
+	b.node.Hooks.OnSuccess = hook.ref()
+
+	return b
+}
+
+// Outputs sets the files (or directories) written by a command (see Cache).
+func (b *Builder) Outputs(paths ...string) *Builder {
+	b.node.Outputs = paths
+
+	return b
+}
+
+// Overlap sets what happens when a scheduled run is due while the previous one is still running.
+func (b *Builder) Overlap(policy loop.OverlapPolicy) *Builder {
+	b.node.Overlap = policy
+
+	return b
+}
+
+// Schedule runs the node periodically, following a cron expression, until the pipeline is stopped.
+func (b *Builder) Schedule(spec string) *Builder {
+	b.node.Schedule = spec
+
+	return b
+}
+
+// Stderr sets the standard error streams (files, or stdout, stderr and devnul), each receiving a copy.
+func (b *Builder) Stderr(streams ...string) *Builder {
+	b.node.Stderr = subprocess.To(streams...)
+
+	return b
+}
+
+// StderrSinks sets the standard error sinks, eg: to prefix its lines.
+func (b *Builder) StderrSinks(sinks ...subprocess.Sink) *Builder {
+	b.node.Stderr = sinks
+
+	return b
+}
+
+// Stdout sets the standard output streams (files, or stdout, stderr and devnul), each receiving a copy.
+func (b *Builder) Stdout(streams ...string) *Builder {
+	b.node.Stdout = subprocess.To(streams...)
+
+	return b
+}
+
+// StdoutSinks sets the standard output sinks, eg: to prefix its lines.
+func (b *Builder) StdoutSinks(sinks ...subprocess.Sink) *Builder {
+	b.node.Stdout = sinks
+
+	return b
+}
+
+// StopTimeout sets how long the processes of a command can take to exit on SIGTERM once it is stopped, before being
+// killed.
+func (b *Builder) StopTimeout(timeout time.Duration) *Builder {
+	b.node.StopTimeout = Duration(timeout)
+
+	return b
+}
+
+// Timeout sets the maximum duration of the node.
+func (b *Builder) Timeout(timeout time.Duration) *Builder {
+	b.node.Timeout = Duration(timeout)
+
+	return b
+}
+
+// Watch sets the glob patterns of the files that run the node again, in watch mode (see Node.WithWatch).
+func (b *Builder) Watch(patterns ...string) *Builder {
+	b.node.Watch = patterns
+
+	return b
+}
+
+// Node returns a copy of the node being built, without validating it.
+func (b *Builder) Node() Node {
+	return b.node.clone()
+}
+
+// ref returns a copy of the node, or nil for a nil builder.
+func (b *Builder) ref() *Node {
+	if b == nil {
+		return nil
+	}
+
+	node := b.node.clone()
+
+	return &node
+}
+
+// Build validates the node tree and returns a runnable root node, which shares no child with the builder.
+func (b *Builder) Build() (*Node, error) {
+	exec := b.node.clone()
+
+	if err := exec.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid pipeline")
+	}
+
+	return exec.withConfiguredLogger()
+}
+
+// YAML serialises the node tree, so that it can be loaded again with New.
+func (b *Builder) YAML() ([]byte, error) {
+	return FormatYAML.Marshal(b.node)
+}
+
+// clone returns a copy of the node whose children and hooks are copied as well, so that running one of the copies
+// (which attaches the loggers, paths and so on to the children) does not alter the other.
+func (n Node) clone() Node {
+	n.Hooks = n.Hooks.clone()
+	n.Parallel = cloneNodes(n.Parallel)
+	n.Steps = cloneNodes(n.Steps)
+
+	return n
+}
+
+// cloneNodes applies clone to a list of nodes.
+func cloneNodes(nodes []Node) []Node {
+	if nodes == nil {
+		return nil
+	}
+
+	list := make([]Node, 0, len(nodes))
+	for i := range nodes {
+		list = append(list, nodes[i].clone())
+	}
+
+	return list
+}
+
+// nodes converts a list of builders into a list of nodes.
+func nodes(builders []*Builder) []Node {
+	list := make([]Node, 0, len(builders))
+	for _, b := range builders {
+		list = append(list, b.node)
+	}
+
+	return list
+}
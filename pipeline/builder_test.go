@@ -0,0 +1,323 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilderBuild(t *testing.T) {
+	t.Parallel()
+
+	type (
+		fields struct {
+			builder *pipeline.Builder
+		}
+
+		want struct {
+			err    string
+			runErr string
+		}
+	)
+
+	testTable := map[string]struct {
+		fields
+		want
+	}{
+		"Success": {
+			fields: fields{
+				builder: pipeline.Serial(
+					pipeline.Command("true"),
+					pipeline.Parallel(pipeline.Command("true"), pipeline.Command("true")),
+				).Log(pipeline.LogConfig{Disabled: true}),
+			},
+		},
+		"With error": {
+			fields: fields{
+				builder: pipeline.Serial(
+					pipeline.Command("false").Name("false-1"),
+				).Name("root").Log(pipeline.LogConfig{Disabled: true}),
+			},
+			want: want{
+				runErr: "task root: iteration aborted: task false-1: exit status 1",
+			},
+		},
+		"With timeout": {
+			fields: fields{
+				builder: pipeline.Command("sleep", "1").
+					Name("sleep-1").
+					Timeout(100 * time.Millisecond).
+					Log(pipeline.LogConfig{Disabled: true}),
+			},
+			want: want{
+				runErr: "task sleep-1: context deadline exceeded",
+			},
+		},
+		"With unknown exit policy": {
+			fields: fields{
+				builder: pipeline.Serial(
+					pipeline.Command("true").OnExit("report-if-err"),
+				).Name("root"),
+			},
+			want: want{
+				err: "invalid pipeline: root.steps[0]: report-if-err: unknown exit policy",
+			},
+		},
+		"With negative timeout": {
+			fields: fields{
+				builder: pipeline.Parallel(
+					pipeline.Command("true").Timeout(-time.Second),
+				),
+			},
+			want: want{
+				err: "invalid pipeline: parallel.parallel[0]: negative duration",
+			},
+		},
+		"With negative stop timeout": {
+			fields: fields{
+				builder: pipeline.Command("true").StopTimeout(-time.Second).Name("root"),
+			},
+			want: want{
+				err: "invalid pipeline: root: negative duration",
+			},
+		},
+		"With a partial recursive input": {
+			fields: fields{
+				builder: pipeline.Command("true").Inputs("src/a**/*.go").Name("root"),
+			},
+			want: want{
+				err: "invalid pipeline: root: pattern src/a**/*.go: ** must be a whole path element, eg: src/**/*.go",
+			},
+		},
+		"With an invalid variable input": {
+			fields: fields{
+				builder: pipeline.Command("true").Inputs("$GO-FLAGS").Name("root"),
+			},
+			want: want{
+				err: "invalid pipeline: root: $GO-FLAGS: invalid environment variable input, eg: $GOFLAGS",
+			},
+		},
+		"With a recursive watch pattern": {
+			fields: fields{
+				builder: pipeline.Command("true").Watch("src/**/*.go").Name("root"),
+			},
+			want: want{
+				err: "invalid pipeline: root: pattern src/**/*.go: ** is not supported by the watch patterns",
+			},
+		},
+		"With outputs on a group": {
+			fields: fields{
+				builder: pipeline.Serial(pipeline.Command("true")).Outputs("out.txt").Name("root"),
+			},
+			want: want{
+				err: "invalid pipeline: root: inputs and outputs are only supported by commands",
+			},
+		},
+		"With invalid schedule": {
+			fields: fields{
+				builder: pipeline.Command("true").Schedule("*/5 * * *").Name("backup"),
+			},
+			want: want{
+				err: "invalid pipeline: backup: */5 * * *: expected 5 fields: invalid cron expression",
+			},
+		},
+		"With schedule and interval": {
+			fields: fields{
+				builder: pipeline.Command("true").Schedule("@daily").Every(time.Hour).Name("backup"),
+			},
+			want: want{
+				err: "invalid pipeline: backup: schedule and every are mutually exclusive",
+			},
+		},
+		"With unknown overlap policy": {
+			fields: fields{
+				builder: pipeline.Command("true").Every(time.Hour).Overlap("wait").Name("backup"),
+			},
+			want: want{
+				err: "invalid pipeline: backup: wait: unknown overlap policy",
+			},
+		},
+		"With capture on a group": {
+			fields: fields{
+				builder: pipeline.Serial(pipeline.Command("true")).Capture("OUT", false).Name("root"),
+			},
+			want: want{
+				err: "invalid pipeline: root: capture is only supported by commands",
+			},
+		},
+		"With invalid capture name": {
+			fields: fields{
+				builder: pipeline.Command("true").Capture("steps.out", false).Name("gen"),
+			},
+			want: want{
+				err: "invalid pipeline: gen: steps.out: invalid capture name",
+			},
+		},
+		"With invalid captured JSON": {
+			fields: fields{
+				builder: pipeline.Command("echo", "{").Capture("OUT", true).Name("gen").Log(pipeline.LogConfig{Disabled: true}),
+			},
+			want: want{
+				runErr: "task gen: capture OUT: invalid JSON: unexpected end of JSON input",
+			},
+		},
+		"With empty sink": {
+			fields: fields{
+				builder: pipeline.Command("true").Stdout("stdout", "").Name("gen"),
+			},
+			want: want{
+				err: "invalid pipeline: gen: stream sink without a path",
+			},
+		},
+		"With rotated stdout": {
+			fields: fields{
+				builder: pipeline.Command("true").
+					StdoutSinks(subprocess.Sink{Path: "stdout", Mode: subprocess.FileModeTruncate}).Name("gen"),
+			},
+			want: want{
+				err: "invalid pipeline: gen: stdout: mode, rotation and permissions are only supported by files",
+			},
+		},
+		"With invalid inputs": {
+			fields: fields{
+				builder: pipeline.Command("true").Inputs("src/[").Name("gen"),
+			},
+			want: want{
+				err: "invalid pipeline: gen: pattern src/[: syntax error in pattern",
+			},
+		},
+	}
+
+	for name, unit := range testTable {
+		unit := unit
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			node, err := unit.fields.builder.Build()
+			if unit.want.err != "" {
+				assert.Nil(t, node)
+				assert.EqualError(t, err, unit.want.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+			defer cancel()
+
+			err = node.Run(ctx)
+			if unit.want.runErr != "" {
+				assert.EqualError(t, err, unit.want.runErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBuilderYAML(t *testing.T) {
+	t.Parallel()
+
+	builder := pipeline.Serial(
+		pipeline.Command("echo", "This is the first step").Name("print-0a").Stdout("devnul"),
+		pipeline.Parallel(
+			pipeline.Command("echo", "This is the first parallel step").Name("paral-0").Stdout("devnul"),
+			pipeline.Command("echo", "This is the second parallel step").Name("paral-1").Stdout("devnul"),
+			pipeline.Command("echo", "This is the third parallel step").Name("paral-2").Stdout("devnul"),
+		),
+	).Name("test-pipeline-001")
+
+	// Programmatic and file based pipelines are the same.
+	assert.Equal(t, decode(t, "../testdata/test-pipeline-001.yaml"), builder.Node())
+
+	data, err := builder.YAML()
+	require.NoError(t, err)
+
+	var node pipeline.Node
+
+	// And they round-trip.
+	require.NoError(t, pipeline.FormatYAML.Unmarshal(data, &node))
+	assert.Equal(t, builder.Node(), node)
+}
+
+func TestBuilderYAMLWithTimes(t *testing.T) {
+	t.Parallel()
+
+	builder := pipeline.Command("sleep", "100").
+		Deadline(time.Date(2026, time.October, 19, 18, 30, 0, 0, time.UTC)).
+		Delay(1500 * time.Millisecond).
+		OnExit("restart").
+		Timeout(90 * time.Second)
+
+	data, err := builder.YAML()
+	require.NoError(t, err)
+
+	assert.Equal(t, `args: ["100"]
+path: sleep
+deadline: 2026-10-19T18:30:00Z
+delay: 1.5s
+onExit: restart
+timeout: 1m30s
+`, string(data))
+}
+
+func TestBuilderJSON(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		builder *pipeline.Builder
+		want    string
+	}{
+		"empty configurations": {
+			builder: pipeline.Serial(pipeline.Command("true")),
+			want:    `{"steps":[{"path":"true"}]}`,
+		},
+		"configurations": {
+			builder: pipeline.Serial(pipeline.Command("true").OnFailure(pipeline.Command("false"))).
+				Log(pipeline.LogConfig{Level: "debug"}),
+			want: `{"steps":[{"path":"true","hooks":{"onFailure":{"path":"false"}}}],"log":{"level":"debug"}}`,
+		},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := json.Marshal(tt.builder.Node())
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(data))
+
+			var node pipeline.Node
+
+			// The JSON round-trips.
+			require.NoError(t, pipeline.FormatJSON.Unmarshal(data, &node))
+			assert.Equal(t, tt.builder.Node(), node)
+		})
+	}
+}
+
+func TestBuilderCopies(t *testing.T) {
+	t.Parallel()
+
+	builder := pipeline.Serial(pipeline.Command("true").Name("step")).Log(pipeline.LogConfig{Disabled: true})
+
+	node, err := builder.Build()
+	require.NoError(t, err)
+
+	// Running (or altering) the built tree leaves the builder as it was.
+	node.Steps[0].Name = "altered"
+	require.NoError(t, node.Run(context.TODO()))
+
+	assert.Equal(t, "step", builder.Node().Steps[0].Name)
+	assert.Equal(t, pipeline.Command("true").Name("step").Node(), builder.Node().Steps[0])
+}
@@ -0,0 +1,391 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// cacheDir is the default directory of the cache records, relative to the working directory.
+const cacheDir = ".pipeline/cache"
+
+var (
+	// errMissingOutput is returned when a command succeeded without creating one of its outputs.
+	errMissingOutput = errors.New("missing output")
+	// errRecursiveGlob is returned when ** is not a whole path element of a pattern.
+	errRecursiveGlob = errors.New("** must be a whole path element, eg: src/**/*.go")
+	// envName matches the environment variable names declared as inputs.
+	envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+type (
+	// CacheConfig describes the step cache of a pipeline (only relevant for the root node).
+	CacheConfig struct {
+		// Dir holds the cache records, .pipeline/cache (in the working directory) by default.
+		Dir string `json:"dir,omitempty" toml:"dir,omitempty" yaml:"dir,omitempty"`
+		// Restore keeps a copy of the outputs of the cached commands, restored when they are missing.
+		Restore bool `json:"restore,omitempty" toml:"restore,omitempty" yaml:"restore,omitempty"`
+	}
+
+	// Cache skips the commands that are up to date, make-style: a command declaring inputs or outputs is skipped when
+	// it already succeeded with the same definition (command, arguments, streams...), input files and variables, and
+	// its outputs still exist. Records are content-addressed: they are named after the hash of all of the above.
+	//
+	// Inputs are glob patterns (see filepath.Match), where a ** path element matches any number of directories (eg:
+	// src/**/*.go), or environment variables ($NAME): the rest of the environment is not part of the hash.
+	Cache struct {
+		dir     string
+		restore bool
+	}
+
+	// cacheRecord is the content of a cache record.
+	cacheRecord struct {
+		Node     string    `json:"node"`
+		Finished time.Time `json:"finished"`
+		Outputs  []string  `json:"outputs,omitempty"`
+	}
+)
+
+// NewCache returns the cache described by the configuration.
+func NewCache(config CacheConfig) *Cache {
+	if config.Dir == "" {
+		config.Dir = cacheDir
+	}
+
+	return &Cache{dir: config.Dir, restore: config.Restore}
+}
+
+// WithCache skips the commands that are up to date (nil disables the cache, running every command).
+func (n *Node) WithCache(c *Cache) *Node {
+	n.cache = c
+
+	return n
+}
+
+// lookup returns the cache key of the node (empty when the node is not cached) and whether it is up to date, after
+// restoring its missing outputs if possible.
+func (c *Cache) lookup(n *Node) (string, bool) {
+	if c == nil || !n.IsCommand() || (len(n.Inputs) == 0 && len(n.Outputs) == 0) {
+		return "", false
+	}
+
+	key, err := n.cacheKey()
+	if err != nil {
+		n.logger.Warn("cache disabled", zap.Error(err))
+
+		return "", false
+	}
+
+	if _, err := os.Stat(c.record(key)); err != nil {
+		return key, false
+	}
+
+	for i, output := range n.Outputs {
+		if _, err := os.Stat(output); err == nil {
+			continue
+		}
+
+		if !c.restore {
+			return key, false
+		}
+
+		if err := copyPath(c.object(key, i), output); err != nil {
+			return key, false
+		}
+	}
+
+	return key, true
+}
+
+// store records the success of the node, along with a copy of its outputs if restoring is enabled.
+func (c *Cache) store(n *Node, key string) error {
+	if c == nil || key == "" {
+		return nil
+	}
+
+	for _, output := range n.Outputs {
+		if _, err := os.Stat(output); err != nil {
+			return errors.Wrap(errMissingOutput, output)
+		}
+	}
+
+	if c.restore {
+		if err := os.RemoveAll(filepath.Join(c.dir, key)); err != nil {
+			return errors.Wrap(err, "cannot store outputs")
+		}
+
+		for i, output := range n.Outputs {
+			if err := copyPath(output, c.object(key, i)); err != nil {
+				return errors.Wrap(err, "cannot store outputs")
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(cacheRecord{Node: n.Path(), Finished: time.Now(), Outputs: n.Outputs}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "cannot encode record")
+	}
+
+	if err := os.MkdirAll(c.dir, stateDirPerm); err != nil {
+		return errors.Wrap(err, "cannot store record")
+	}
+
+	return errors.Wrap(os.WriteFile(c.record(key), data, statePerm), "cannot store record")
+}
+
+// record returns the file recording the success of a key.
+func (c *Cache) record(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// object returns the copy of the i-th output of a key.
+func (c *Cache) object(key string, i int) string {
+	return filepath.Join(c.dir, key, strconv.Itoa(i))
+}
+
+// cacheKey hashes the node definition (with the captured values filled in), the variables set by the pipeline (eg:
+// for the hooks), the variables declared as inputs and the input files (by path and content; the directories
+// matching an input pattern are hashed recursively).
+func (n *Node) cacheKey() (string, error) {
+	hash := sha256.New()
+
+	// The references to the captured values (eg: ${VERSION}) are only filled in when the command runs.
+	expanded := *n
+	expanded.Args = n.captures.expandList(n.Args)
+	expanded.Command = n.captures.expand(n.Command)
+	expanded.Stderr = n.captures.expandSinks(n.Stderr)
+	expanded.Stdout = n.captures.expandSinks(n.Stdout)
+
+	fmt.Fprintf(hash, "node %s\n", expanded.digest())
+
+	env := append([]string(nil), n.env...)
+	sort.Strings(env)
+
+	for _, item := range env {
+		fmt.Fprintf(hash, "env %q\n", item)
+	}
+
+	for _, pattern := range n.Inputs {
+		if name, ok := envInput(pattern); ok {
+			value, set := os.LookupEnv(name)
+			fmt.Fprintf(hash, "input %q %t %q\n", pattern, set, value)
+
+			continue
+		}
+
+		matches, err := glob(pattern)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid input %s", pattern)
+		}
+
+		fmt.Fprintf(hash, "input %q\n", pattern)
+
+		for _, match := range matches {
+			if err := hashTree(hash, match); err != nil {
+				return "", errors.Wrapf(err, "cannot hash input %s", match)
+			}
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// envInput returns the name of the environment variable declared by an input ($NAME), if any.
+func envInput(input string) (string, bool) {
+	if !strings.HasPrefix(input, "$") {
+		return "", false
+	}
+
+	return input[1:], true
+}
+
+// glob returns the paths matching a pattern, like filepath.Glob, where a ** path element matches any number of
+// directories. The directories matching such a pattern are returned without their content.
+func glob(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern) // nolint:wrapcheck // wrapped by the caller
+	}
+
+	if err := checkGlob(pattern); err != nil {
+		return nil, err
+	}
+
+	elems := strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/")
+
+	// The walk starts from the leading elements without metacharacters.
+	base := 0
+	for base < len(elems) && !strings.ContainsAny(elems[base], `*?[\`) {
+		base++
+	}
+
+	root := strings.Join(elems[:base], "/")
+
+	switch {
+	case base == 0:
+		root = "."
+	case root == "":
+		root = "/"
+	}
+
+	root = filepath.FromSlash(root)
+
+	var matches []string
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case os.IsNotExist(err):
+			return nil
+		case err != nil:
+			return err
+		case path == root:
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if !matchElems(elems[base:], strings.Split(filepath.ToSlash(rel), "/")) {
+			return nil
+		}
+
+		matches = append(matches, path)
+
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	return matches, err // nolint:wrapcheck // wrapped by the caller
+}
+
+// matchElems returns whether the path elements match the pattern elements, where ** matches any number of elements.
+func matchElems(pattern, path []string) bool {
+	switch {
+	case len(pattern) == 0:
+		return len(path) == 0
+	case pattern[0] == "**":
+		for i := 0; i <= len(path); i++ {
+			if matchElems(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+
+		return false
+	case len(path) == 0:
+		return false
+	}
+
+	ok, _ := filepath.Match(pattern[0], path[0])
+
+	return ok && matchElems(pattern[1:], path[1:])
+}
+
+// checkGlob checks a glob pattern: ** is only supported as a whole path element.
+func checkGlob(pattern string) error {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return err // nolint:wrapcheck // wrapped by the caller
+	}
+
+	for _, elem := range strings.Split(filepath.ToSlash(pattern), "/") {
+		if elem != "**" && strings.Contains(elem, "**") {
+			return errRecursiveGlob
+		}
+	}
+
+	return nil
+}
+
+// hashTree writes the path and content of the files of a tree into the hash, in lexical order.
+func hashTree(hash io.Writer, root string) error {
+	// nolint:wrapcheck // wrapped by the caller
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		sum := sha256.New()
+		if _, err := io.Copy(sum, f); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(hash, "file %q %x\n", path, sum.Sum(nil))
+
+		return nil
+	})
+}
+
+// copyPath copies a file, or a directory recursively, keeping the file permissions.
+func copyPath(src, dst string) error {
+	// nolint:wrapcheck // wrapped by the caller
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700) // nolint:gomnd // keep the directory writable
+		case !info.Mode().IsRegular():
+			return nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), stateDirPerm); err != nil {
+			return err
+		}
+
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+// copyFile copies a regular file.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err // nolint:wrapcheck // wrapped by the caller
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm) // nolint:nosnakecase // go package
+	if err != nil {
+		return err // nolint:wrapcheck // wrapped by the caller
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+
+		return err // nolint:wrapcheck // wrapped by the caller
+	}
+
+	return out.Close() // nolint:wrapcheck // wrapped by the caller
+}
@@ -0,0 +1,166 @@
+package pipeline_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "src", "input.txt")
+	output := filepath.Join(dir, "out", "output.txt")
+	counter := filepath.Join(dir, "counter")
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(input), 0o700))
+	require.NoError(t, os.WriteFile(input, []byte("v1"), 0o600))
+
+	script := "mkdir -p " + filepath.Dir(output) + " && cat " + input + " > " + output + " && echo run >> " + counter
+
+	// run builds the output from the input, and returns the status of the command and how many times it ran so far.
+	run := func(cache *pipeline.Cache) (pipeline.Status, int) {
+		node, err := pipeline.Serial(
+			pipeline.Command("sh", "-c", script).Name("gen").
+				Inputs(filepath.Join(dir, "src", "*")).Outputs(filepath.Dir(output)),
+		).Name("root").Log(pipeline.LogConfig{Disabled: true}).Build()
+		require.NoError(t, err)
+
+		report, err := node.WithCache(cache).RunWithResult(context.TODO())
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(counter)
+		require.NoError(t, err)
+
+		return report.Children[0].Status, len(strings.Fields(string(data)))
+	}
+
+	restoring := pipeline.NewCache(pipeline.CacheConfig{Dir: filepath.Join(dir, "cache"), Restore: true})
+
+	status, runs := run(restoring)
+	assert.Equal(t, pipeline.StatusSuccess, status)
+	assert.Equal(t, 1, runs)
+
+	// Up to date.
+	status, runs = run(restoring)
+	assert.Equal(t, pipeline.StatusSkipped, status)
+	assert.Equal(t, 1, runs)
+
+	// Restored from the cache.
+	require.NoError(t, os.RemoveAll(filepath.Dir(output)))
+
+	status, runs = run(restoring)
+	assert.Equal(t, pipeline.StatusSkipped, status)
+	assert.Equal(t, 1, runs)
+	assert.FileExists(t, output)
+
+	// Changed input.
+	require.NoError(t, os.WriteFile(input, []byte("v2"), 0o600))
+
+	status, runs = run(restoring)
+	assert.Equal(t, pipeline.StatusSuccess, status)
+	assert.Equal(t, 2, runs)
+
+	// Missing output, without copies.
+	require.NoError(t, os.RemoveAll(filepath.Dir(output)))
+
+	status, runs = run(pipeline.NewCache(pipeline.CacheConfig{Dir: filepath.Join(dir, "cache"), Restore: false}))
+	assert.Equal(t, pipeline.StatusSuccess, status)
+	assert.Equal(t, 3, runs)
+
+	// No cache.
+	status, runs = run(nil)
+	assert.Equal(t, pipeline.StatusSuccess, status)
+	assert.Equal(t, 4, runs)
+}
+
+// nolint:paralleltest // sets environment variables
+func TestCacheInputs(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "src", "a", "b", "input.txt")
+	counter := filepath.Join(dir, "counter")
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(nested), 0o700))
+	require.NoError(t, os.WriteFile(nested, []byte("v1"), 0o600))
+
+	cache := pipeline.NewCache(pipeline.CacheConfig{Dir: filepath.Join(dir, "cache"), Restore: false})
+
+	// run returns the status of the command.
+	run := func() pipeline.Status {
+		node, err := pipeline.Serial(
+			pipeline.Command("sh", "-c", "echo run >> "+counter).Name("gen").
+				Inputs(filepath.Join(dir, "src", "**", "*.txt"), "$PIPELINE_TEST_FLAGS"),
+		).Name("root").Log(pipeline.LogConfig{Disabled: true}).Build()
+		require.NoError(t, err)
+
+		report, err := node.WithCache(cache).RunWithResult(context.TODO())
+		require.NoError(t, err)
+
+		return report.Children[0].Status
+	}
+
+	t.Setenv("PIPELINE_TEST_FLAGS", "-v")
+	assert.Equal(t, pipeline.StatusSuccess, run())
+	assert.Equal(t, pipeline.StatusSkipped, run())
+
+	// The rest of the environment is not an input.
+	t.Setenv("PIPELINE_TEST_OTHER", "changed")
+	assert.Equal(t, pipeline.StatusSkipped, run())
+
+	// ** matches the nested directories.
+	require.NoError(t, os.WriteFile(nested, []byte("v2"), 0o600))
+	assert.Equal(t, pipeline.StatusSuccess, run())
+	assert.Equal(t, pipeline.StatusSkipped, run())
+
+	// The declared variables are inputs.
+	t.Setenv("PIPELINE_TEST_FLAGS", "-race")
+	assert.Equal(t, pipeline.StatusSuccess, run())
+	assert.Equal(t, pipeline.StatusSkipped, run())
+}
+
+func TestCacheCaptures(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	version := filepath.Join(dir, "version")
+	output := filepath.Join(dir, "output.txt")
+
+	require.NoError(t, os.WriteFile(input, []byte("input"), 0o600))
+
+	cache := pipeline.NewCache(pipeline.CacheConfig{Dir: filepath.Join(dir, "cache"), Restore: false})
+
+	// run releases the captured version, and returns the status of the release.
+	run := func(v string) pipeline.Status {
+		require.NoError(t, os.WriteFile(version, []byte(v), 0o600))
+
+		node, err := pipeline.Serial(
+			pipeline.Command("cat", version).Name("version").Capture("VERSION", false),
+			pipeline.Command("sh", "-c", `echo "$1" > `+output, "sh", "${VERSION}").Name("release").
+				Inputs(input).Outputs(output),
+		).Name("root").Log(pipeline.LogConfig{Disabled: true}).Build()
+		require.NoError(t, err)
+
+		report, err := node.WithCache(cache).RunWithResult(context.TODO())
+		require.NoError(t, err)
+
+		return report.Children[1].Status
+	}
+
+	assert.Equal(t, pipeline.StatusSuccess, run("v1"))
+	assert.Equal(t, pipeline.StatusSkipped, run("v1"))
+
+	// The captured value changed: the command is not up to date.
+	assert.Equal(t, pipeline.StatusSuccess, run("v2"))
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, "v2\n", string(data))
+}
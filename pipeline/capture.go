@@ -0,0 +1,279 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// captureLimit is the maximum size of a captured output; the rest is discarded.
+const captureLimit = 64 << 10
+
+var (
+	// captureName matches the valid capture names (a variable name, without dots).
+	captureName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// errCaptureNotCommand is returned when capture is set on a node that is not a command.
+	errCaptureNotCommand = errors.New("capture is only supported by commands")
+	// errCaptureName is returned when capture is not a valid variable name.
+	errCaptureName = errors.New("invalid capture name")
+)
+
+type (
+	// captures holds the outputs captured by the commands of a run, shared by the whole tree.
+	captures struct {
+		mtx    sync.Mutex
+		values map[string]capturedValue
+	}
+
+	// capturedValue is the trimmed output of a command, along with its JSON decoding (if requested).
+	capturedValue struct {
+		text    string
+		decoded interface{}
+		isJSON  bool
+	}
+
+	// captureBuffer keeps the first captureLimit bytes written into it.
+	captureBuffer struct {
+		mtx       sync.Mutex
+		buf       bytes.Buffer
+		truncated bool
+	}
+)
+
+// newCaptureBuffer returns an empty buffer.
+func newCaptureBuffer() *captureBuffer {
+	return &captureBuffer{mtx: sync.Mutex{}, buf: bytes.Buffer{}, truncated: false}
+}
+
+// Write implements io.Writer, never failing.
+func (b *captureBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if room := captureLimit - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:room])
+		b.truncated = true
+
+		return len(p), nil
+	}
+
+	b.buf.Write(p)
+
+	return len(p), nil
+}
+
+// reset discards the output of a previous process.
+func (b *captureBuffer) reset() {
+	if b == nil {
+		return
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.buf.Reset()
+	b.truncated = false
+}
+
+// storeCapture saves the output of a command that succeeded, as ${NAME} and ${steps.<name>.stdout} (plus
+// ${NAME.field} and ${steps.<name>.json.field} when parsed as JSON).
+func (n *Node) storeCapture() error {
+	if n.captured == nil {
+		return nil
+	}
+
+	n.captured.mtx.Lock()
+	text, truncated := strings.TrimSpace(n.captured.buf.String()), n.captured.truncated
+	n.captured.mtx.Unlock()
+
+	if truncated {
+		n.logger.Warn("captured output truncated", zap.Int("limit", captureLimit))
+	}
+
+	value := capturedValue{text: text, decoded: nil, isJSON: false}
+
+	if n.CaptureJSON {
+		if err := json.Unmarshal([]byte(text), &value.decoded); err != nil {
+			return errors.Wrapf(err, "capture %s: invalid JSON", n.Capture)
+		}
+
+		value.isJSON = true
+	}
+
+	n.captures.set(value, n.Capture, "steps."+n.ID())
+
+	return nil
+}
+
+// captureStream returns the default standard output of the node: the captured output is not printed, unless
+// redirected.
+func (n *Node) captureStream() string {
+	if n.Capture != "" {
+		return "devnul"
+	}
+
+	return "stdout"
+}
+
+// captureSummary describes where the output is captured.
+func (n *Node) captureSummary() string {
+	if n.CaptureJSON {
+		return fmt.Sprintf("${%s} (json), ${steps.%s.json}", n.Capture, n.ID())
+	}
+
+	return fmt.Sprintf("${%s}, ${steps.%s.stdout}", n.Capture, n.ID())
+}
+
+// set stores a value under some names.
+func (c *captures) set(value capturedValue, names ...string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, name := range names {
+		c.values[name] = value
+	}
+}
+
+// lookup resolves a reference to a captured output: NAME, NAME.field..., steps.<name>.stdout or
+// steps.<name>.json.field...; structured JSON values are rendered as JSON.
+func (c *captures) lookup(ref string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	parts := strings.Split(ref, ".")
+	name, fields, raw := parts[0], parts[1:], len(parts) == 1
+
+	if name == "steps" {
+		if len(parts) < 3 { // nolint:gomnd // steps.<name>.<stream>
+			return "", false
+		}
+
+		name, fields, raw = "steps."+parts[1], parts[3:], parts[2] == "stdout"
+
+		if (parts[2] != "stdout" && parts[2] != "json") || (raw && len(fields) > 0) {
+			return "", false
+		}
+	}
+
+	c.mtx.Lock()
+	value, ok := c.values[name]
+	c.mtx.Unlock()
+
+	switch {
+	case !ok:
+		return "", false
+	case raw:
+		return value.text, true
+	case !value.isJSON:
+		return "", false
+	}
+
+	return jsonField(value.decoded, fields)
+}
+
+// jsonField returns a field of a decoded JSON value (strings as they are, other values as JSON).
+func jsonField(value interface{}, fields []string) (string, bool) {
+	for _, field := range fields {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+
+		if value, ok = object[field]; !ok {
+			return "", false
+		}
+	}
+
+	if str, ok := value.(string); ok {
+		return str, true
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+
+	return string(data), true
+}
+
+// expand replaces the references to the captured outputs of a string (others are left as they are).
+func (c *captures) expand(str string) string {
+	if c == nil {
+		return str
+	}
+
+	return expand(str, c.lookup)
+}
+
+// expandList returns a copy of a list, with the references to the captured outputs replaced.
+func (c *captures) expandList(list []string) []string {
+	if c == nil || list == nil {
+		return list
+	}
+
+	expanded := make([]string, len(list))
+	for i := range list {
+		expanded[i] = c.expand(list[i])
+	}
+
+	return expanded
+}
+
+// expandSinks returns a copy of the sinks of a stream, with the references to the captured outputs replaced.
+func (c *captures) expandSinks(sinks subprocess.Sinks) subprocess.Sinks {
+	if c == nil || sinks == nil {
+		return sinks
+	}
+
+	expanded := make(subprocess.Sinks, len(sinks))
+	for i, sink := range sinks {
+		sink.Path, sink.Prefix = c.expand(sink.Path), c.expand(sink.Prefix)
+		expanded[i] = sink
+	}
+
+	return expanded
+}
+
+// expandWith returns a copy of the plugin settings, with the references to the captured outputs replaced.
+func (c *captures) expandWith(with map[string]interface{}) map[string]interface{} {
+	if c == nil || with == nil {
+		return with
+	}
+
+	expanded := make(map[string]interface{}, len(with))
+	for key, value := range with {
+		expanded[key] = expandValue(copyValue(value), c.lookup)
+	}
+
+	return expanded
+}
+
+// copyValue returns a deep copy of the lists and maps of a decoded value.
+func copyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i := range value {
+			list[i] = copyValue(value[i])
+		}
+
+		return list
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key := range value {
+			object[key] = copyValue(value[key])
+		}
+
+		return object
+	default:
+		return value
+	}
+}
@@ -0,0 +1,41 @@
+package pipeline_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapture(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+
+	node, err := pipeline.Serial(
+		pipeline.Command("echo", "  v1.2.3  ").Name("version").Capture("VERSION", false),
+		pipeline.Command("echo", `{"image": {"tag": "latest", "ports": [80]}}`).Name("meta").Capture("META", true),
+		pipeline.Command("sh", "-c", `printf '%s\n' "$@" > `+out, "sh",
+			"${VERSION}", "${steps.version.stdout}", "${META.image.tag}", "${steps.meta.json.image.ports}",
+			"${META.nope}", "${steps.other.stdout}", "${steps.version.json}",
+		).OnSuccess(pipeline.Command("sh", "-c", "echo ${VERSION} >> "+out)),
+	).Name("root").Log(pipeline.LogConfig{Disabled: true}).Build()
+	require.NoError(t, err)
+
+	require.NoError(t, node.Run(context.TODO()))
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"v1.2.3", "v1.2.3", "latest", "[80]", "${META.nope}", "${steps.other.stdout}", "${steps.version.json}", "v1.2.3",
+	}, strings.Split(strings.TrimSpace(string(data)), "\n"))
+
+	// The arguments of the definition are left as they are, for the next runs.
+	assert.Equal(t, "${VERSION}", node.Steps[2].Args[3])
+}
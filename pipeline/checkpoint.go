@@ -0,0 +1,215 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// checkpointDir is the directory of the default state files, relative to the working directory.
+	checkpointDir = ".pipeline/state"
+	// stateDirPerm is the permission of new state (and cache) directories.
+	stateDirPerm = 0o755
+	// statePerm is the permission of state (and cache) files.
+	statePerm = 0o644
+)
+
+// unsafeFileChars matches the characters replaced in the state file names.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+type (
+	// Checkpoint persists the nodes that succeeded into a state file, so that a failed run can be resumed (see
+	// Resume). The state belongs to a pipeline, by name, and records every node by path along with the hash of its
+	// definition. It is saved after every node, and removed once the whole pipeline succeeds.
+	Checkpoint struct {
+		mtx   sync.Mutex
+		file  string
+		state checkpointState
+	}
+
+	// checkpointState is the content of a state file.
+	checkpointState struct {
+		Pipeline string                     `json:"pipeline"`
+		Nodes    map[string]checkpointEntry `json:"nodes"`
+	}
+
+	// checkpointEntry records a node that succeeded.
+	checkpointEntry struct {
+		Hash     string    `json:"hash"`
+		Finished time.Time `json:"finished"`
+	}
+)
+
+// CheckpointFile returns the default state file of a pipeline: .pipeline/state/<name>.json, in the working directory.
+func CheckpointFile(pipeline string) string {
+	return filepath.Join(checkpointDir, unsafeFileChars.ReplaceAllString(pipeline, "_")+".json")
+}
+
+// NewCheckpoint returns an empty state for the pipeline, saved into file (the previous state, if any, is overwritten
+// as soon as a node finishes).
+func NewCheckpoint(file, pipeline string) *Checkpoint {
+	return &Checkpoint{
+		mtx:   sync.Mutex{},
+		file:  file,
+		state: checkpointState{Pipeline: pipeline, Nodes: make(map[string]checkpointEntry)},
+	}
+}
+
+// LoadCheckpoint reads the state of the pipeline from file. A missing file, or the state of another pipeline, is an
+// empty state.
+func LoadCheckpoint(file, pipeline string) (*Checkpoint, error) {
+	c := NewCheckpoint(file, pipeline)
+
+	data, err := os.ReadFile(file)
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return c, nil
+	case err != nil:
+		return nil, errors.Wrap(err, "cannot read state")
+	}
+
+	var state checkpointState
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Wrapf(err, "invalid state %s", file)
+	}
+
+	if state.Pipeline == pipeline && state.Nodes != nil {
+		c.state = state
+	}
+
+	return c, nil
+}
+
+// Resume skips the serial steps that succeeded in a previous run with an unchanged definition, as recorded by the
+// checkpoint: every serial group resumes from its first step that did not succeed, and runs the steps after it
+// whether they succeeded or not. Skipped steps are reported like the ones left out by Select. It returns the number
+// of resumed steps.
+func (n *Node) Resume(c *Checkpoint) int {
+	count := n.resume(c, n.ID())
+
+	n.logger.Info("resume", zap.String("state", c.file), zap.Int("succeeded", count))
+
+	return count
+}
+
+// resume skips the succeeded steps of the subtree and returns how many.
+func (n *Node) resume(c *Checkpoint, path string) int {
+	if n.skipped {
+		return 0
+	}
+
+	count := 0
+
+	children := n.Children()
+	for i := range children {
+		child, childPath := &children[i], n.ChildPath(path, i)
+
+		if n.IsParallel() {
+			count += child.resume(c, childPath)
+
+			continue
+		}
+
+		if child.skipped {
+			continue
+		}
+
+		if !c.succeeded(childPath, child) {
+			return count + child.resume(c, childPath)
+		}
+
+		child.skipAll()
+		count++
+	}
+
+	return count
+}
+
+// WithCheckpoint records the nodes that succeeded into the checkpoint (nil disables it).
+func (n *Node) WithCheckpoint(c *Checkpoint) *Node {
+	n.checkpoint = c
+
+	return n
+}
+
+// record saves the outcome of a node: a success is recorded (the root one removes the state file, since there is
+// nothing left to resume), a failure forgets any previous success.
+func (c *Checkpoint) record(path string, n *Node, err error) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	switch {
+	case path == c.state.Pipeline && err == nil:
+		c.state.Nodes = make(map[string]checkpointEntry)
+
+		if err := os.Remove(c.file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.Wrap(err, "cannot remove state")
+		}
+
+		return nil
+	case err == nil:
+		c.state.Nodes[path] = checkpointEntry{Hash: n.digest(), Finished: time.Now()}
+	default:
+		delete(c.state.Nodes, path)
+	}
+
+	return c.save()
+}
+
+// succeeded returns whether the node succeeded with its current definition.
+func (c *Checkpoint) succeeded(path string, n *Node) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entry, ok := c.state.Nodes[path]
+
+	return ok && entry.Hash != "" && entry.Hash == n.digest()
+}
+
+// save writes the state file atomically (the state is locked by the caller).
+func (c *Checkpoint) save() error {
+	data, err := json.MarshalIndent(c.state, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "cannot encode state")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.file), stateDirPerm); err != nil {
+		return errors.Wrap(err, "cannot save state")
+	}
+
+	tmp := c.file + ".tmp"
+
+	if err := os.WriteFile(tmp, data, statePerm); err != nil {
+		return errors.Wrap(err, "cannot save state")
+	}
+
+	return errors.Wrap(os.Rename(tmp, c.file), "cannot save state")
+}
+
+// digest returns the hash of the node definition, its children and hooks included (after the variable expansion).
+func (n *Node) digest() string {
+	data, err := json.Marshal(n)
+	if err != nil {
+		// Never matches a recorded hash, so the node runs again.
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,101 @@
+package pipeline_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResume(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	state := filepath.Join(dir, "state", "root.json")
+	counter := filepath.Join(dir, "counter")
+	marker := filepath.Join(dir, "marker")
+
+	// build counts the runs of the first step, and fails on the second one until the marker exists.
+	build := func(first string) *pipeline.Node {
+		node, err := pipeline.Serial(
+			pipeline.Command("sh", "-c", "echo "+first+" >> "+counter).Name("first"),
+			pipeline.Serial(
+				pipeline.Command("true").Name("prepare"),
+				pipeline.Command("test", "-f", marker).Name("check"),
+			).Name("second"),
+			pipeline.Command("true").Name("third"),
+		).Name("root").Log(pipeline.LogConfig{Disabled: true}).Build()
+		require.NoError(t, err)
+
+		return node
+	}
+
+	runs := func() []string {
+		data, err := os.ReadFile(counter)
+		require.NoError(t, err)
+
+		return strings.Fields(string(data))
+	}
+
+	node := build("a").WithCheckpoint(pipeline.NewCheckpoint(state, "root"))
+	require.Error(t, node.Run(context.TODO()))
+	assert.FileExists(t, state)
+
+	// The first step and the first step of the second one succeeded.
+	checkpoint, err := pipeline.LoadCheckpoint(state, "root")
+	require.NoError(t, err)
+
+	node = build("a")
+	assert.Equal(t, 2, node.Resume(checkpoint))
+	assert.Equal(t, []string{"root", "root/second", "root/second/check", "root/third"}, running(node, node.ID()))
+
+	// A changed definition runs again, along with the steps after it.
+	node = build("b")
+	assert.Zero(t, node.Resume(checkpoint))
+	assert.Equal(t, []string{
+		"root", "root/first", "root/second", "root/second/prepare", "root/second/check", "root/third",
+	}, running(node, node.ID()))
+
+	// Another pipeline does not share the state.
+	other, err := pipeline.LoadCheckpoint(state, "other")
+	require.NoError(t, err)
+
+	node = build("a")
+	assert.Zero(t, node.Resume(other))
+
+	require.NoError(t, os.WriteFile(marker, nil, 0o600))
+
+	node = build("a").WithCheckpoint(checkpoint)
+	node.Resume(checkpoint)
+
+	report, err := node.RunWithResult(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, pipeline.StatusSkipped, report.Children[0].Status)
+	assert.Equal(t, pipeline.StatusSuccess, report.Children[2].Status)
+	assert.Equal(t, []string{"a"}, runs())
+	// Nothing is left to resume.
+	assert.NoFileExists(t, state)
+}
+
+func TestLoadCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	checkpoint, err := pipeline.LoadCheckpoint(filepath.Join(dir, "nope.json"), "root")
+	require.NoError(t, err)
+	assert.NotNil(t, checkpoint)
+
+	invalid := filepath.Join(dir, "invalid.json")
+	require.NoError(t, os.WriteFile(invalid, []byte("{"), 0o600))
+
+	_, err = pipeline.LoadCheckpoint(invalid, "root")
+	assert.ErrorContains(t, err, "invalid state")
+
+	assert.Equal(t, filepath.Join(".pipeline", "state", "my_pipeline.json"), pipeline.CheckpointFile("my pipeline"))
+}
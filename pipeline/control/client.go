@@ -0,0 +1,141 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"github.com/pkg/errors"
+)
+
+// errAPI is returned when the API replies with an error.
+var errAPI = errors.New("control API error")
+
+// Client talks to the control API of a running pipeline.
+type Client struct {
+	base string
+	http *http.Client
+}
+
+// NewClient returns a client for addr (host:port, or unix:path for a Unix socket).
+func NewClient(addr string) *Client {
+	if !strings.HasPrefix(addr, UnixPrefix) {
+		return &Client{base: "http://" + addr, http: &http.Client{}} // nolint:exhaustruct // defaults
+	}
+
+	socket := strings.TrimPrefix(addr, UnixPrefix)
+	transport := &http.Transport{ // nolint:exhaustruct // defaults
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket) // nolint:exhaustruct // defaults
+		},
+	}
+
+	return &Client{base: "http://pipeline", http: &http.Client{Transport: transport}} // nolint:exhaustruct // defaults
+}
+
+// Tree returns the live node tree.
+func (c *Client) Tree(ctx context.Context) (*pipeline.NodeState, error) {
+	var state pipeline.NodeState
+
+	return &state, c.do(ctx, http.MethodGet, "/nodes", &state)
+}
+
+// Node returns a node, by path or by unique name.
+func (c *Client) Node(ctx context.Context, node string) (*pipeline.NodeState, error) {
+	var state pipeline.NodeState
+
+	return &state, c.do(ctx, http.MethodGet, "/nodes/"+escape(node), &state)
+}
+
+// Act applies an action (stop, start, restart, pause or resume) to a node and returns its new state.
+func (c *Client) Act(ctx context.Context, node, action string) (*pipeline.NodeState, error) {
+	var state pipeline.NodeState
+
+	return &state, c.do(ctx, http.MethodPost, "/nodes/"+escape(node)+"/"+action, &state)
+}
+
+// Signal sends a signal (name or number) to a command.
+func (c *Client) Signal(ctx context.Context, node, signal string) (*pipeline.NodeState, error) {
+	var state pipeline.NodeState
+
+	path := "/nodes/" + escape(node) + "/signal?signal=" + url.QueryEscape(signal)
+
+	return &state, c.do(ctx, http.MethodPost, path, &state)
+}
+
+// Logs copies the last output of a command into w, then the next one until the context is done if follow is set.
+func (c *Client) Logs(ctx context.Context, node string, follow bool, w io.Writer) error {
+	path := "/logs/" + escape(node)
+	if follow {
+		path += "?follow=true"
+	}
+
+	res, err := c.request(ctx, http.MethodGet, path)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if _, err := io.Copy(w, res.Body); err != nil && ctx.Err() == nil {
+		return errors.Wrap(err, "cannot read logs")
+	}
+
+	return nil
+}
+
+// do sends a request and decodes the JSON response into out.
+func (c *Client) do(ctx context.Context, method, path string, out interface{}) error {
+	res, err := c.request(ctx, method, path)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	return errors.Wrap(json.NewDecoder(res.Body).Decode(out), "invalid response")
+}
+
+// request sends a request, turning error responses into errors.
+func (c *Client) request(ctx context.Context, method, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create request")
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot reach the pipeline")
+	}
+
+	if res.StatusCode == http.StatusOK {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+
+	var body struct {
+		Error string `json:"error"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil || body.Error == "" {
+		return nil, errors.Wrap(errAPI, res.Status)
+	}
+
+	return nil, errors.Wrap(errAPI, body.Error)
+}
+
+// escape escapes the segments of a node path.
+func escape(node string) string {
+	parts := strings.Split(node, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,77 @@
+package control_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/control"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	node, err := pipeline.Parallel(
+		pipeline.Command("sh", "-c", "echo ready; sleep 10").Name("daemon-1"),
+	).Name("root").Log(pipeline.LogConfig{Disabled: true}).Build()
+	require.NoError(t, err)
+
+	supervisor := pipeline.NewSupervisor(node)
+	node.WithSupervisor(supervisor)
+
+	socket := control.UnixPrefix + filepath.Join(t.TempDir(), "pipeline.sock")
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan error, 2)
+
+	go func() { done <- node.Run(ctx) }()
+	go func() { done <- control.Serve(ctx, socket, supervisor) }()
+
+	defer func() {
+		cancel()
+		<-done
+		<-done
+	}()
+
+	client := control.NewClient(socket)
+
+	require.Eventually(t, func() bool {
+		state, err := client.Node(context.TODO(), "daemon-1")
+
+		return err == nil && state.PID != 0
+	}, time.Second, 10*time.Millisecond)
+
+	tree, err := client.Tree(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, "root", tree.Path)
+	require.Len(t, tree.Children, 1)
+	assert.Equal(t, "root/daemon-1", tree.Children[0].Path)
+
+	state, err := client.Act(context.TODO(), "daemon-1", "pause")
+	require.NoError(t, err)
+	assert.True(t, state.Paused)
+
+	_, err = client.Act(context.TODO(), "nope", "stop")
+	assert.EqualError(t, err, "nope: unknown node: control API error")
+
+	_, err = client.Signal(context.TODO(), "daemon-1", "NOPE")
+	assert.EqualError(t, err, "NOPE: unknown signal: control API error")
+
+	require.Eventually(t, func() bool {
+		var buf bytes.Buffer
+
+		return client.Logs(context.TODO(), "daemon-1", false, &buf) == nil && buf.String() == "ready\n"
+	}, time.Second, 10*time.Millisecond)
+
+	var buf bytes.Buffer
+
+	follow, stop := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer stop()
+
+	assert.NoError(t, client.Logs(follow, "daemon-1", true, &buf))
+	assert.Equal(t, "ready\n", buf.String())
+}
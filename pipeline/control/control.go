@@ -0,0 +1,308 @@
+// package control exposes a running pipeline over a local HTTP API (on TCP or on a Unix socket).
+//
+// Endpoints:
+//
+//	GET  /nodes                 the live node tree
+//	GET  /nodes/{node}          a node, by path (eg: root/stage1/daemon-1) or by unique name (eg: daemon-1)
+//	POST /nodes/{node}/stop     stop a command (or plugin) until start
+//	POST /nodes/{node}/start    start a stopped node
+//	POST /nodes/{node}/restart  restart a node, regardless of its exit policy
+//	POST /nodes/{node}/pause    prevent a node from being restarted, until resume
+//	POST /nodes/{node}/resume   undo pause
+//	POST /nodes/{node}/signal   send ?signal=HUP (name or number) to the process group of a command
+//	GET  /logs/{node}           the last output of a command, then the next one with ?follow=true
+//
+// Errors are returned as {"error": "..."}.
+//
+// The API has no authentication: it is only served on loopback TCP addresses, or on Unix sockets (protected by their
+// file permissions).
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+	"github.com/pkg/errors"
+)
+
+const (
+	// UnixPrefix marks Unix socket addresses (eg: unix:/run/pipeline.sock).
+	UnixPrefix = "unix:"
+	// dialTimeout is how long Listen waits for an existing socket to answer.
+	dialTimeout = time.Second
+	// readHeaderTimeout protects the endpoint from slow clients.
+	readHeaderTimeout = 5 * time.Second
+	// shutdownTimeout is how long Serve waits for in-flight requests.
+	shutdownTimeout = 5 * time.Second
+)
+
+var (
+	// ErrNotLoopback is returned when listening on a TCP address reachable from other hosts.
+	ErrNotLoopback = errors.New("not a loopback address, the control API has no authentication")
+	// ErrSocketInUse is returned when listening on a Unix socket served by another process.
+	ErrSocketInUse = errors.New("socket in use")
+	// ErrUnknownAction is returned for unsupported POST actions.
+	ErrUnknownAction = errors.New("unknown action")
+	// ErrUnknownSignal is returned for unsupported signals.
+	ErrUnknownSignal = errors.New("unknown signal")
+	// errMethodNotAllowed is returned for unsupported HTTP methods.
+	errMethodNotAllowed = errors.New("method not allowed")
+	// errNotSocket is returned when the path of a Unix socket is taken by another kind of file.
+	errNotSocket = errors.New("not a socket")
+)
+
+// Handler returns the HTTP handler of the API.
+func Handler(supervisor *pipeline.Supervisor) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/nodes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			reply(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+
+			return
+		}
+
+		reply(w, http.StatusOK, supervisor.Tree())
+	})
+
+	mux.HandleFunc("/nodes/", func(w http.ResponseWriter, r *http.Request) {
+		node := strings.Trim(strings.TrimPrefix(r.URL.Path, "/nodes/"), "/")
+
+		switch r.Method {
+		case http.MethodGet:
+			state, err := supervisor.Node(node)
+			if err != nil {
+				reply(w, status(err), err)
+
+				return
+			}
+
+			reply(w, http.StatusOK, state)
+		case http.MethodPost:
+			idx := strings.LastIndex(node, "/")
+			if idx < 0 {
+				reply(w, http.StatusNotFound, errors.Wrap(ErrUnknownAction, "missing node or action"))
+
+				return
+			}
+
+			node, action := node[:idx], node[idx+1:]
+
+			if err := act(supervisor, node, action, r.URL.Query().Get("signal")); err != nil {
+				reply(w, status(err), err)
+
+				return
+			}
+
+			if state, err := supervisor.Node(node); err != nil {
+				reply(w, status(err), err)
+			} else {
+				reply(w, http.StatusOK, state)
+			}
+		default:
+			reply(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/logs/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			reply(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+
+			return
+		}
+
+		follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+		streamLogs(w, r, supervisor, strings.Trim(strings.TrimPrefix(r.URL.Path, "/logs/"), "/"), follow)
+	})
+
+	return mux
+}
+
+// streamLogs writes the output of a command, and the next one until the client leaves if follow is set.
+func streamLogs(w http.ResponseWriter, r *http.Request, supervisor *pipeline.Supervisor, node string, follow bool) {
+	history, next, stop, err := supervisor.Logs(node)
+	if err != nil {
+		reply(w, status(err), err)
+
+		return
+	}
+
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+
+	for data := history; ; {
+		if _, err := w.Write(data); err != nil || !follow {
+			return
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case data = <-next:
+		}
+	}
+}
+
+// Serve exposes the API on addr (host:port, or unix:path for a Unix socket) until the context is done.
+func Serve(ctx context.Context, addr string, supervisor *pipeline.Supervisor) error {
+	listener, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{ // nolint:exhaustruct // defaults
+		// Requests (eg: followed logs) end along with the server.
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+		Handler:           Handler(supervisor),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		_ = srv.Shutdown(shutdown) // nolint:contextcheck // the parent context is done
+	}()
+
+	if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err, "control")
+	}
+
+	return nil
+}
+
+// Listen opens a Unix socket (a stale socket file is replaced) or a TCP socket, on a loopback address only.
+func Listen(addr string) (net.Listener, error) {
+	network, check := "tcp", checkLoopback
+
+	if strings.HasPrefix(addr, UnixPrefix) {
+		network, addr, check = "unix", strings.TrimPrefix(addr, UnixPrefix), removeStale
+	}
+
+	if err := check(addr); err != nil {
+		return nil, errors.Wrap(err, "control")
+	}
+
+	listener, err := net.Listen(network, addr)
+
+	return listener, errors.Wrap(err, "control")
+}
+
+// checkLoopback rejects the TCP addresses reachable from other hosts (including the unspecified one, eg: :9101).
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err // nolint:wrapcheck // wrapped by Listen
+	}
+
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+
+	return errors.Wrap(ErrNotLoopback, addr)
+}
+
+// removeStale removes the socket file left by a previous run. Other files, and the sockets still served, are kept.
+func removeStale(path string) error {
+	info, err := os.Lstat(path)
+
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err // nolint:wrapcheck // wrapped by Listen
+	case info.Mode()&os.ModeSocket == 0:
+		return errors.Wrap(errNotSocket, path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, dialTimeout); err == nil {
+		conn.Close()
+
+		return errors.Wrap(ErrSocketInUse, path)
+	}
+
+	return os.Remove(path) // nolint:wrapcheck // wrapped by Listen
+}
+
+// ParseSignal parses a signal name (HUP, SIGHUP) or number.
+func ParseSignal(str string) (syscall.Signal, error) {
+	if num, err := strconv.Atoi(str); err == nil && num > 0 {
+		return syscall.Signal(num), nil
+	}
+
+	if sig, ok := signals[strings.TrimPrefix(strings.ToUpper(str), "SIG")]; ok {
+		return sig, nil
+	}
+
+	return 0, errors.Wrap(ErrUnknownSignal, str)
+}
+
+// act applies an action to a node.
+func act(supervisor *pipeline.Supervisor, node, action, signal string) error {
+	switch action {
+	case "pause":
+		return supervisor.Pause(node) // nolint:wrapcheck // already wrapped
+	case "restart":
+		return supervisor.Restart(node) // nolint:wrapcheck // already wrapped
+	case "resume":
+		return supervisor.Resume(node) // nolint:wrapcheck // already wrapped
+	case "start":
+		return supervisor.Start(node) // nolint:wrapcheck // already wrapped
+	case "stop":
+		return supervisor.Stop(node) // nolint:wrapcheck // already wrapped
+	case "signal":
+		sig, err := ParseSignal(signal)
+		if err != nil {
+			return err
+		}
+
+		return supervisor.Signal(node, sig) // nolint:wrapcheck // already wrapped
+	default:
+		return errors.Wrap(ErrUnknownAction, action)
+	}
+}
+
+// status maps errors to HTTP status codes.
+func status(err error) int {
+	switch {
+	case errors.Is(err, pipeline.ErrUnknownNode), errors.Is(err, ErrUnknownAction):
+		return http.StatusNotFound
+	case errors.Is(err, pipeline.ErrNotControllable), errors.Is(err, subprocess.ErrNotRunning):
+		return http.StatusConflict
+	case errors.Is(err, pipeline.ErrAmbiguousNode), errors.Is(err, ErrUnknownSignal):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// reply writes a JSON response; errors are wrapped in an object.
+func reply(w http.ResponseWriter, code int, body interface{}) {
+	if err, ok := body.(error); ok {
+		body = map[string]string{"error": err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(body)
+}
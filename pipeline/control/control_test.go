@@ -0,0 +1,207 @@
+package control_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/control"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	node, err := pipeline.Parallel(
+		pipeline.Command("sleep", "10").Name("daemon-1"),
+	).Name("root").Log(pipeline.LogConfig{Disabled: true}).Build()
+	require.NoError(t, err)
+
+	supervisor := pipeline.NewSupervisor(node)
+	node.WithSupervisor(supervisor)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan error)
+
+	go func() { done <- node.Run(ctx) }()
+
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	srv := httptest.NewServer(control.Handler(supervisor))
+	defer srv.Close()
+
+	require.Eventually(t, func() bool {
+		state, err := supervisor.Node("daemon-1")
+
+		return err == nil && state.PID != 0
+	}, time.Second, time.Millisecond)
+
+	tests := map[string]struct {
+		method string
+		path   string
+		code   int
+		want   string
+	}{
+		"tree":           {method: http.MethodGet, path: "/nodes", code: http.StatusOK, want: `"path":"root"`},
+		"node":           {method: http.MethodGet, path: "/nodes/root/daemon-1", code: http.StatusOK, want: `"status":"running"`},
+		"unknown node":   {method: http.MethodGet, path: "/nodes/nope", code: http.StatusNotFound, want: `{"error":"nope: unknown node"}`},
+		"pause":          {method: http.MethodPost, path: "/nodes/daemon-1/pause", code: http.StatusOK, want: `"paused":true`},
+		"resume":         {method: http.MethodPost, path: "/nodes/daemon-1/resume", code: http.StatusOK, want: `"name":"daemon-1"`},
+		"group":          {method: http.MethodPost, path: "/nodes/root/stop", code: http.StatusConflict, want: "node not controllable"},
+		"unknown action": {method: http.MethodPost, path: "/nodes/daemon-1/kill", code: http.StatusNotFound, want: "unknown action"},
+		"bad signal":     {method: http.MethodPost, path: "/nodes/daemon-1/signal?signal=NOPE", code: http.StatusBadRequest, want: "unknown signal"},
+		"bad method":     {method: http.MethodDelete, path: "/nodes/daemon-1", code: http.StatusMethodNotAllowed, want: "method not allowed"},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.TODO(), tt.method, srv.URL+tt.path, nil)
+			require.NoError(t, err)
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+
+			defer res.Body.Close()
+
+			var body json.RawMessage
+
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			assert.Equal(t, tt.code, res.StatusCode)
+			assert.Contains(t, string(body), tt.want)
+		})
+	}
+}
+
+func TestServeUnix(t *testing.T) {
+	t.Parallel()
+
+	node := pipeline.Command("true").Name("job").Node()
+	socket := filepath.Join(t.TempDir(), "pipeline.sock")
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan error)
+
+	go func() { done <- control.Serve(ctx, control.UnixPrefix+socket, pipeline.NewSupervisor(&node)) }()
+
+	client := http.Client{Transport: &http.Transport{ // nolint:exhaustruct // defaults
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket) // nolint:exhaustruct // defaults
+		},
+	}}
+
+	require.Eventually(t, func() bool {
+		req, _ := http.NewRequestWithContext(context.TODO(), http.MethodGet, "http://pipeline/nodes/job", nil)
+
+		res, err := client.Do(req)
+		if err != nil {
+			return false
+		}
+
+		defer res.Body.Close()
+
+		return res.StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestListen(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		// addr returns the address to listen on, given a temporary directory.
+		addr func(t *testing.T, dir string) string
+		err  string
+	}{
+		"loopback":  {addr: func(*testing.T, string) string { return "127.0.0.1:0" }},
+		"localhost": {addr: func(*testing.T, string) string { return "localhost:0" }},
+		"any":       {addr: func(*testing.T, string) string { return ":0" }, err: "not a loopback address"},
+		"remote":    {addr: func(*testing.T, string) string { return "0.0.0.0:0" }, err: "not a loopback address"},
+		"new socket": {addr: func(_ *testing.T, dir string) string {
+			return control.UnixPrefix + filepath.Join(dir, "pipeline.sock")
+		}},
+		"stale socket": {addr: func(t *testing.T, dir string) string {
+			socket := filepath.Join(dir, "pipeline.sock")
+
+			listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+			require.NoError(t, err)
+
+			listener.SetUnlinkOnClose(false)
+			require.NoError(t, listener.Close())
+			require.FileExists(t, socket)
+
+			return control.UnixPrefix + socket
+		}},
+		"socket in use": {addr: func(t *testing.T, dir string) string {
+			socket := filepath.Join(dir, "pipeline.sock")
+
+			listener, err := net.Listen("unix", socket)
+			require.NoError(t, err)
+			t.Cleanup(func() { listener.Close() })
+
+			return control.UnixPrefix + socket
+		}, err: "socket in use"},
+		"regular file": {addr: func(t *testing.T, dir string) string {
+			file := filepath.Join(dir, "pipeline.sock")
+			require.NoError(t, os.WriteFile(file, []byte("data"), 0o600))
+
+			return control.UnixPrefix + file
+		}, err: "not a socket"},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			addr := tt.addr(t, dir)
+
+			listener, err := control.Listen(addr)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+
+				// The file is kept.
+				if path := strings.TrimPrefix(addr, control.UnixPrefix); path != addr {
+					assert.FileExists(t, path)
+				}
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NoError(t, listener.Close())
+		})
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	t.Parallel()
+
+	for str, want := range map[string]syscall.Signal{"HUP": syscall.SIGHUP, "sigterm": syscall.SIGTERM, "9": syscall.SIGKILL} {
+		sig, err := control.ParseSignal(str)
+		require.NoError(t, err, str)
+		assert.Equal(t, want, sig, str)
+	}
+
+	_, err := control.ParseSignal("nope")
+	assert.True(t, strings.HasPrefix(err.Error(), "nope"))
+	assert.ErrorIs(t, err, control.ErrUnknownSignal)
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package control
+
+import "syscall"
+
+// signals maps the supported signal names.
+var signals = map[string]syscall.Signal{ // nolint:gochecknoglobals // lookup table
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
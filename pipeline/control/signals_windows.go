@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package control
+
+import "syscall"
+
+// signals maps the supported signal names (only KILL is delivered, see subprocess.Proc.Signal).
+var signals = map[string]syscall.Signal{ // nolint:gochecknoglobals // lookup table
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+}
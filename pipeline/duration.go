@@ -0,0 +1,6 @@
+package pipeline
+
+import "bitbucket.org/lucacontini/z6/pipeline/units"
+
+// Duration is a time.Duration that decodes the same way from YAML, JSON and TOML (see units.Duration).
+type Duration = units.Duration
@@ -0,0 +1,207 @@
+// package event lets embedding code observe the pipeline lifecycle.
+package event
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Event types, as written by JSONLines.
+const (
+	TypeNodeFinished   Type = "node-finished"
+	TypeNodeStarted    Type = "node-started"
+	TypeProcessExited  Type = "process-exited"
+	TypeProcessSpawned Type = "process-spawned"
+	TypeRestarting     Type = "restarting"
+	TypeSkipped        Type = "skipped"
+)
+
+type (
+	// Observer is notified of what the engine does. Nodes are identified by their path (eg: root/daemon-1).
+	// Methods can be called concurrently, by parallel nodes.
+	Observer interface {
+		// NodeStarted is called when a node starts running.
+		NodeStarted(node string)
+		// NodeFinished is called when a node returns, with its error if any.
+		NodeFinished(node string, err error)
+		// ProcessSpawned is called when the process of a command node starts.
+		ProcessSpawned(node string, pid int)
+		// ProcessExited is called when the process of a command node exits.
+		ProcessExited(node string, state *os.ProcessState, elapsed time.Duration)
+		// Restarting is called before a node is restarted by its exit policy.
+		Restarting(node string, restarts int)
+		// Skipped is called for the steps that do not run because a previous one failed.
+		Skipped(node string)
+	}
+
+	// Nop is an observer that does nothing, to embed in partial implementations.
+	Nop struct{}
+
+	// Type is the kind of an event.
+	Type string
+
+	// Event is a line written by JSONLines.
+	Event struct {
+		Elapsed  float64   `json:"elapsed,omitempty"`
+		Error    string    `json:"error,omitempty"`
+		ExitCode *int      `json:"exitCode,omitempty"`
+		Node     string    `json:"node"`
+		PID      int       `json:"pid,omitempty"`
+		Restarts int       `json:"restarts,omitempty"`
+		Signal   string    `json:"signal,omitempty"`
+		Time     time.Time `json:"time"`
+		Type     Type      `json:"type"`
+	}
+
+	// JSONLines is an observer writing an event per line. Once a write fails, the following events are dropped and Err
+	// returns the error.
+	JSONLines struct {
+		mtx sync.Mutex
+		enc *json.Encoder
+		err error
+	}
+
+	// multi sends the events to several observers.
+	multi []Observer
+)
+
+// NodeStarted does nothing.
+func (Nop) NodeStarted(string) {}
+
+// NodeFinished does nothing.
+func (Nop) NodeFinished(string, error) {}
+
+// ProcessSpawned does nothing.
+func (Nop) ProcessSpawned(string, int) {}
+
+// ProcessExited does nothing.
+func (Nop) ProcessExited(string, *os.ProcessState, time.Duration) {}
+
+// Restarting does nothing.
+func (Nop) Restarting(string, int) {}
+
+// Skipped does nothing.
+func (Nop) Skipped(string) {}
+
+// Multi returns an observer notifying all the given observers, in order.
+func Multi(observers ...Observer) Observer { // nolint:ireturn // composite
+	return multi(observers)
+}
+
+// NodeStarted notifies all the observers.
+func (m multi) NodeStarted(node string) {
+	for _, obs := range m {
+		obs.NodeStarted(node)
+	}
+}
+
+// NodeFinished notifies all the observers.
+func (m multi) NodeFinished(node string, err error) {
+	for _, obs := range m {
+		obs.NodeFinished(node, err)
+	}
+}
+
+// ProcessSpawned notifies all the observers.
+func (m multi) ProcessSpawned(node string, pid int) {
+	for _, obs := range m {
+		obs.ProcessSpawned(node, pid)
+	}
+}
+
+// ProcessExited notifies all the observers.
+func (m multi) ProcessExited(node string, state *os.ProcessState, elapsed time.Duration) {
+	for _, obs := range m {
+		obs.ProcessExited(node, state, elapsed)
+	}
+}
+
+// Restarting notifies all the observers.
+func (m multi) Restarting(node string, restarts int) {
+	for _, obs := range m {
+		obs.Restarting(node, restarts)
+	}
+}
+
+// Skipped notifies all the observers.
+func (m multi) Skipped(node string) {
+	for _, obs := range m {
+		obs.Skipped(node)
+	}
+}
+
+// NewJSONLines returns an observer writing events into w. Write errors are ignored.
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{mtx: sync.Mutex{}, enc: json.NewEncoder(w), err: nil}
+}
+
+// Err returns the first write error, if any.
+func (j *JSONLines) Err() error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	return j.err
+}
+
+// NodeStarted writes a node-started event.
+func (j *JSONLines) NodeStarted(node string) {
+	j.write(Event{Node: node, Type: TypeNodeStarted}) // nolint:exhaustruct // omitted
+}
+
+// NodeFinished writes a node-finished event.
+func (j *JSONLines) NodeFinished(node string, err error) {
+	evt := Event{Node: node, Type: TypeNodeFinished} // nolint:exhaustruct // omitted
+	if err != nil {
+		evt.Error = err.Error()
+	}
+
+	j.write(evt)
+}
+
+// ProcessSpawned writes a process-spawned event.
+func (j *JSONLines) ProcessSpawned(node string, pid int) {
+	j.write(Event{Node: node, PID: pid, Type: TypeProcessSpawned}) // nolint:exhaustruct // omitted
+}
+
+// ProcessExited writes a process-exited event.
+func (j *JSONLines) ProcessExited(node string, state *os.ProcessState, elapsed time.Duration) {
+	evt := Event{Elapsed: elapsed.Seconds(), Node: node, Type: TypeProcessExited} // nolint:exhaustruct // omitted
+
+	if state != nil {
+		code := state.ExitCode()
+		evt.ExitCode = &code
+		evt.PID = state.Pid()
+
+		if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			evt.Signal = ws.Signal().String()
+		}
+	}
+
+	j.write(evt)
+}
+
+// Restarting writes a restarting event.
+func (j *JSONLines) Restarting(node string, restarts int) {
+	j.write(Event{Node: node, Restarts: restarts, Type: TypeRestarting}) // nolint:exhaustruct // omitted
+}
+
+// Skipped writes a skipped event.
+func (j *JSONLines) Skipped(node string) {
+	j.write(Event{Node: node, Type: TypeSkipped}) // nolint:exhaustruct // omitted
+}
+
+// write timestamps and encodes an event.
+func (j *JSONLines) write(evt Event) {
+	evt.Time = time.Now()
+
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if j.err == nil {
+		j.err = j.enc.Encode(evt)
+	}
+}
@@ -0,0 +1,111 @@
+package event_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/event"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// counter counts the node-started events.
+type counter struct {
+	event.Nop
+	started int
+}
+
+func (c *counter) NodeStarted(string) {
+	c.started++
+}
+
+func TestJSONLines(t *testing.T) {
+	t.Parallel()
+
+	cmd := exec.Command("sh", "-c", "exit 3")
+	require.Error(t, cmd.Run())
+
+	var buf bytes.Buffer
+
+	sink := event.NewJSONLines(&buf)
+	sink.NodeStarted("root")
+	sink.ProcessSpawned("root/exit-3", 42)
+	sink.ProcessExited("root/exit-3", cmd.ProcessState, 1500*time.Millisecond)
+	sink.Restarting("root/exit-3", 1)
+	sink.Skipped("root/next")
+	sink.NodeFinished("root", errors.New("exit status 3"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 6)
+
+	events := make([]event.Event, 0, len(lines))
+
+	for _, line := range lines {
+		var evt event.Event
+
+		require.NoError(t, json.Unmarshal([]byte(line), &evt))
+		assert.False(t, evt.Time.IsZero())
+
+		evt.Time = time.Time{}
+		events = append(events, evt)
+	}
+
+	code := 3
+
+	assert.Equal(t, []event.Event{
+		{Node: "root", Type: event.TypeNodeStarted},
+		{Node: "root/exit-3", PID: 42, Type: event.TypeProcessSpawned},
+		{Elapsed: 1.5, ExitCode: &code, Node: "root/exit-3", PID: cmd.ProcessState.Pid(), Type: event.TypeProcessExited},
+		{Node: "root/exit-3", Restarts: 1, Type: event.TypeRestarting},
+		{Node: "root/next", Type: event.TypeSkipped},
+		{Error: "exit status 3", Node: "root", Type: event.TypeNodeFinished},
+	}, events)
+}
+
+// failingWriter fails every write.
+type failingWriter struct {
+	writes int
+}
+
+func (f *failingWriter) Write([]byte) (int, error) {
+	f.writes++
+
+	return 0, errors.New("disk full")
+}
+
+func TestJSONLinesError(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	sink := event.NewJSONLines(&buf)
+	sink.NodeStarted("root")
+	assert.NoError(t, sink.Err())
+
+	w := &failingWriter{}
+
+	// The first error is kept, and the following events are dropped.
+	sink = event.NewJSONLines(w)
+	sink.NodeStarted("root")
+	sink.NodeFinished("root", nil)
+	assert.EqualError(t, sink.Err(), "disk full")
+	assert.Equal(t, 1, w.writes)
+}
+
+func TestMulti(t *testing.T) {
+	t.Parallel()
+
+	first, second := &counter{}, &counter{}
+
+	obs := event.Multi(first, second, event.Nop{})
+	obs.NodeStarted("root")
+	obs.NodeFinished("root", nil)
+
+	assert.Equal(t, 1, first.started)
+	assert.Equal(t, 1, second.started)
+}
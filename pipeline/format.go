@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the encoding of a pipeline definition.
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatTOML Format = "toml"
+	FormatYAML Format = "yaml"
+)
+
+// yamlIndent is the indentation of encoded YAML documents.
+const yamlIndent = 2
+
+// errUnknownFormat is returned when a format is not supported.
+var errUnknownFormat = errors.New("unknown format")
+
+// ParseFormat validates a format name (eg: from a command line flag).
+func ParseFormat(str string) (Format, error) {
+	switch format := Format(strings.ToLower(str)); format {
+	case "", "yml":
+		return FormatYAML, nil
+	case FormatJSON, FormatTOML, FormatYAML:
+		return format, nil
+	default:
+		return "", errors.Wrap(errUnknownFormat, str)
+	}
+}
+
+// FormatOf detects the format of a file from its extension, defaulting to YAML.
+func FormatOf(file string) Format {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatYAML
+	}
+}
+
+// Marshal encodes a pipeline definition.
+func (f Format) Marshal(v interface{}) ([]byte, error) {
+	switch f {
+	case FormatJSON:
+		data, err := json.MarshalIndent(v, "", "  ")
+
+		return data, errors.Wrap(err, "json")
+	case FormatTOML:
+		return toml.Marshal(v) // nolint:wrapcheck // errors are already prefixed with "toml:"
+	case FormatYAML, "":
+		var buf bytes.Buffer
+
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(yamlIndent)
+
+		if err := enc.Encode(v); err != nil {
+			return nil, err // nolint:wrapcheck // errors are already prefixed with "yaml:"
+		}
+
+		return buf.Bytes(), nil
+	default:
+		return nil, errors.Wrap(errUnknownFormat, string(f))
+	}
+}
+
+// Unmarshal decodes a pipeline definition into v.
+func (f Format) Unmarshal(data []byte, v interface{}) error {
+	switch f {
+	case FormatJSON:
+		return errors.Wrap(json.Unmarshal(data, v), "json")
+	case FormatTOML:
+		return toml.Unmarshal(data, v) // nolint:wrapcheck // errors are already prefixed with "toml:"
+	case FormatYAML, "":
+		return yaml.Unmarshal(data, v) // nolint:wrapcheck // errors are already prefixed with "yaml:"
+	default:
+		return errors.Wrap(errUnknownFormat, string(f))
+	}
+}
@@ -0,0 +1,249 @@
+package pipeline_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatOf(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]pipeline.Format{
+		"-":                 pipeline.FormatYAML,
+		"pipeline.json":     pipeline.FormatJSON,
+		"pipeline.JSON":     pipeline.FormatJSON,
+		"pipeline.toml":     pipeline.FormatTOML,
+		"pipeline.yaml":     pipeline.FormatYAML,
+		"pipeline.yml":      pipeline.FormatYAML,
+		"/tmp/pipeline.txt": pipeline.FormatYAML,
+	}
+
+	for file, want := range testTable {
+		file, want := file, want
+
+		t.Run(file, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, want, pipeline.FormatOf(file))
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	t.Parallel()
+
+	type want struct {
+		err    string
+		format pipeline.Format
+	}
+
+	testTable := map[string]want{
+		"":     {format: pipeline.FormatYAML},
+		"json": {format: pipeline.FormatJSON},
+		"TOML": {format: pipeline.FormatTOML},
+		"yml":  {format: pipeline.FormatYAML},
+		"xml":  {err: "xml: unknown format"},
+	}
+
+	for name, unit := range testTable {
+		name, unit := name, unit
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			format, err := pipeline.ParseFormat(name)
+			if unit.err != "" {
+				assert.EqualError(t, err, unit.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, unit.format, format)
+		})
+	}
+}
+
+func TestFormatsAreEquivalent(t *testing.T) {
+	t.Parallel()
+
+	want := decode(t, "../testdata/test-pipeline-001.yaml")
+
+	for _, file := range []string{
+		"../testdata/test-pipeline-001.json",
+		"../testdata/test-pipeline-001.toml",
+	} {
+		file := file
+
+		t.Run(file, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, want, decode(t, file))
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	t.Parallel()
+
+	type (
+		args struct {
+			format pipeline.Format
+			str    string
+		}
+
+		want struct {
+			err     string
+			timeout time.Duration
+		}
+	)
+
+	testTable := map[string]struct {
+		args
+		want
+	}{
+		"JSON": {
+			args: args{format: pipeline.FormatJSON, str: `{"timeout": "1m30s"}`},
+			want: want{timeout: 90 * time.Second},
+		},
+		"JSON (integer)": {
+			args: args{format: pipeline.FormatJSON, str: `{"timeout": 90}`},
+			want: want{err: "cannot unmarshal: json: 90: duration without a unit, eg: 90s or 1m30s"},
+		},
+		"JSON (null)": {
+			args: args{format: pipeline.FormatJSON, str: `{"timeout": null}`},
+			want: want{timeout: 0},
+		},
+		"TOML": {
+			args: args{format: pipeline.FormatTOML, str: `timeout = "1m30s"`},
+			want: want{timeout: 90 * time.Second},
+		},
+		"TOML (integer)": {
+			args: args{format: pipeline.FormatTOML, str: `timeout = 90`},
+			want: want{err: "cannot unmarshal: toml: 90: duration without a unit, eg: 90s or 1m30s"},
+		},
+		"YAML": {
+			args: args{format: pipeline.FormatYAML, str: `timeout: 1m30s`},
+			want: want{timeout: 90 * time.Second},
+		},
+		"YAML (seconds)": {
+			args: args{format: pipeline.FormatYAML, str: `timeout: 90s`},
+			want: want{timeout: 90 * time.Second},
+		},
+		"YAML (fraction)": {
+			args: args{format: pipeline.FormatYAML, str: `timeout: 1.5m`},
+			want: want{timeout: 90 * time.Second},
+		},
+		"YAML (float)": {
+			args: args{format: pipeline.FormatYAML, str: `timeout: 1.5`},
+			want: want{err: "cannot unmarshal: line 1: 1.5: duration without a unit, eg: 90s or 1m30s"},
+		},
+		"YAML (invalid)": {
+			args: args{format: pipeline.FormatYAML, str: `timeout: soon`},
+			want: want{err: `cannot unmarshal: line 1: time: invalid duration "soon": invalid duration`},
+		},
+		"YAML (integer)": {
+			args: args{format: pipeline.FormatYAML, str: `timeout: 90`},
+			want: want{err: "cannot unmarshal: line 1: 90: duration without a unit, eg: 90s or 1m30s"},
+		},
+		"YAML (infinity)": {
+			args: args{format: pipeline.FormatYAML, str: `timeout: inf`},
+			want: want{err: `cannot unmarshal: line 1: time: invalid duration "inf": invalid duration`},
+		},
+		"YAML (nan)": {
+			args: args{format: pipeline.FormatYAML, str: `timeout: NaN`},
+			want: want{err: `cannot unmarshal: line 1: time: invalid duration "NaN": invalid duration`},
+		},
+	}
+
+	for name, unit := range testTable {
+		unit := unit
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			node, err := pipeline.NewWithFormat(unit.args.str, unit.args.format)
+			if unit.want.err != "" {
+				assert.EqualError(t, err, unit.want.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, unit.want.timeout, node.Timeout.Duration())
+		})
+	}
+}
+
+func TestDurationNull(t *testing.T) {
+	t.Parallel()
+
+	dur := pipeline.Duration(time.Minute)
+
+	require.NoError(t, json.Unmarshal([]byte("null"), &dur))
+	assert.Equal(t, time.Minute, dur.Duration())
+}
+
+func TestDeadline(t *testing.T) {
+	t.Parallel()
+
+	want := time.Date(2026, time.October, 19, 18, 30, 0, 0, time.UTC)
+
+	testTable := map[pipeline.Format]string{
+		pipeline.FormatJSON: `{"deadline": "2026-10-19T18:30:00Z"}`,
+		pipeline.FormatTOML: `deadline = 2026-10-19T18:30:00Z`,
+		pipeline.FormatYAML: `deadline: 2026-10-19T18:30:00Z`,
+	}
+
+	for format, str := range testTable {
+		format, str := format, str
+
+		t.Run(string(format), func(t *testing.T) {
+			t.Parallel()
+
+			node, err := pipeline.NewWithFormat(str, format)
+			require.NoError(t, err)
+			require.NotNil(t, node.Deadline)
+			assert.True(t, want.Equal(*node.Deadline), node.Deadline)
+		})
+	}
+}
+
+func TestExitPolicy(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[pipeline.Format]string{
+		pipeline.FormatJSON: `{"onExit": "report-if-err"}`,
+		pipeline.FormatTOML: `onExit = "report-if-err"`,
+		pipeline.FormatYAML: `onExit: report-if-err`,
+	}
+
+	for format, str := range testTable {
+		format, str := format, str
+
+		t.Run(string(format), func(t *testing.T) {
+			t.Parallel()
+
+			_, err := pipeline.NewWithFormat(str, format)
+			assert.ErrorContains(t, err, "report-if-err: unknown exit policy")
+		})
+	}
+}
+
+func decode(t *testing.T, file string) pipeline.Node {
+	t.Helper()
+
+	var node pipeline.Node
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.NoError(t, pipeline.FormatOf(file).Unmarshal(data, &node))
+
+	return node
+}
@@ -0,0 +1,314 @@
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GraphFormat is a diagram language of WriteGraph.
+type GraphFormat string
+
+const (
+	GraphDOT     GraphFormat = "dot"
+	GraphMermaid GraphFormat = "mermaid"
+	GraphTree    GraphFormat = "tree"
+)
+
+// graphLabelWidth truncates the commands in the diagram labels.
+const graphLabelWidth = 40
+
+// errGraphFormat is returned when a diagram language is not supported.
+var errGraphFormat = errors.New("unknown graph format (use tree, dot or mermaid)")
+
+// statusColors maps the statuses to the fill colors of the diagrams.
+var statusColors = map[Status]string{ // nolint:gochecknoglobals // lookup table
+	StatusCancelled: "#e0e0e0",
+	StatusFailed:    "#ffcdd2",
+	StatusSkipped:   "#f5f5f5",
+	StatusSuccess:   "#c8e6c9",
+	StatusTimedOut:  "#ffe0b2",
+}
+
+// graph renders a node tree; edges are written last, once every node is declared.
+type graph struct {
+	w      io.Writer
+	format GraphFormat
+	edges  [][2]string
+	ids    int
+}
+
+// ParseGraphFormat validates a diagram language name (eg: from a command line flag).
+func ParseGraphFormat(str string) (GraphFormat, error) {
+	switch format := GraphFormat(strings.ToLower(str)); format {
+	case "":
+		return GraphTree, nil
+	case GraphDOT, GraphMermaid, GraphTree:
+		return format, nil
+	default:
+		return "", errors.Wrap(errGraphFormat, str)
+	}
+}
+
+// WriteGraph renders the tree as a diagram: serial steps are chained, parallel tasks fan out and in, and the labels
+// show the commands, exit policies and timeouts. The statuses of report (eg: the JSON report of a previous run) are
+// overlaid when it is not nil.
+func (n *Node) WriteGraph(w io.Writer, format GraphFormat, report *Result) error {
+	buf := bufio.NewWriter(w)
+	inst := &graph{w: buf, format: format, edges: nil, ids: 0}
+
+	switch format {
+	case GraphDOT:
+		fmt.Fprintf(buf, "digraph %s {\n", dotQuote(n.ID()))
+		fmt.Fprintln(buf, `  node [shape=box, style=rounded, fontname="Helvetica"];`)
+		inst.add(n, report, "  ")
+
+		for _, edge := range inst.edges {
+			fmt.Fprintf(buf, "  %s -> %s;\n", edge[0], edge[1])
+		}
+
+		fmt.Fprintln(buf, "}")
+	case GraphMermaid:
+		fmt.Fprintln(buf, "flowchart TD")
+
+		if report != nil {
+			for _, status := range []Status{StatusCancelled, StatusFailed, StatusSkipped, StatusSuccess, StatusTimedOut} {
+				fmt.Fprintf(buf, "  classDef %s fill:%s\n", mermaidClass(status), statusColors[status])
+			}
+		}
+
+		inst.add(n, report, "  ")
+
+		for _, edge := range inst.edges {
+			fmt.Fprintf(buf, "  %s --> %s\n", edge[0], edge[1])
+		}
+	case GraphTree:
+		n.writeTree(buf, report, "", "")
+	default:
+		return errors.Wrap(errGraphFormat, string(format))
+	}
+
+	return errors.Wrap(buf.Flush(), "cannot write graph")
+}
+
+// add declares the node (a box, or a cluster of its children) and records its edges. It returns the entry and exit
+// points of the node.
+func (g *graph) add(n *Node, res *Result, indent string) ([]string, []string) {
+	g.ids++
+	id := "n" + strconv.Itoa(g.ids)
+	label := n.graphLabel(res)
+
+	children := n.Children()
+	if len(children) == 0 {
+		g.box(indent, id, label, res)
+
+		return []string{id}, []string{id}
+	}
+
+	g.open(indent, id, label)
+	defer g.close(indent)
+
+	if n.IsParallel() {
+		fork, join := id+"_fork", id+"_join"
+		g.point(indent+"  ", fork)
+		g.point(indent+"  ", join)
+
+		for i := range children {
+			entries, exits := g.add(&children[i], res.child(i, children[i].ID()), indent+"  ")
+			g.link([]string{fork}, entries)
+			g.link(exits, []string{join})
+		}
+
+		return []string{fork}, []string{join}
+	}
+
+	var first, last []string
+
+	for i := range children {
+		entries, exits := g.add(&children[i], res.child(i, children[i].ID()), indent+"  ")
+		if i == 0 {
+			first = entries
+		}
+
+		g.link(last, entries)
+		last = exits
+	}
+
+	return first, last
+}
+
+// link records the edges from every source to every target.
+func (g *graph) link(sources, targets []string) {
+	for _, source := range sources {
+		for _, target := range targets {
+			g.edges = append(g.edges, [2]string{source, target})
+		}
+	}
+}
+
+// box declares a command, plugin or empty node.
+func (g *graph) box(indent, id string, label []string, res *Result) {
+	status := res.status()
+
+	switch g.format {
+	case GraphDOT:
+		fill := ""
+		if color, ok := statusColors[status]; ok {
+			fill = fmt.Sprintf(`, style="rounded,filled", fillcolor=%q`, color)
+		}
+
+		fmt.Fprintf(g.w, "%s%s [label=%s%s];\n", indent, id, dotQuote(strings.Join(label, "\n")), fill)
+	case GraphMermaid:
+		class := ""
+		if status != "" {
+			class = ":::" + mermaidClass(status)
+		}
+
+		fmt.Fprintf(g.w, "%s%s[%s]%s\n", indent, id, mermaidQuote(label), class)
+	}
+}
+
+// open starts the cluster of a group.
+func (g *graph) open(indent, id string, label []string) {
+	switch g.format {
+	case GraphDOT:
+		fmt.Fprintf(g.w, "%ssubgraph cluster_%s {\n", indent, id)
+		fmt.Fprintf(g.w, "%s  label=%s;\n", indent, dotQuote(strings.Join(label, "\n")))
+		fmt.Fprintf(g.w, "%s  style=dashed;\n", indent)
+	case GraphMermaid:
+		fmt.Fprintf(g.w, "%ssubgraph %s [%s]\n", indent, id, mermaidQuote(label))
+	}
+}
+
+// close ends the cluster of a group.
+func (g *graph) close(indent string) {
+	switch g.format {
+	case GraphDOT:
+		fmt.Fprintf(g.w, "%s}\n", indent)
+	case GraphMermaid:
+		fmt.Fprintf(g.w, "%send\n", indent)
+	}
+}
+
+// point declares the fan-out or fan-in point of a parallel group.
+func (g *graph) point(indent, id string) {
+	switch g.format {
+	case GraphDOT:
+		fmt.Fprintf(g.w, "%s%s [shape=point];\n", indent, id)
+	case GraphMermaid:
+		fmt.Fprintf(g.w, "%s%s((\" \"))\n", indent, id)
+	}
+}
+
+// writeTree draws the node tree as text; prefix indents the node line and indent its children.
+func (n *Node) writeTree(w io.Writer, res *Result, prefix, indent string) {
+	details := append([]string{n.Kind()}, n.graphLabel(res)[2:]...)
+	fmt.Fprintf(w, "%s%s (%s)\n", prefix, n.ID(), strings.Join(details, ", "))
+
+	children := n.Children()
+	for i := range children {
+		child := res.child(i, children[i].ID())
+
+		if i == len(children)-1 {
+			children[i].writeTree(w, child, indent+"└── ", indent+"    ")
+		} else {
+			children[i].writeTree(w, child, indent+"├── ", indent+"│   ")
+		}
+	}
+}
+
+// graphLabel returns the lines describing the node: its name, kind (or command), policy, schedule, timeout and status
+// (or whether Select skips it).
+func (n *Node) graphLabel(res *Result) []string {
+	label := []string{n.ID()}
+
+	switch n.Kind() {
+	case KindCommand:
+		label = append(label, truncate(shellQuote(append([]string{n.Command}, n.Args...)), graphLabelWidth))
+	case KindPlugin:
+		label = append(label, "plugin "+n.Type)
+	default:
+		label = append(label, n.Kind())
+	}
+
+	if n.OnExit != "" {
+		label = append(label, "onExit: "+string(n.OnExit))
+	}
+
+	if schedule := n.scheduleSummary(); schedule != "" {
+		label = append(label, "schedule: "+schedule)
+	}
+
+	if n.Timeout > 0 {
+		label = append(label, "timeout: "+n.Timeout.String())
+	}
+
+	switch status := res.status(); {
+	case status != "":
+		label = append(label, "status: "+string(status))
+	case n.skipped:
+		label = append(label, "skipped")
+	}
+
+	return label
+}
+
+// child returns the result of the i-th child, matched by name when the trees differ (eg: after a selection).
+func (r *Result) child(i int, name string) *Result {
+	switch {
+	case r == nil:
+		return nil
+	case i < len(r.Children) && r.Children[i].Name == name:
+		return r.Children[i]
+	}
+
+	for _, child := range r.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+
+	return nil
+}
+
+// status returns the status of the result, if any.
+func (r *Result) status() Status {
+	if r == nil {
+		return ""
+	}
+
+	return r.Status
+}
+
+// truncate shortens a string to width runes, with an ellipsis.
+func truncate(str string, width int) string {
+	if runes := []rune(str); len(runes) > width {
+		return string(runes[:width-1]) + "…"
+	}
+
+	return str
+}
+
+// dotQuote returns a DOT string.
+func dotQuote(str string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(str) + `"`
+}
+
+// mermaidQuote returns a Mermaid label, one line per item.
+func mermaidQuote(lines []string) string {
+	escaped := make([]string, len(lines))
+	for i, line := range lines {
+		escaped[i] = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(line)
+	}
+
+	return `"` + strings.Join(escaped, "<br/>") + `"`
+}
+
+// mermaidClass returns the Mermaid class of a status (class names cannot contain dashes).
+func mermaidClass(status Status) string {
+	return strings.ReplaceAll(string(status), "-", "_")
+}
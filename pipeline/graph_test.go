@@ -0,0 +1,139 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteGraph(t *testing.T) {
+	t.Parallel()
+
+	node, err := pipeline.Serial(
+		pipeline.Command("echo", "build").Name("build").Timeout(time.Minute),
+		pipeline.Parallel(
+			pipeline.Command("true").Name("unit"),
+			pipeline.Command("sh", "-c", "exit 1").Name("e2e").OnExit(loop.ExitPolicyPropagate),
+		).Name("test"),
+		pipeline.Command("echo", "deploy").Name("deploy"),
+	).Name("root").Log(pipeline.LogConfig{Disabled: true}).Build()
+	require.NoError(t, err)
+
+	// The report of a run where e2e failed, as read back from --report.
+	report := &pipeline.Result{
+		Name: "root", Kind: pipeline.KindSerial, Status: pipeline.StatusFailed, Children: []*pipeline.Result{
+			{Name: "build", Kind: pipeline.KindCommand, Status: pipeline.StatusSuccess},
+			{Name: "test", Kind: pipeline.KindParallel, Status: pipeline.StatusFailed, Children: []*pipeline.Result{
+				{Name: "unit", Kind: pipeline.KindCommand, Status: pipeline.StatusSuccess},
+				{Name: "e2e", Kind: pipeline.KindCommand, Status: pipeline.StatusFailed},
+			}},
+			{Name: "deploy", Kind: pipeline.KindCommand, Status: pipeline.StatusSkipped},
+		},
+	}
+
+	tests := map[string]struct {
+		format pipeline.GraphFormat
+		report *pipeline.Result
+		want   string
+	}{
+		"tree": {
+			format: pipeline.GraphTree,
+			want: `root (serial)
+├── build (command, timeout: 1m0s)
+├── test (parallel)
+│   ├── unit (command)
+│   └── e2e (command, onExit: propagate)
+└── deploy (command)
+`,
+		},
+		"tree with report": {
+			format: pipeline.GraphTree,
+			report: report,
+			want: `root (serial, status: failed)
+├── build (command, timeout: 1m0s, status: success)
+├── test (parallel, status: failed)
+│   ├── unit (command, status: success)
+│   └── e2e (command, onExit: propagate, status: failed)
+└── deploy (command, status: skipped)
+`,
+		},
+		"dot": {
+			format: pipeline.GraphDOT,
+			report: report,
+			want: `digraph "root" {
+  node [shape=box, style=rounded, fontname="Helvetica"];
+  subgraph cluster_n1 {
+    label="root\nserial\nstatus: failed";
+    style=dashed;
+    n2 [label="build\necho build\ntimeout: 1m0s\nstatus: success", style="rounded,filled", fillcolor="#c8e6c9"];
+    subgraph cluster_n3 {
+      label="test\nparallel\nstatus: failed";
+      style=dashed;
+      n3_fork [shape=point];
+      n3_join [shape=point];
+      n4 [label="unit\ntrue\nstatus: success", style="rounded,filled", fillcolor="#c8e6c9"];
+      n5 [label="e2e\nsh -c 'exit 1'\nonExit: propagate\nstatus: failed", style="rounded,filled", fillcolor="#ffcdd2"];
+    }
+    n6 [label="deploy\necho deploy\nstatus: skipped", style="rounded,filled", fillcolor="#f5f5f5"];
+  }
+  n3_fork -> n4;
+  n4 -> n3_join;
+  n3_fork -> n5;
+  n5 -> n3_join;
+  n2 -> n3_fork;
+  n3_join -> n6;
+}
+`,
+		},
+		"mermaid": {
+			format: pipeline.GraphMermaid,
+			want: `flowchart TD
+  subgraph n1 ["root<br/>serial"]
+    n2["build<br/>echo build<br/>timeout: 1m0s"]
+    subgraph n3 ["test<br/>parallel"]
+      n3_fork((" "))
+      n3_join((" "))
+      n4["unit<br/>true"]
+      n5["e2e<br/>sh -c 'exit 1'<br/>onExit: propagate"]
+    end
+    n6["deploy<br/>echo deploy"]
+  end
+  n3_fork --> n4
+  n4 --> n3_join
+  n3_fork --> n5
+  n5 --> n3_join
+  n2 --> n3_fork
+  n3_join --> n6
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+
+			require.NoError(t, node.WriteGraph(&buf, tt.format, tt.report))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestParseGraphFormat(t *testing.T) {
+	t.Parallel()
+
+	format, err := pipeline.ParseGraphFormat("Mermaid")
+	require.NoError(t, err)
+	assert.Equal(t, pipeline.GraphMermaid, format)
+
+	_, err = pipeline.ParseGraphFormat("svg")
+	assert.EqualError(t, err, "svg: unknown graph format (use tree, dot or mermaid)")
+}
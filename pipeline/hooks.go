@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// Hook names, also the PIPELINE_EVENT values.
+const (
+	HookOnFailure = "onFailure"
+	HookOnRestart = "onRestart"
+	HookOnStart   = "onStart"
+	HookOnSuccess = "onSuccess"
+)
+
+// Environment variables of the hook processes.
+const (
+	EnvError    = "PIPELINE_ERROR"
+	EnvEvent    = "PIPELINE_EVENT"
+	EnvExitCode = "PIPELINE_EXIT_CODE"
+	EnvNode     = "PIPELINE_NODE"
+	EnvRestarts = "PIPELINE_RESTARTS"
+)
+
+// Hooks lists the nodes to run on the lifecycle events of a node. Hooks are run with the PIPELINE_* variables
+// describing the event; their failures are logged and do not affect the node.
+type Hooks struct {
+	OnFailure *Node `json:"onFailure,omitempty" toml:"onFailure,omitempty" yaml:"onFailure,omitempty"`
+	OnRestart *Node `json:"onRestart,omitempty" toml:"onRestart,omitempty" yaml:"onRestart,omitempty"`
+	OnStart   *Node `json:"onStart,omitempty"   toml:"onStart,omitempty"   yaml:"onStart,omitempty"`
+	OnSuccess *Node `json:"onSuccess,omitempty" toml:"onSuccess,omitempty" yaml:"onSuccess,omitempty"`
+}
+
+// list returns the defined hooks by name.
+func (h Hooks) list() map[string]*Node {
+	hooks := make(map[string]*Node)
+
+	for name, hook := range map[string]*Node{
+		HookOnFailure: h.OnFailure,
+		HookOnRestart: h.OnRestart,
+		HookOnStart:   h.OnStart,
+		HookOnSuccess: h.OnSuccess,
+	} {
+		if hook != nil {
+			hooks[name] = hook
+		}
+	}
+
+	return hooks
+}
+
+// clone returns a copy of the hooks, with copies of their nodes (see Node.clone).
+func (h Hooks) clone() Hooks {
+	for _, hook := range []**Node{&h.OnFailure, &h.OnRestart, &h.OnStart, &h.OnSuccess} {
+		if *hook != nil {
+			inst := (*hook).clone()
+			*hook = &inst
+		}
+	}
+
+	return h
+}
+
+// runHook runs a hook of the node, if defined. err is the node error, for onFailure.
+func (n *Node) runHook(ctx context.Context, name string, err error) {
+	hook, ok := n.Hooks.list()[name]
+	if !ok {
+		return
+	}
+
+	env := []string{
+		EnvEvent + "=" + name,
+		EnvNode + "=" + n.Path(),
+		EnvRestarts + "=" + strconv.Itoa(n.restarts),
+	}
+
+	if n.proc != nil && n.proc.ProcessState() != nil {
+		env = append(env, EnvExitCode+"="+strconv.Itoa(n.proc.ProcessState().ExitCode()))
+	}
+
+	if err != nil {
+		env = append(env, EnvError+"="+err.Error())
+	}
+
+	inst := hook.clone()
+	inst.captures = n.captures
+	inst.env = env
+	inst.path = n.Path() + "/" + name
+	inst.WithLogger(n.logger).WithTracer(n.tracer)
+
+	n.logger.Debug("running hook", zap.String("hook", name))
+
+	if err := inst.Run(ctx); err != nil {
+		n.logger.Warn("hook failed", zap.String("hook", name), zap.Error(err))
+	}
+}
+
+// environ returns the environment of the node processes, with some extra variables, or nil to inherit the
+// current environment.
+func (n *Node) environ(extra ...string) []string {
+	if len(n.env) == 0 && len(extra) == 0 {
+		return nil
+	}
+
+	env := make([]string, 0, len(n.env)+len(extra))
+	env = append(env, os.Environ()...)
+	env = append(env, n.env...)
+
+	return append(env, extra...)
+}
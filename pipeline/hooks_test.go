@@ -0,0 +1,97 @@
+package pipeline_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "hooks.log")
+	record := func() *pipeline.Builder {
+		return pipeline.Command("sh", "-c",
+			`echo "$PIPELINE_EVENT $PIPELINE_NODE code=$PIPELINE_EXIT_CODE restarts=$PIPELINE_RESTARTS error=$PIPELINE_ERROR" >> $0`,
+			out)
+	}
+
+	tests := map[string]struct {
+		builder *pipeline.Builder
+		err     string
+		want    []string
+	}{
+		"success": {
+			builder: pipeline.Serial(
+				pipeline.Command("true").Name("ok").OnStart(record()).OnSuccess(record()).OnFailure(record()),
+			).Name("root"),
+			want: []string{
+				"onStart root/ok code= restarts=0 error=",
+				"onSuccess root/ok code=0 restarts=0 error=",
+			},
+		},
+		"failure": {
+			builder: pipeline.Serial(
+				pipeline.Command("sh", "-c", "exit 3").Name("exit-3").OnSuccess(record()),
+			).Name("root").OnFailure(record()),
+			err: "task root: iteration aborted: task exit-3: exit status 3",
+			want: []string{
+				"onFailure root code= restarts=0 error=iteration aborted: task exit-3: exit status 3",
+			},
+		},
+		"restart": {
+			builder: pipeline.Command("sh", "-c", "test -e $0 || { touch $0; exit 1; }", filepath.Join(dir, "flag")).
+				Name("flaky").
+				OnExit(loop.ExitPolicyRestartIfErr).
+				OnRestart(record()).
+				OnSuccess(record()),
+			want: []string{
+				"onRestart flaky code=1 restarts=1 error=",
+				"onSuccess flaky code=0 restarts=1 error=",
+			},
+		},
+		"failing hook": {
+			builder: pipeline.Command("true").Name("ok").OnStart(pipeline.Command("false")).OnSuccess(record()),
+			want: []string{
+				"onSuccess ok code=0 restarts=0 error=",
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, os.RemoveAll(out))
+
+			node, err := tt.builder.Log(pipeline.LogConfig{Disabled: true}).Build()
+			require.NoError(t, err)
+
+			if err := node.Run(context.TODO()); tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			data, err := os.ReadFile(out)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, strings.Split(strings.TrimSpace(string(data)), "\n"))
+		})
+	}
+}
+
+func TestHooksValidate(t *testing.T) {
+	t.Parallel()
+
+	node := pipeline.Command("true").OnFailure(pipeline.Serial().OnExit("bogus")).Node()
+
+	assert.EqualError(t, node.Validate(), "true.hooks.onFailure: bogus: unknown exit policy")
+}
@@ -0,0 +1,143 @@
+package pipeline
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// junitSuites is the root element of a JUnit XML report.
+	junitSuites struct {
+		XMLName  xml.Name     `xml:"testsuites"`
+		Name     string       `xml:"name,attr"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Skipped  int          `xml:"skipped,attr"`
+		Time     float64      `xml:"time,attr"`
+		Suites   []junitSuite `xml:"testsuite"`
+	}
+
+	// junitSuite maps a serial or parallel group.
+	junitSuite struct {
+		Name      string      `xml:"name,attr"`
+		Tests     int         `xml:"tests,attr"`
+		Failures  int         `xml:"failures,attr"`
+		Skipped   int         `xml:"skipped,attr"`
+		Time      float64     `xml:"time,attr"`
+		Timestamp string      `xml:"timestamp,attr,omitempty"`
+		Cases     []junitCase `xml:"testcase"`
+	}
+
+	// junitCase maps a command (or plugin) node.
+	junitCase struct {
+		Name      string        `xml:"name,attr"`
+		ClassName string        `xml:"classname,attr"`
+		Time      float64       `xml:"time,attr"`
+		Failure   *junitMessage `xml:"failure,omitempty"`
+		Skipped   *junitMessage `xml:"skipped,omitempty"`
+		SystemErr string        `xml:"system-err,omitempty"`
+	}
+
+	// junitMessage is a failure or a skipped element.
+	junitMessage struct {
+		Message string `xml:"message,attr,omitempty"`
+		Type    string `xml:"type,attr,omitempty"`
+		Text    string `xml:",chardata"`
+	}
+)
+
+// WriteJUnit writes the report as JUnit XML: every command is a testcase, grouped in a testsuite per serial or
+// parallel node.
+func (r *Result) WriteJUnit(w io.Writer) error {
+	doc := junitSuites{ // nolint:exhaustruct // counters are computed below
+		Name: r.Name,
+		Time: r.Duration.Duration().Seconds(),
+	}
+
+	r.junitSuites(r.Name, &doc.Suites)
+
+	for _, suite := range doc.Suites {
+		doc.Tests += suite.Tests
+		doc.Failures += suite.Failures
+		doc.Skipped += suite.Skipped
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return errors.Wrap(err, "junit")
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(doc); err != nil {
+		return errors.Wrap(err, "junit")
+	}
+
+	_, err := io.WriteString(w, "\n")
+
+	return errors.Wrap(err, "junit")
+}
+
+// junitSuites appends a testsuite for the node (when it has test cases) and for each nested group.
+func (r *Result) junitSuites(path string, suites *[]junitSuite) {
+	suite := junitSuite{Name: path, Time: r.Duration.Duration().Seconds()} // nolint:exhaustruct // see below
+	if !r.Start.IsZero() {
+		suite.Timestamp = r.Start.Format("2006-01-02T15:04:05")
+	}
+
+	switch r.Kind {
+	case KindCommand, KindPlugin:
+		suite.add(r.junitCase(path))
+	default:
+		for _, child := range r.Children {
+			if child.Kind == KindCommand || child.Kind == KindPlugin {
+				suite.add(child.junitCase(path))
+			}
+		}
+	}
+
+	if len(suite.Cases) > 0 {
+		*suites = append(*suites, suite)
+	}
+
+	for _, child := range r.Children {
+		if child.Kind == KindParallel || child.Kind == KindSerial {
+			child.junitSuites(path+"/"+child.Name, suites)
+		}
+	}
+}
+
+// junitCase converts a command result into a test case.
+func (r *Result) junitCase(className string) junitCase {
+	tcase := junitCase{ // nolint:exhaustruct // optional elements
+		ClassName: className,
+		Name:      r.Name,
+		SystemErr: r.Stderr,
+		Time:      r.Duration.Duration().Seconds(),
+	}
+
+	switch r.Status {
+	case StatusFailed, StatusTimedOut:
+		tcase.Failure = &junitMessage{Message: r.Error, Type: string(r.Status), Text: r.Stderr}
+	case StatusCancelled, StatusSkipped:
+		tcase.Skipped = &junitMessage{Message: string(r.Status), Type: "", Text: ""}
+	case StatusSuccess:
+	}
+
+	return tcase
+}
+
+// add appends a test case and updates the counters.
+func (s *junitSuite) add(tcase junitCase) {
+	s.Cases = append(s.Cases, tcase)
+	s.Tests++
+
+	switch {
+	case tcase.Failure != nil:
+		s.Failures++
+	case tcase.Skipped != nil:
+		s.Skipped++
+	}
+}
@@ -0,0 +1,73 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, time.October, 19, 18, 30, 0, 0, time.UTC)
+	code := 2
+
+	result := &pipeline.Result{
+		Kind:     pipeline.KindSerial,
+		Name:     "ci",
+		Duration: pipeline.Duration(3 * time.Second),
+		Start:    start,
+		Status:   pipeline.StatusFailed,
+		Children: []*pipeline.Result{
+			{Kind: pipeline.KindCommand, Name: "lint", Duration: pipeline.Duration(time.Second), Start: start, Status: pipeline.StatusSuccess},
+			{
+				Kind:     pipeline.KindParallel,
+				Name:     "shards",
+				Duration: pipeline.Duration(2 * time.Second),
+				Start:    start,
+				Status:   pipeline.StatusFailed,
+				Children: []*pipeline.Result{
+					{
+						Kind:     pipeline.KindCommand,
+						Name:     "shard-1",
+						Duration: pipeline.Duration(1500 * time.Millisecond),
+						Error:    "exit status 2",
+						ExitCode: &code,
+						Start:    start,
+						Status:   pipeline.StatusFailed,
+						Stderr:   "FAIL: TestA <oops>\n",
+					},
+					{Kind: pipeline.KindCommand, Name: "shard-2", Start: start, Status: pipeline.StatusCancelled},
+				},
+			},
+			{Kind: pipeline.KindCommand, Name: "package", Status: pipeline.StatusSkipped},
+		},
+	}
+
+	var buf bytes.Buffer
+
+	require.NoError(t, result.WriteJUnit(&buf))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="ci" tests="4" failures="1" skipped="2" time="3">
+  <testsuite name="ci" tests="2" failures="0" skipped="1" time="3" timestamp="2026-10-19T18:30:00">
+    <testcase name="lint" classname="ci" time="1"></testcase>
+    <testcase name="package" classname="ci" time="0">
+      <skipped message="skipped"></skipped>
+    </testcase>
+  </testsuite>
+  <testsuite name="ci/shards" tests="2" failures="1" skipped="1" time="2" timestamp="2026-10-19T18:30:00">
+    <testcase name="shard-1" classname="ci/shards" time="1.5">
+      <failure message="exit status 2" type="failed">FAIL: TestA &lt;oops&gt;&#xA;</failure>
+      <system-err>FAIL: TestA &lt;oops&gt;&#xA;</system-err>
+    </testcase>
+    <testcase name="shard-2" classname="ci/shards" time="0">
+      <skipped message="cancelled"></skipped>
+    </testcase>
+  </testsuite>
+</testsuites>
+`, buf.String())
+}
@@ -0,0 +1,60 @@
+package pipeline
+
+import "sync"
+
+const (
+	// logBufferSize is the number of output bytes retained per command by Supervisor.
+	logBufferSize = 64 * 1024
+	// logBacklog is the number of writes queued per follower before they are dropped.
+	logBacklog = 256
+)
+
+// logBuffer retains the last output of a command and broadcasts the new one to its followers.
+type logBuffer struct {
+	mtx       sync.Mutex
+	data      []byte
+	followers map[chan []byte]struct{}
+}
+
+// newLogBuffer returns an empty buffer.
+func newLogBuffer() *logBuffer {
+	return &logBuffer{mtx: sync.Mutex{}, data: nil, followers: make(map[chan []byte]struct{})}
+}
+
+// Write implements io.Writer. Slow followers miss the writes that do not fit in their backlog.
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.data = append(b.data, p...)
+	if overflow := len(b.data) - logBufferSize; overflow > 0 {
+		b.data = append(b.data[:0], b.data[overflow:]...)
+	}
+
+	for ch := range b.followers {
+		select {
+		case ch <- append([]byte(nil), p...):
+		default:
+		}
+	}
+
+	return len(p), nil
+}
+
+// follow returns the retained output and a channel receiving the next writes, until stop is called.
+func (b *logBuffer) follow() ([]byte, <-chan []byte, func()) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	ch := make(chan []byte, logBacklog)
+	b.followers[ch] = struct{}{}
+
+	stop := func() {
+		b.mtx.Lock()
+		defer b.mtx.Unlock()
+
+		delete(b.followers, ch)
+	}
+
+	return append([]byte(nil), b.data...), ch, stop
+}
@@ -3,13 +3,16 @@ package pipeline
 import (
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 // LogConfig.
+//
+// Level (debug, info, warn or error) overrides the default level of the logger: info, or debug with Debug set.
 type LogConfig struct {
-	Debug    bool   `yaml:"debug"`
-	Disabled bool   `yaml:"disabled"`
-	Level    string `yaml:"level"`
+	Debug    bool   `json:"debug,omitempty"    toml:"debug,omitempty"    yaml:"debug,omitempty"`
+	Disabled bool   `json:"disabled,omitempty" toml:"disabled,omitempty" yaml:"disabled,omitempty"`
+	Level    string `json:"level,omitempty"    toml:"level,omitempty"    yaml:"level,omitempty"`
 
 	inst *zap.Logger
 }
@@ -20,20 +23,25 @@ func (l *LogConfig) Logger() (*zap.Logger, error) {
 		return l.inst, nil
 	}
 
-	var (
-		err    error
-		logger *zap.Logger
-	)
-
-	switch {
-	case l.Disabled:
+	if l.Disabled {
 		return zap.NewNop(), nil
-	case l.Debug:
-		logger, err = zap.NewDevelopment()
-	default:
-		logger, err = zap.NewProduction()
 	}
 
+	config := zap.NewProductionConfig()
+	if l.Debug {
+		config = zap.NewDevelopmentConfig()
+	}
+
+	if l.Level != "" {
+		level, err := zapcore.ParseLevel(l.Level)
+		if err != nil {
+			return nil, errors.Wrap(err, "log level")
+		}
+
+		config.Level.SetLevel(level)
+	}
+
+	logger, err := config.Build()
 	if err != nil {
 		return nil, errors.Wrap(err, "zap")
 	}
@@ -42,3 +50,12 @@ func (l *LogConfig) Logger() (*zap.Logger, error) {
 
 	return logger, nil
 }
+
+// WithLogLevel overrides the level of the configured logger (eg: debug, info, warn or error).
+func WithLogLevel(level string) Option {
+	return func(n *Node) {
+		if level != "" {
+			n.LogConfig.Level = level
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package pipeline_test
+
+import (
+	"testing"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLogConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config pipeline.LogConfig
+		err    string
+		want   zapcore.Level
+	}{
+		"default":  {config: pipeline.LogConfig{}, want: zapcore.InfoLevel},
+		"debug":    {config: pipeline.LogConfig{Debug: true}, want: zapcore.DebugLevel},
+		"level":    {config: pipeline.LogConfig{Debug: true, Level: "warn"}, want: zapcore.WarnLevel},
+		"disabled": {config: pipeline.LogConfig{Disabled: true, Level: "debug"}, want: zapcore.FatalLevel + 1},
+		"invalid":  {config: pipeline.LogConfig{Level: "loud"}, err: `log level: unrecognized level: "loud"`},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			logger, err := tt.config.Logger()
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+
+				return
+			}
+
+			assert.NoError(t, err)
+
+			for level := zapcore.DebugLevel; level <= zapcore.FatalLevel; level++ {
+				assert.Equal(t, level >= tt.want, logger.Core().Enabled(level), level)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package loop
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// Control lets other goroutines stop, start, restart and pause a Runner. A nil Control is valid and does
+	// nothing.
+	Control struct {
+		mtx sync.Mutex
+		// cancel stops the current attempt.
+		cancel context.CancelFunc
+		// forced is set when the current attempt was stopped by Stop or Restart.
+		forced  bool
+		paused  bool
+		running bool
+		stopped bool
+		// wake is closed (and replaced) whenever the state changes.
+		wake chan struct{}
+	}
+
+	// ControlState is a snapshot of a Control.
+	ControlState struct {
+		Paused  bool `json:"paused"`
+		Running bool `json:"running"`
+		Stopped bool `json:"stopped"`
+	}
+)
+
+// NewControl returns a control, initially started and not paused.
+func NewControl() *Control {
+	return &Control{ // nolint:exhaustruct // zero state
+		wake: make(chan struct{}),
+	}
+}
+
+// Pause prevents the loop from restarting the task, until Resume. The current attempt is not affected.
+func (c *Control) Pause() {
+	c.update(func() { c.paused = true })
+}
+
+// Resume undoes Pause.
+func (c *Control) Resume() {
+	c.update(func() { c.paused = false })
+}
+
+// Restart stops the current attempt and starts a new one right away, regardless of the exit policy and of Pause.
+func (c *Control) Restart() {
+	c.update(func() {
+		c.stopped = false
+		c.interrupt()
+	})
+}
+
+// Start undoes Stop.
+func (c *Control) Start() {
+	c.update(func() { c.stopped = false })
+}
+
+// Stop stops the current attempt; the loop waits for Start (or for its context to be done).
+func (c *Control) Stop() {
+	c.update(func() {
+		c.stopped = true
+		c.interrupt()
+	})
+}
+
+// State returns the current state.
+func (c *Control) State() ControlState {
+	if c == nil {
+		return ControlState{Paused: false, Running: false, Stopped: false}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return ControlState{Paused: c.paused, Running: c.running, Stopped: c.stopped}
+}
+
+// begin returns the context of a new attempt, along with a function to call once it is over. The function returns
+// whether the attempt was interrupted by Stop or Restart.
+func (c *Control) begin(ctx context.Context) (context.Context, func() bool) {
+	if c == nil {
+		return ctx, func() bool { return false }
+	}
+
+	attempt, cancel := context.WithCancel(ctx)
+
+	c.update(func() {
+		c.cancel = cancel
+		c.forced = false
+		c.running = true
+	})
+
+	return attempt, func() bool {
+		cancel()
+
+		var forced bool
+
+		c.update(func() {
+			forced = c.forced
+			c.cancel = nil
+			c.running = false
+		})
+
+		return forced
+	}
+}
+
+// waitStarted blocks while the loop is stopped.
+func (c *Control) waitStarted(ctx context.Context) error {
+	return c.waitUntil(ctx, func() bool { return !c.stopped })
+}
+
+// waitResumed blocks while the loop is paused or stopped.
+func (c *Control) waitResumed(ctx context.Context) error {
+	return c.waitUntil(ctx, func() bool { return !c.paused || c.forced || c.stopped })
+}
+
+// waitUntil blocks until cond holds (checked with the lock held) or the context is done.
+func (c *Control) waitUntil(ctx context.Context, cond func() bool) error {
+	if c == nil {
+		return nil
+	}
+
+	for {
+		c.mtx.Lock()
+		ok, wake := cond(), c.wake
+		c.mtx.Unlock()
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err() // nolint:wrapcheck // not relevant
+		case <-wake:
+		}
+	}
+}
+
+// interrupt cancels the current attempt, if any (with the lock held).
+func (c *Control) interrupt() {
+	c.forced = true
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// update changes the state and wakes up the waiters.
+func (c *Control) update(change func()) {
+	if c == nil {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	change()
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
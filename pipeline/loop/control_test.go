@@ -0,0 +1,120 @@
+package loop_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/event"
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockTask counts its runs and blocks until its context is done.
+type blockTask struct {
+	runs *int32
+}
+
+func (t blockTask) Run(ctx context.Context) error {
+	atomic.AddInt32(t.runs, 1)
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+// restartCounter counts the restarts.
+type restartCounter struct {
+	event.Nop
+	restarts int32
+}
+
+func (c *restartCounter) Restarting(string, int) {
+	atomic.AddInt32(&c.restarts, 1)
+}
+
+func TestControl(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	var runs int32
+
+	counter := &restartCounter{}
+
+	ctl := loop.NewControl()
+	done := make(chan error)
+
+	go func() {
+		done <- loop.Loop(blockTask{&runs}).
+			WithControl(ctl).
+			WithObserver(counter).
+			Run(ctx)
+	}()
+
+	waitFor := func(cond func() bool) {
+		require.Eventually(t, cond, time.Second, time.Millisecond)
+	}
+
+	waitFor(func() bool { return ctl.State().Running })
+	assert.Equal(t, int32(1), atomic.LoadInt32(&runs))
+
+	// The default policy would end the loop on errors: control interruptions bypass it.
+	ctl.Restart()
+	waitFor(func() bool { return atomic.LoadInt32(&runs) == 2 })
+	assert.Equal(t, int32(1), atomic.LoadInt32(&counter.restarts))
+
+	ctl.Stop()
+	waitFor(func() bool { return !ctl.State().Running })
+	assert.Equal(t, loop.ControlState{Paused: false, Running: false, Stopped: true}, ctl.State())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&runs))
+
+	ctl.Start()
+	waitFor(func() bool { return atomic.LoadInt32(&runs) == 3 })
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestControlPause(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	var runs int32
+
+	ctl := loop.NewControl()
+	ctl.Pause()
+
+	done := make(chan error)
+
+	go func() {
+		done <- loop.Loop(countTask{nil, &runs}, loop.WithPolicy(loop.ExitPolicyRestart)).WithControl(ctl).Run(ctx)
+	}()
+
+	// Paused loops run the current attempt, then wait.
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&runs))
+	assert.True(t, ctl.State().Paused)
+
+	ctl.Resume()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&runs) > 1 }, time.Second, time.Millisecond)
+
+	ctl.Pause()
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestNilControl(t *testing.T) {
+	t.Parallel()
+
+	var ctl *loop.Control
+
+	ctl.Stop()
+	ctl.Restart()
+	assert.Equal(t, loop.ControlState{Paused: false, Running: false, Stopped: false}, ctl.State())
+	assert.NoError(t, loop.Loop(testTask{nil}).WithControl(ctl).Run(context.TODO()))
+}
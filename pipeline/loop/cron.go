@@ -0,0 +1,174 @@
+package loop
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cronSearchYears bounds the search of the next activation (eg: for 30 February, which never comes).
+const cronSearchYears = 5
+
+// ErrCron is returned for invalid cron expressions.
+var ErrCron = errors.New("invalid cron expression")
+
+// cronMacros are the shorthands of the common expressions.
+var cronMacros = map[string]string{ // nolint:gochecknoglobals // lookup table
+	"@annually": "0 0 1 1 *",
+	"@daily":    "0 0 * * *",
+	"@hourly":   "0 * * * *",
+	"@midnight": "0 0 * * *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@yearly":   "0 0 1 1 *",
+}
+
+// Cron is a Schedule following a standard cron expression: minute, hour, day of month, month and day of week (0 or 7
+// is Sunday). Fields can be *, numbers, ranges (1-5) and lists (1,15), with steps (*/5, 0-30/10). When both days are
+// restricted (neither starts with *), either matches (like cron does). Times are local.
+type Cron struct {
+	spec                                   string
+	minutes, hours, days, months, weekdays uint64
+	anyDay, anyWeekday                     bool
+}
+
+// ParseCron parses a cron expression, or one of the macros @yearly, @monthly, @weekly, @daily and @hourly.
+func ParseCron(spec string) (*Cron, error) {
+	expr := strings.TrimSpace(spec)
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 { // nolint:gomnd // cron fields
+		return nil, errors.Wrapf(ErrCron, "%s: expected 5 fields", spec)
+	}
+
+	inst := &Cron{spec: spec} // nolint:exhaustruct // filled below
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*uint64{&inst.minutes, &inst.hours, &inst.days, &inst.months, &inst.weekdays}
+
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", spec)
+		}
+
+		*sets[i] = set
+	}
+
+	// Sunday is both 0 and 7.
+	if inst.weekdays&(1<<7) != 0 {
+		inst.weekdays |= 1
+	}
+
+	// Like cron, a day field starting with * (eg: */2) is not a restriction.
+	inst.anyDay, inst.anyWeekday = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+
+	return inst, nil
+}
+
+// parseCronField returns the set of values of a field, as a bit set.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(field, ",") {
+		expr, step := part, 1
+
+		if pair := strings.SplitN(part, "/", 2); len(pair) == 2 { // nolint:gomnd // range and step
+			value, err := strconv.Atoi(pair[1])
+			if err != nil || value < 1 {
+				return 0, errors.Wrapf(ErrCron, "invalid step %s", part)
+			}
+
+			expr, step = pair[0], value
+		}
+
+		low, high, err := parseCronRange(expr, min, max)
+		if err != nil {
+			return 0, err
+		}
+
+		// A step without range (eg: 5/15) runs until the maximum.
+		if step > 1 && !strings.ContainsAny(expr, "*-") {
+			high = max
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+
+	return set, nil
+}
+
+// parseCronRange parses *, a number or a range of numbers, within the bounds.
+func parseCronRange(expr string, min, max int) (int, int, error) {
+	if expr == "*" {
+		return min, max, nil
+	}
+
+	bounds := strings.SplitN(expr, "-", 2) // nolint:gomnd // low and high
+	values := make([]int, len(bounds))
+
+	for i, bound := range bounds {
+		value, err := strconv.Atoi(bound)
+		if err != nil || value < min || value > max {
+			return 0, 0, errors.Wrapf(ErrCron, "%s out of range %d-%d", expr, min, max)
+		}
+
+		values[i] = value
+	}
+
+	if len(values) == 1 {
+		return values[0], values[0], nil
+	}
+
+	if values[0] > values[1] {
+		return 0, 0, errors.Wrapf(ErrCron, "invalid range %s", expr)
+	}
+
+	return values[0], values[1], nil
+}
+
+// Next implements Schedule: it returns the first matching minute after t (or the zero time if none comes).
+func (c *Cron) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(cronSearchYears, 0, 0)
+
+	for next.Before(limit) {
+		switch {
+		case c.months&(1<<uint(next.Month())) == 0:
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+		case !c.matchesDay(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+		case c.hours&(1<<uint(next.Hour())) == 0:
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+		case c.minutes&(1<<uint(next.Minute())) == 0:
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+
+	return time.Time{}
+}
+
+// String returns the expression.
+func (c *Cron) String() string {
+	return c.spec
+}
+
+// matchesDay returns whether the day of month and the day of week match.
+func (c *Cron) matchesDay(t time.Time) bool {
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+
+	return day || weekday
+}
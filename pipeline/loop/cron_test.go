@@ -0,0 +1,61 @@
+package loop_test
+
+import (
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCron(t *testing.T) {
+	t.Parallel()
+
+	// A Wednesday.
+	from := time.Date(2024, time.May, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := map[string]struct {
+		spec string
+		want time.Time
+		err  string
+	}{
+		"every minute":    {spec: "* * * * *", want: time.Date(2024, time.May, 15, 10, 8, 0, 0, time.UTC)},
+		"every 5 minutes": {spec: "*/5 * * * *", want: time.Date(2024, time.May, 15, 10, 10, 0, 0, time.UTC)},
+		"list":            {spec: "0,30 9-17 * * *", want: time.Date(2024, time.May, 15, 10, 30, 0, 0, time.UTC)},
+		"next day":        {spec: "0 9 * * *", want: time.Date(2024, time.May, 16, 9, 0, 0, 0, time.UTC)},
+		"weekday":         {spec: "0 0 * * 1-5", want: time.Date(2024, time.May, 16, 0, 0, 0, 0, time.UTC)},
+		"sunday as 7":     {spec: "0 0 * * 7", want: time.Date(2024, time.May, 19, 0, 0, 0, 0, time.UTC)},
+		"day or weekday":  {spec: "0 0 1 * 0", want: time.Date(2024, time.May, 19, 0, 0, 0, 0, time.UTC)},
+		"day step":        {spec: "0 0 */2 * 1", want: time.Date(2024, time.May, 27, 0, 0, 0, 0, time.UTC)},
+		"next year":       {spec: "0 0 1 1 *", want: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		"leap day":        {spec: "0 0 29 2 *", want: time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		"step from":       {spec: "50/5 * * * *", want: time.Date(2024, time.May, 15, 10, 50, 0, 0, time.UTC)},
+		"macro":           {spec: "@hourly", want: time.Date(2024, time.May, 15, 11, 0, 0, 0, time.UTC)},
+		"never":           {spec: "0 0 30 2 *", want: time.Time{}},
+		"too few fields":  {spec: "* * * *", err: "* * * *: expected 5 fields: invalid cron expression"},
+		"out of range":    {spec: "60 * * * *", err: "60 * * * *: 60 out of range 0-59: invalid cron expression"},
+		"invalid step":    {spec: "*/0 * * * *", err: "*/0 * * * *: invalid step */0: invalid cron expression"},
+		"reversed range":  {spec: "* 5-1 * * *", err: "* 5-1 * * *: invalid range 5-1: invalid cron expression"},
+		"not a number":    {spec: "* * * jan *", err: "* * * jan *: jan out of range 1-12: invalid cron expression"},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cron, err := loop.ParseCron(tt.spec)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cron.Next(from))
+			assert.Equal(t, tt.spec, cron.String())
+		})
+	}
+}
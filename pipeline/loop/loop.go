@@ -3,23 +3,23 @@ package loop
 
 import (
 	"context"
+	"time"
 
+	"bitbucket.org/lucacontini/z6/pipeline/event"
 	"go.uber.org/zap"
 )
 
-const (
-	ExitPolicyNone           = "none"
-	ExitPolicyRestart        = "restart"
-	ExitPolicyRestartIfErr   = "restart-if-err"
-	ExitPolicyPropagate      = "propagate"
-	ExitPolicyPropagateIfErr = "propagate-if-err"
-)
-
 type (
-	loop struct {
+	// Runner runs a task, restarting it according to its exit policy.
+	Runner struct {
 		task   Task
 		logger *zap.Logger
-		policy string
+		policy ExitPolicy
+		delay  time.Duration
+		// control, if set, lets other goroutines stop, start, restart and pause the loop.
+		control *Control
+		// observer is notified of the restarts, identified by the task ID.
+		observer event.Observer
 	}
 
 	Task interface {
@@ -28,27 +28,100 @@ type (
 )
 
 // Run executes the loop (restarts with ExitPolicyRestart/ExitPolicyRestartIfErr).
-func (l loop) Run(ctx context.Context) error {
-	l.logger.Debug("starting loop", zap.String("policy", l.policy))
-	defer l.logger.Debug("closing loop", zap.String("policy", l.policy))
+func (l Runner) Run(ctx context.Context) error {
+	restarts := 0
+
+	l.logger.Debug("starting loop", zap.String("policy", string(l.policy)))
+	defer l.logger.Debug("closing loop", zap.String("policy", string(l.policy)))
 
 	for {
-		err := l.task.Run(ctx)
-		restart, notify := policyCtl(err, l.policy)
-
-		switch {
-		case notify:
-			return err // nolint:wrapcheck // legit
-		case restart && err != nil:
-			l.logger.Info("ignoring error", zap.String("err", err.Error()))
-		case !restart:
-			return nil
+		if err := l.control.waitStarted(ctx); err != nil {
+			return err
 		}
+
+		attempt, done := l.control.begin(ctx)
+		err := l.task.Run(attempt)
+
+		if done() {
+			// Stopped or restarted through the control: the exit policy does not apply.
+			l.logger.Info("interrupted by control")
+
+			if err := l.control.waitStarted(ctx); err != nil {
+				return err
+			}
+		} else if over, err := l.next(ctx, err); over {
+			return err
+		}
+
+		restarts++
+		l.observer.Restarting(TaskID(l.task), restarts)
 	}
 }
 
+// next applies the exit policy to the result of an attempt, then waits for the delay and for any pause. It returns
+// true when the loop is over, along with its result.
+func (l Runner) next(ctx context.Context, err error) (bool, error) {
+	restart, notify := policyCtl(err, l.policy)
+
+	switch {
+	case notify:
+		return true, err
+	case restart && err != nil:
+		l.logger.Info("ignoring error", zap.String("err", err.Error()))
+	case !restart:
+		return true, nil
+	}
+
+	if err := l.wait(ctx); err != nil {
+		return true, err
+	}
+
+	if err := l.control.waitResumed(ctx); err != nil {
+		return true, err
+	}
+
+	return false, nil
+}
+
+// wait pauses before a restart, if a delay is set. It fails when the context is done.
+func (l Runner) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err // nolint:wrapcheck // not relevant
+	}
+
+	if l.delay <= 0 {
+		return nil
+	}
+
+	l.logger.Debug("delaying restart", zap.Duration("delay", l.delay))
+
+	timer := time.NewTimer(l.delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err() // nolint:wrapcheck // not relevant
+	case <-timer.C:
+		return nil
+	}
+}
+
+// WithControl attaches a control (nil detaches it).
+func (l *Runner) WithControl(control *Control) *Runner {
+	l.control = control
+
+	return l
+}
+
+// WithDelay sets a pause between restarts.
+func (l *Runner) WithDelay(delay time.Duration) *Runner {
+	l.delay = delay
+
+	return l
+}
+
 // WithLogger sets up the logger.
-func (l *loop) WithLogger(logger *zap.Logger) *loop {
+func (l *Runner) WithLogger(logger *zap.Logger) *Runner {
 	if logger == nil {
 		logger = zap.NewNop()
 	}
@@ -58,40 +131,36 @@ func (l *loop) WithLogger(logger *zap.Logger) *loop {
 	return l
 }
 
-// WithPolicy changes the exit policy.
-func (l *loop) WithPolicy(policy string) *loop {
-	if policy == "" {
-		l.policy = ExitPolicyPropagateIfErr
-	} else {
-		l.policy = policy
+// WithObserver sets up the lifecycle observer.
+func (l *Runner) WithObserver(observer event.Observer) *Runner {
+	if observer == nil {
+		observer = event.Nop{}
 	}
 
+	l.observer = observer
+
 	return l
 }
 
-// Loop constructor.
-func Loop(task Task) *loop {
-	inst := &loop{
-		logger: nil,
-		policy: "",
-		task:   task,
-	}
+// WithPolicy changes the exit policy.
+func (l *Runner) WithPolicy(policy ExitPolicy) *Runner {
+	l.policy = policy.orDefault()
 
-	return inst.WithLogger(nil).WithPolicy("")
+	return l
 }
 
-// policyCtl returns whether to restart and/or notify a result.
-func policyCtl(err error, policy string) (bool, bool) {
-	switch policy {
-	case ExitPolicyNone:
-		return false, false
-	case ExitPolicyRestart:
-		return true, false
-	case ExitPolicyRestartIfErr:
-		return err != nil, false
-	case ExitPolicyPropagate:
-		return false, true
-	default: // ExitPolicyPropagateIfErr
-		return false, err != nil
+// Loop constructor.
+func Loop(task Task, opts ...Option) *Runner {
+	cfg := newOptions(opts)
+
+	inst := &Runner{
+		control:  nil,
+		delay:    0,
+		logger:   nil,
+		observer: nil,
+		policy:   "",
+		task:     task,
 	}
+
+	return inst.WithLogger(cfg.logger).WithObserver(cfg.observer).WithPolicy(cfg.policy).WithDelay(cfg.delay)
 }
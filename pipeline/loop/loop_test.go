@@ -3,6 +3,16 @@ package loop_test
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/event"
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -17,3 +27,195 @@ type testTask struct {
 func (t testTask) Run(_ context.Context) error {
 	return t.err
 }
+
+type countTask struct {
+	err  error
+	runs *int32
+}
+
+func (t countTask) Run(_ context.Context) error {
+	atomic.AddInt32(t.runs, 1)
+
+	return t.err
+}
+
+func TestLoopCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	var runs int32
+
+	// The task fails because the loop is cancelled (eg: a process killed through its context): it is not restarted.
+	err := loop.Loop(loop.TaskFunc(func(context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		cancel()
+
+		return errA
+	}), loop.WithPolicy(loop.ExitPolicyRestartIfErr)).Run(ctx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&runs))
+}
+
+func TestLoopDelay(t *testing.T) {
+	t.Parallel()
+
+	const delay = 20 * time.Millisecond
+
+	// The runner is configured either with options or with methods.
+	testTable := map[string]func(task loop.Task) *loop.Runner{
+		"Methods": func(task loop.Task) *loop.Runner {
+			return loop.Loop(task).WithPolicy(loop.ExitPolicyRestartIfErr).WithDelay(delay)
+		},
+		"Options": func(task loop.Task) *loop.Runner {
+			return loop.Loop(
+				task,
+				loop.WithPolicy(loop.ExitPolicyRestartIfErr),
+				loop.WithDelay(delay),
+				loop.WithLogger(nil),
+			)
+		},
+	}
+
+	for name, runner := range testTable {
+		runner := runner
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+
+			// The task fails on every run, and cancels the loop on the third one.
+			runs := make(chan time.Time, 3)
+			task := loop.TaskFunc(func(context.Context) error {
+				runs <- time.Now()
+
+				if len(runs) == cap(runs) {
+					cancel()
+				}
+
+				return errA
+			})
+
+			err := runner(task).Run(ctx)
+			close(runs)
+
+			assert.ErrorIs(t, err, context.Canceled)
+			require.Len(t, runs, 3)
+
+			// Only the lower bound is checked, which holds however loaded the machine is.
+			last := <-runs
+			for run := range runs {
+				assert.GreaterOrEqual(t, run.Sub(last), delay)
+				last = run
+			}
+		})
+	}
+}
+
+func TestLoopDelayCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	started, done := make(chan struct{}, 1), make(chan error, 1)
+	task := loop.TaskFunc(func(context.Context) error {
+		started <- struct{}{}
+
+		return errA
+	})
+
+	go func() {
+		done <- loop.Loop(task).WithPolicy(loop.ExitPolicyRestartIfErr).WithDelay(time.Hour).Run(ctx)
+	}()
+
+	// The loop returns as soon as it is cancelled, without waiting for the delay.
+	<-started
+	cancel()
+
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+// recorder records the restarts and skips.
+type recorder struct {
+	event.Nop
+	mtx    sync.Mutex
+	events []string
+}
+
+func (r *recorder) Restarting(node string, restarts int) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.events = append(r.events, fmt.Sprintf("restarting %s %d", node, restarts))
+}
+
+func (r *recorder) Skipped(node string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.events = append(r.events, "skipped "+node)
+}
+
+func TestObserver(t *testing.T) {
+	t.Parallel()
+
+	// flaky fails twice, then succeeds.
+	flaky := func() loop.Task {
+		var runs int32
+
+		return loop.Named("flaky", loop.TaskFunc(func(context.Context) error {
+			if atomic.AddInt32(&runs, 1) < 3 {
+				return errA
+			}
+
+			return nil
+		}))
+	}
+
+	tests := map[string]struct {
+		runner func(obs event.Observer) loop.Task
+		want   []string
+	}{
+		"loop": {
+			runner: func(obs event.Observer) loop.Task {
+				return loop.Loop(flaky(), loop.WithPolicy(loop.ExitPolicyRestartIfErr), loop.WithObserver(obs))
+			},
+			want: []string{"restarting flaky 1", "restarting flaky 2"},
+		},
+		"parallel": {
+			runner: func(obs event.Observer) loop.Task {
+				return loop.Parallel([]loop.Task{flaky()}, loop.WithPolicy(loop.ExitPolicyRestartIfErr), loop.WithObserver(obs))
+			},
+			want: []string{"restarting flaky 1", "restarting flaky 2"},
+		},
+		"serial": {
+			runner: func(obs event.Observer) loop.Task {
+				return loop.Serial([]loop.Task{
+					loop.Named("a", testTask{nil}),
+					loop.Named("b", testTask{errA}),
+					loop.Named("c", testTask{nil}),
+					testTask{nil},
+				}, loop.WithObserver(obs))
+			},
+			want: []string{"skipped c", "skipped loop_test.testTask"},
+		},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			obs := &recorder{}
+			_ = tt.runner(obs).Run(context.TODO())
+
+			assert.Equal(t, tt.want, obs.events)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package loop
+
+import (
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/event"
+	"go.uber.org/zap"
+)
+
+type (
+	// Option configures a runner when it is constructed.
+	Option func(*options)
+
+	// options holds the settings shared by all runners.
+	options struct {
+		delay    time.Duration
+		logger   *zap.Logger
+		observer event.Observer
+		policy   ExitPolicy
+	}
+)
+
+// WithDelay sets a pause between restarts (only relevant for Loop).
+func WithDelay(delay time.Duration) Option {
+	return func(o *options) {
+		o.delay = delay
+	}
+}
+
+// WithLogger sets up the logger.
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithObserver sets up the lifecycle observer.
+func WithObserver(observer event.Observer) Option {
+	return func(o *options) {
+		o.observer = observer
+	}
+}
+
+// WithPolicy sets the exit policy (for Parallel, the policy of the initial tasks).
+func WithPolicy(policy ExitPolicy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+// newOptions applies a list of options over the defaults.
+func newOptions(opts []Option) options {
+	inst := options{delay: 0, logger: nil, observer: nil, policy: ""}
+	for _, opt := range opts {
+		opt(&inst)
+	}
+
+	return inst
+}
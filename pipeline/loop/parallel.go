@@ -4,26 +4,28 @@ import (
 	"context"
 	"sync"
 
+	"bitbucket.org/lucacontini/z6/pipeline/event"
 	"go.uber.org/zap"
 )
 
 type (
-	parallel struct {
-		routine []routine
-		logger  *zap.Logger
-		policy  string
+	// ParallelRunner runs a list of tasks concurrently.
+	ParallelRunner struct {
+		routine  []routine
+		logger   *zap.Logger
+		observer event.Observer
 	}
 
 	routine struct {
 		idx      int
 		task     Task
-		parallel *parallel
-		policy   string
+		parallel *ParallelRunner
+		policy   ExitPolicy
 	}
 )
 
 // Run executes multiple routines concurrently.
-func (p parallel) Run(ctx context.Context) error {
+func (p ParallelRunner) Run(ctx context.Context) error {
 	var wgr sync.WaitGroup
 
 	length := len(p.routine)
@@ -36,11 +38,17 @@ func (p parallel) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	// Routines return results asynchronously
-	resCh := make(chan error, 1)
+	// Routines return results asynchronously (one slot each, so that none blocks once Run returns)
+	resCh := make(chan error, length+1)
 
 	wgr.Add(length)
 
+	// Stop the remaining routines and wait for them, so that none outlives Run.
+	defer func() {
+		cancel()
+		wgr.Wait()
+	}()
+
 	// Spawn routines
 	for _, coro := range p.routine {
 		go func(task routine) {
@@ -82,7 +90,7 @@ func (p parallel) Run(ctx context.Context) error {
 }
 
 // AddTask inserts a new routine in the parallel queue.
-func (p *parallel) AddTask(task Task, policy string) {
+func (p *ParallelRunner) AddTask(task Task, policy ExitPolicy) {
 	p.routine = append(p.routine, routine{
 		idx:      len(p.routine),
 		parallel: p,
@@ -92,7 +100,7 @@ func (p *parallel) AddTask(task Task, policy string) {
 }
 
 // WithLogger sets up the logger.
-func (p *parallel) WithLogger(logger *zap.Logger) *parallel {
+func (p *ParallelRunner) WithLogger(logger *zap.Logger) *ParallelRunner {
 	if logger == nil {
 		logger = zap.NewNop()
 	}
@@ -102,13 +110,25 @@ func (p *parallel) WithLogger(logger *zap.Logger) *parallel {
 	return p
 }
 
+// WithObserver sets up the lifecycle observer, notified of the routine restarts.
+func (p *ParallelRunner) WithObserver(observer event.Observer) *ParallelRunner {
+	if observer == nil {
+		observer = event.Nop{}
+	}
+
+	p.observer = observer
+
+	return p
+}
+
 // Run executes a single concurrent task.
 func (r routine) Run(ctx context.Context) error {
 	logger := r.parallel.logger.With(zap.Int("routine", r.idx))
 
 	loop := Loop(r.task).
 		WithPolicy(r.policy).
-		WithLogger(logger)
+		WithLogger(logger).
+		WithObserver(r.parallel.observer)
 
 	err := loop.Run(ctx)
 
@@ -124,12 +144,14 @@ func (r routine) Run(ctx context.Context) error {
 	return nil
 }
 
-// Parallel returns an executable loop.
-func Parallel(tasks []Task) *parallel {
-	inst := &parallel{logger: nil, policy: "", routine: nil}
+// Parallel returns an executable loop. The tasks are added with the WithPolicy option (ExitPolicyPropagateIfErr by default).
+func Parallel(tasks []Task, opts ...Option) *ParallelRunner {
+	cfg := newOptions(opts)
+
+	inst := &ParallelRunner{logger: nil, observer: nil, routine: nil}
 	for _, task := range tasks {
-		inst.AddTask(task, ExitPolicyPropagateIfErr)
+		inst.AddTask(task, cfg.policy.orDefault())
 	}
 
-	return inst.WithLogger(nil)
+	return inst.WithLogger(cfg.logger).WithObserver(cfg.observer)
 }
@@ -2,6 +2,7 @@ package loop_test
 
 import (
 	"context"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -58,6 +59,16 @@ func TestParallelRun(t *testing.T) {
 				err: errA,
 			},
 		},
+		"With exit policy option": {
+			fields: fields{
+				instance: func(t *testing.T) loop.Task {
+					t.Helper()
+
+					return loop.Parallel([]loop.Task{testTask{nil}, testTask{errA}}, loop.WithPolicy(loop.ExitPolicyNone))
+				},
+			},
+			want: want{},
+		},
 		"With exit policy - none": {
 			fields: fields{
 				instance: func(t *testing.T) loop.Task {
@@ -153,6 +164,26 @@ func TestParallelRun(t *testing.T) {
 	}
 }
 
+func TestParallelWait(t *testing.T) {
+	t.Parallel()
+
+	var stopped int32
+
+	// The second task only stops once cancelled, following the failure of the first one.
+	err := loop.Parallel([]loop.Task{
+		testTask{errA},
+		loop.TaskFunc(func(ctx context.Context) error {
+			<-ctx.Done()
+			atomic.StoreInt32(&stopped, 1)
+
+			return ctx.Err()
+		}),
+	}).Run(context.TODO())
+
+	assert.ErrorIs(t, err, errA)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&stopped), "returned before the task stopped")
+}
+
 // nolint:ireturn // ok
 func mkParallel(t *testing.T, tasks ...loop.Task) loop.Task {
 	t.Helper()
@@ -0,0 +1,87 @@
+package loop
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ExitPolicy controls what happens when a task exits.
+type ExitPolicy string
+
+const (
+	// ExitPolicyNone ignores the task result.
+	ExitPolicyNone ExitPolicy = "none"
+	// ExitPolicyRestart restarts the task, whatever the result.
+	ExitPolicyRestart ExitPolicy = "restart"
+	// ExitPolicyRestartIfErr restarts the task when it fails.
+	ExitPolicyRestartIfErr ExitPolicy = "restart-if-err"
+	// ExitPolicyPropagate reports the task result, even when it succeeds.
+	ExitPolicyPropagate ExitPolicy = "propagate"
+	// ExitPolicyPropagateIfErr reports the task result when it fails (default).
+	ExitPolicyPropagateIfErr ExitPolicy = "propagate-if-err"
+)
+
+// ErrExitPolicy is returned for unknown exit policies.
+var ErrExitPolicy = errors.New("unknown exit policy")
+
+// ParseExitPolicy converts a string into a valid ExitPolicy. An empty string is the default policy.
+func ParseExitPolicy(str string) (ExitPolicy, error) {
+	policy := ExitPolicy(str)
+	if err := policy.Validate(); err != nil {
+		return "", err
+	}
+
+	return policy.orDefault(), nil
+}
+
+// Validate returns ErrExitPolicy when the policy is unknown. An empty policy is valid.
+func (p ExitPolicy) Validate() error {
+	switch p {
+	case "", ExitPolicyNone, ExitPolicyRestart, ExitPolicyRestartIfErr, ExitPolicyPropagate, ExitPolicyPropagateIfErr:
+		return nil
+	default:
+		return errors.Wrap(ErrExitPolicy, string(p))
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler (used by the JSON and TOML decoders).
+func (p *ExitPolicy) UnmarshalText(text []byte) error {
+	policy := ExitPolicy(text)
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	*p = policy
+
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (p *ExitPolicy) UnmarshalYAML(value *yaml.Node) error {
+	return errors.Wrapf(p.UnmarshalText([]byte(value.Value)), "line %d", value.Line)
+}
+
+// orDefault replaces an empty policy with ExitPolicyPropagateIfErr.
+func (p ExitPolicy) orDefault() ExitPolicy {
+	if p == "" {
+		return ExitPolicyPropagateIfErr
+	}
+
+	return p
+}
+
+// policyCtl returns whether to restart and/or notify a result.
+func policyCtl(err error, policy ExitPolicy) (bool, bool) {
+	switch policy {
+	case ExitPolicyNone:
+		return false, false
+	case ExitPolicyRestart:
+		return true, false
+	case ExitPolicyRestartIfErr:
+		return err != nil, false
+	case ExitPolicyPropagate:
+		return false, true
+	default: // ExitPolicyPropagateIfErr
+		return false, err != nil
+	}
+}
@@ -0,0 +1,66 @@
+package loop_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseExitPolicy(t *testing.T) {
+	t.Parallel()
+
+	type want struct {
+		err    string
+		policy loop.ExitPolicy
+	}
+
+	testTable := map[string]want{
+		"":                 {policy: loop.ExitPolicyPropagateIfErr},
+		"none":             {policy: loop.ExitPolicyNone},
+		"restart":          {policy: loop.ExitPolicyRestart},
+		"restart-if-err":   {policy: loop.ExitPolicyRestartIfErr},
+		"propagate":        {policy: loop.ExitPolicyPropagate},
+		"propagate-if-err": {policy: loop.ExitPolicyPropagateIfErr},
+		"report-if-err":    {err: "report-if-err: unknown exit policy"},
+	}
+
+	for name, unit := range testTable {
+		name, unit := name, unit
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			policy, err := loop.ParseExitPolicy(name)
+			if unit.err != "" {
+				assert.ErrorIs(t, err, loop.ErrExitPolicy)
+				assert.EqualError(t, err, unit.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, unit.policy, policy)
+		})
+	}
+}
+
+func TestExitPolicyUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	var doc struct {
+		OnExit loop.ExitPolicy `json:"onExit" yaml:"onExit"`
+	}
+
+	require.NoError(t, yaml.Unmarshal([]byte("onExit: restart"), &doc))
+	assert.Equal(t, loop.ExitPolicyRestart, doc.OnExit)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"onExit": "none"}`), &doc))
+	assert.Equal(t, loop.ExitPolicyNone, doc.OnExit)
+
+	assert.EqualError(t, yaml.Unmarshal([]byte("onExit: retsart"), &doc), "line 1: retsart: unknown exit policy")
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"onExit": "retsart"}`), &doc), loop.ErrExitPolicy)
+}
@@ -0,0 +1,106 @@
+package loop
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// Factory builds a Task from arbitrary parameters (the `with:` block of a pipeline node).
+	Factory func(params map[string]interface{}) (Task, error)
+
+	// Registry maps task types to their factories.
+	Registry struct {
+		mtx       sync.RWMutex
+		factories map[string]Factory
+	}
+)
+
+var (
+	// ErrDuplicateType is returned when a task type is registered twice.
+	ErrDuplicateType = errors.New("task type already registered")
+	// ErrUnknownType is returned when a task type is not registered.
+	ErrUnknownType = errors.New("unknown task type")
+
+	// defaultRegistry is used by the package level functions.
+	defaultRegistry = NewRegistry() // nolint:gochecknoglobals // process-wide registry
+)
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{mtx: sync.RWMutex{}, factories: make(map[string]Factory)}
+}
+
+// Register adds a task type to the registry.
+func (r *Registry) Register(kind string, factory Factory) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, ok := r.factories[kind]; ok {
+		return errors.Wrap(ErrDuplicateType, kind)
+	}
+
+	r.factories[kind] = factory
+
+	return nil
+}
+
+// Has returns whether a task type is registered.
+func (r *Registry) Has(kind string) bool {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	_, ok := r.factories[kind]
+
+	return ok
+}
+
+// New builds a task of the given type.
+func (r *Registry) New(kind string, params map[string]interface{}) (Task, error) { // nolint:ireturn // factories decide
+	r.mtx.RLock()
+	factory, ok := r.factories[kind]
+	r.mtx.RUnlock()
+
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownType, kind)
+	}
+
+	task, err := factory(params)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create %s", kind)
+	}
+
+	return task, nil
+}
+
+// Types returns the sorted list of registered task types.
+func (r *Registry) Types() []string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	kinds := make([]string, 0, len(r.factories))
+	for kind := range r.factories {
+		kinds = append(kinds, kind)
+	}
+
+	sort.Strings(kinds)
+
+	return kinds
+}
+
+// Register adds a task type to the default registry, so that pipelines can use it via `type: <kind>`.
+func Register(kind string, factory Factory) error {
+	return defaultRegistry.Register(kind, factory)
+}
+
+// Registered returns whether a task type is in the default registry.
+func Registered(kind string) bool {
+	return defaultRegistry.Has(kind)
+}
+
+// NewTask builds a task from the default registry.
+func NewTask(kind string, params map[string]interface{}) (Task, error) { // nolint:ireturn // factories decide
+	return defaultRegistry.New(kind, params)
+}
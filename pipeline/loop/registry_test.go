@@ -0,0 +1,38 @@
+package loop_test
+
+import (
+	"testing"
+
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	registry := loop.NewRegistry()
+
+	require.NoError(t, registry.Register("fail", func(params map[string]interface{}) (loop.Task, error) {
+		if params["error"] == "a" {
+			return testTask{errA}, nil
+		}
+
+		return nil, errB
+	}))
+
+	assert.ErrorIs(t, registry.Register("fail", nil), loop.ErrDuplicateType)
+	assert.True(t, registry.Has("fail"))
+	assert.False(t, registry.Has("other"))
+	assert.Equal(t, []string{"fail"}, registry.Types())
+
+	task, err := registry.New("fail", map[string]interface{}{"error": "a"})
+	require.NoError(t, err)
+	assert.Equal(t, testTask{errA}, task)
+
+	_, err = registry.New("fail", nil)
+	assert.EqualError(t, err, "cannot create fail: error B")
+
+	_, err = registry.New("other", nil)
+	assert.EqualError(t, err, "other: unknown task type")
+}
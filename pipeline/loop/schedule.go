@@ -0,0 +1,254 @@
+package loop
+
+import (
+	"context"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/event"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// OverlapPolicy controls what happens when a scheduled run is due while the previous one is still running.
+type OverlapPolicy string
+
+const (
+	// OverlapCancel stops the previous run, then starts the new one.
+	OverlapCancel OverlapPolicy = "cancel"
+	// OverlapQueue starts the new run once the previous one is over.
+	OverlapQueue OverlapPolicy = "queue"
+	// OverlapSkip skips the new run (default).
+	OverlapSkip OverlapPolicy = "skip"
+)
+
+// ErrOverlapPolicy is returned for unknown overlap policies.
+var ErrOverlapPolicy = errors.New("unknown overlap policy")
+
+type (
+	// Schedule computes the activations of a ScheduleRunner (see Cron and Every).
+	Schedule interface {
+		// Next returns the first activation after t, or the zero time if there is none.
+		Next(t time.Time) time.Time
+	}
+
+	// Every is a Schedule with a fixed interval: the first run happens one interval after the start.
+	Every time.Duration
+
+	// ScheduleRunner runs a task on a schedule, until its context is done. The result of the runs is logged, not
+	// returned.
+	ScheduleRunner struct {
+		task     Task
+		schedule Schedule
+		overlap  OverlapPolicy
+		logger   *zap.Logger
+		// observer is notified of the runs after the first one, as restarts identified by the task ID.
+		observer event.Observer
+		// onNext is called whenever the next activation changes.
+		onNext func(next time.Time)
+	}
+)
+
+// Next implements Schedule.
+func (e Every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// String returns the interval.
+func (e Every) String() string {
+	return "every " + time.Duration(e).String()
+}
+
+// Run executes the task on schedule, applying the overlap policy. A running task is stopped when the context is done.
+func (s ScheduleRunner) Run(ctx context.Context) error {
+	var (
+		cancel   context.CancelFunc
+		finished = make(chan error)
+		pending  = 0
+		runs     = 0
+	)
+
+	start := func() {
+		attempt, cancelAttempt := context.WithCancel(ctx)
+		cancel = cancelAttempt
+		runs++
+
+		if runs > 1 {
+			s.observer.Restarting(TaskID(s.task), runs-1)
+		}
+
+		go func() { finished <- s.task.Run(attempt) }()
+	}
+
+	// stop stops the running task, if any, and waits for it.
+	stop := func() {
+		if cancel == nil {
+			return
+		}
+
+		cancel()
+		<-finished
+
+		cancel = nil
+	}
+
+	defer stop()
+
+	timer := time.NewTimer(0)
+	<-timer.C
+
+	next := s.plan(timer, time.Now())
+
+	for {
+		var due <-chan time.Time
+		if !next.IsZero() {
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return ctx.Err() // nolint:wrapcheck // not relevant
+		case err := <-finished:
+			cancel()
+			cancel = nil
+
+			if err != nil {
+				s.logger.Warn("scheduled run failed", zap.Error(err))
+			}
+
+			if pending > 0 {
+				pending--
+
+				start()
+			}
+		case <-due:
+			next = s.plan(timer, next)
+
+			switch {
+			case cancel == nil:
+				start()
+			case s.overlap == OverlapCancel:
+				s.logger.Info("cancelling the previous run")
+				stop()
+				start()
+			case s.overlap == OverlapQueue:
+				s.logger.Info("previous run still running, queued")
+
+				pending++
+			default:
+				s.logger.Info("previous run still running, skipped")
+			}
+		}
+	}
+}
+
+// plan arms the timer for the activation following last (skipping the missed ones) and returns it.
+func (s ScheduleRunner) plan(timer *time.Timer, last time.Time) time.Time {
+	next := s.schedule.Next(last)
+	if now := time.Now(); !next.IsZero() && next.Before(now) {
+		next = s.schedule.Next(now)
+	}
+
+	if next.IsZero() {
+		s.logger.Info("no next run")
+	} else {
+		s.logger.Info("next run", zap.Time("at", next))
+		timer.Reset(time.Until(next))
+	}
+
+	if s.onNext != nil {
+		s.onNext(next)
+	}
+
+	return next
+}
+
+// WithLogger sets up the logger.
+func (s *ScheduleRunner) WithLogger(logger *zap.Logger) *ScheduleRunner {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	s.logger = logger
+
+	return s
+}
+
+// WithNextRunHook sets a function called whenever the next activation changes (with the zero time if none).
+func (s *ScheduleRunner) WithNextRunHook(hook func(next time.Time)) *ScheduleRunner {
+	s.onNext = hook
+
+	return s
+}
+
+// WithObserver sets up the lifecycle observer.
+func (s *ScheduleRunner) WithObserver(observer event.Observer) *ScheduleRunner {
+	if observer == nil {
+		observer = event.Nop{}
+	}
+
+	s.observer = observer
+
+	return s
+}
+
+// WithOverlap changes the overlap policy.
+func (s *ScheduleRunner) WithOverlap(policy OverlapPolicy) *ScheduleRunner {
+	s.overlap = policy.orDefault()
+
+	return s
+}
+
+// Scheduled constructor.
+func Scheduled(task Task, schedule Schedule, opts ...Option) *ScheduleRunner {
+	cfg := newOptions(opts)
+
+	inst := &ScheduleRunner{
+		logger:   nil,
+		observer: nil,
+		onNext:   nil,
+		overlap:  "",
+		schedule: schedule,
+		task:     task,
+	}
+
+	return inst.WithLogger(cfg.logger).WithObserver(cfg.observer).WithOverlap("")
+}
+
+// Validate returns ErrOverlapPolicy when the policy is unknown. An empty policy is valid.
+func (p OverlapPolicy) Validate() error {
+	switch p {
+	case "", OverlapCancel, OverlapQueue, OverlapSkip:
+		return nil
+	default:
+		return errors.Wrap(ErrOverlapPolicy, string(p))
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler (used by the JSON and TOML decoders).
+func (p *OverlapPolicy) UnmarshalText(text []byte) error {
+	policy := OverlapPolicy(text)
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	*p = policy
+
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (p *OverlapPolicy) UnmarshalYAML(value *yaml.Node) error {
+	return errors.Wrapf(p.UnmarshalText([]byte(value.Value)), "line %d", value.Line)
+}
+
+// orDefault replaces an empty policy with OverlapSkip.
+func (p OverlapPolicy) orDefault() OverlapPolicy {
+	if p == "" {
+		return OverlapSkip
+	}
+
+	return p
+}
@@ -0,0 +1,118 @@
+package loop_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowTask counts its runs, which take a while unless cancelled.
+type slowTask struct {
+	duration                      time.Duration
+	started, completed, cancelled int32
+}
+
+func (t *slowTask) Run(ctx context.Context) error {
+	atomic.AddInt32(&t.started, 1)
+
+	select {
+	case <-ctx.Done():
+		atomic.AddInt32(&t.cancelled, 1)
+
+		return ctx.Err()
+	case <-time.After(t.duration):
+		atomic.AddInt32(&t.completed, 1)
+
+		return errA
+	}
+}
+
+func TestScheduled(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		overlap loop.OverlapPolicy
+		check   func(t *testing.T, task *slowTask)
+	}{
+		"skip": {
+			overlap: loop.OverlapSkip,
+			check: func(t *testing.T, task *slowTask) {
+				t.Helper()
+				// Runs every 60ms at most, never cancelled before the end.
+				assert.GreaterOrEqual(t, task.started, int32(2))
+				assert.LessOrEqual(t, task.started, int32(5))
+				assert.LessOrEqual(t, task.cancelled, int32(1))
+			},
+		},
+		"queue": {
+			overlap: loop.OverlapQueue,
+			check: func(t *testing.T, task *slowTask) {
+				t.Helper()
+				// Runs back to back.
+				assert.GreaterOrEqual(t, task.completed, int32(2))
+				assert.LessOrEqual(t, task.cancelled, int32(1))
+			},
+		},
+		"cancel": {
+			overlap: loop.OverlapCancel,
+			check: func(t *testing.T, task *slowTask) {
+				t.Helper()
+				// Every run is cancelled by the next one.
+				assert.Zero(t, task.completed)
+				assert.GreaterOrEqual(t, task.cancelled, int32(5))
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			task := &slowTask{duration: 60 * time.Millisecond}
+
+			ctx, cancel := context.WithTimeout(context.TODO(), 250*time.Millisecond)
+			defer cancel()
+
+			err := loop.Scheduled(task, loop.Every(15*time.Millisecond)).WithOverlap(tt.overlap).Run(ctx)
+
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			tt.check(t, task)
+		})
+	}
+}
+
+func TestScheduledNextRun(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mtx   sync.Mutex
+		next  []time.Time
+		runs  int32
+		start = time.Now()
+	)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+
+	err := loop.Scheduled(countTask{runs: &runs}, loop.Every(30*time.Millisecond)).
+		WithNextRunHook(func(at time.Time) {
+			mtx.Lock()
+			defer mtx.Unlock()
+
+			next = append(next, at)
+		}).
+		Run(ctx)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&runs))
+	assert.Len(t, next, 4)
+	assert.WithinDuration(t, start.Add(30*time.Millisecond), next[0], 10*time.Millisecond)
+	assert.Equal(t, next[0].Add(30*time.Millisecond), next[1])
+}
@@ -3,23 +3,26 @@ package loop
 import (
 	"context"
 
+	"bitbucket.org/lucacontini/z6/pipeline/event"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
-type serial struct {
-	tasks  []Task
-	logger *zap.Logger
-	policy string
+// SerialRunner runs a list of tasks sequentially.
+type SerialRunner struct {
+	tasks    []Task
+	logger   *zap.Logger
+	observer event.Observer
+	policy   ExitPolicy
 }
 
 // Run executes multiple routines sequentially.
-func (s serial) Run(ctx context.Context) error {
+func (s SerialRunner) Run(ctx context.Context) error {
 	s.logger.Info("starting")
 	defer s.logger.Info("done")
 
-	for _, task := range s.tasks {
-		s.logger.Debug("task", zap.Any("task", task))
+	for idx, task := range s.tasks {
+		s.logger.Debug("task", zap.String("id", TaskID(task)))
 
 		err := task.Run(ctx)
 		if err == nil {
@@ -28,6 +31,8 @@ func (s serial) Run(ctx context.Context) error {
 
 		_, notify := policyCtl(err, s.policy)
 		if notify {
+			s.skip(s.tasks[idx+1:])
+
 			return errors.Wrap(err, "iteration aborted")
 		}
 
@@ -38,7 +43,7 @@ func (s serial) Run(ctx context.Context) error {
 }
 
 // WithLogger sets up the logger.
-func (s *serial) WithLogger(logger *zap.Logger) *serial {
+func (s *SerialRunner) WithLogger(logger *zap.Logger) *SerialRunner {
 	if logger == nil {
 		logger = zap.NewNop()
 	}
@@ -48,24 +53,41 @@ func (s *serial) WithLogger(logger *zap.Logger) *serial {
 	return s
 }
 
-// WithPolicy changes the exit policy.
-func (s *serial) WithPolicy(policy string) *serial {
-	if policy == "" {
-		s.policy = ExitPolicyPropagateIfErr
-	} else {
-		s.policy = policy
+// WithObserver sets up the lifecycle observer.
+func (s *SerialRunner) WithObserver(observer event.Observer) *SerialRunner {
+	if observer == nil {
+		observer = event.Nop{}
 	}
 
+	s.observer = observer
+
+	return s
+}
+
+// WithPolicy changes the exit policy.
+func (s *SerialRunner) WithPolicy(policy ExitPolicy) *SerialRunner {
+	s.policy = policy.orDefault()
+
 	return s
 }
 
 // Serial returns an executable loop.
-func Serial(tasks []Task) *serial {
-	inst := &serial{
-		logger: nil,
-		policy: "",
-		tasks:  tasks,
+func Serial(tasks []Task, opts ...Option) *SerialRunner {
+	cfg := newOptions(opts)
+
+	inst := &SerialRunner{
+		logger:   nil,
+		observer: nil,
+		policy:   "",
+		tasks:    tasks,
 	}
 
-	return inst.WithLogger(nil).WithPolicy("")
+	return inst.WithLogger(cfg.logger).WithObserver(cfg.observer).WithPolicy(cfg.policy)
+}
+
+// skip notifies the tasks that will not run, after an aborted iteration.
+func (s SerialRunner) skip(tasks []Task) {
+	for _, task := range tasks {
+		s.observer.Skipped(TaskID(task))
+	}
 }
@@ -14,7 +14,7 @@ func TestSerialRun(t *testing.T) {
 
 	type (
 		args struct {
-			policy string
+			policy loop.ExitPolicy
 			tasks  []loop.Task
 		}
 
@@ -0,0 +1,51 @@
+package loop
+
+import (
+	"context"
+	"fmt"
+)
+
+type (
+	// TaskFunc adapts a plain function to the Task interface.
+	TaskFunc func(ctx context.Context) error
+
+	// Identifier is implemented by tasks that carry an ID for logging and reporting.
+	Identifier interface {
+		ID() string
+	}
+
+	// NamedTask is a Task with an ID.
+	NamedTask struct {
+		Name string
+		Task Task
+	}
+)
+
+// Run calls f(ctx).
+func (f TaskFunc) Run(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Named wraps a task (or a function, via TaskFunc) with an ID.
+func Named(name string, task Task) NamedTask {
+	return NamedTask{Name: name, Task: task}
+}
+
+// ID returns the task name.
+func (t NamedTask) ID() string {
+	return t.Name
+}
+
+// Run executes the wrapped task.
+func (t NamedTask) Run(ctx context.Context) error {
+	return t.Task.Run(ctx) // nolint:wrapcheck // transparent wrapper
+}
+
+// TaskID returns the ID of tasks implementing Identifier, or a description of the task type.
+func TaskID(task Task) string {
+	if named, ok := task.(Identifier); ok {
+		return named.ID()
+	}
+
+	return fmt.Sprintf("%T", task)
+}
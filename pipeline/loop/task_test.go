@@ -0,0 +1,56 @@
+package loop_test
+
+import (
+	"context"
+	"testing"
+
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskFunc(t *testing.T) {
+	t.Parallel()
+
+	var runs int
+
+	task := loop.TaskFunc(func(context.Context) error {
+		runs++
+
+		return errA
+	})
+
+	err := loop.Serial([]loop.Task{task, loop.Named("health-check", task)}, loop.WithPolicy(loop.ExitPolicyNone)).
+		Run(context.TODO())
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, runs)
+}
+
+func TestTaskID(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		task loop.Task
+		want string
+	}{
+		"Named": {
+			task: loop.Named("health-check", testTask{nil}),
+			want: "health-check",
+		},
+		"Anonymous": {
+			task: testTask{nil},
+			want: "loop_test.testTask",
+		},
+	}
+
+	for name, unit := range testTable {
+		unit := unit
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, unit.want, loop.TaskID(unit.task))
+		})
+	}
+}
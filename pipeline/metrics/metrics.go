@@ -0,0 +1,152 @@
+// package metrics exposes per-node Prometheus metrics of supervised processes.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// namespace prefixes all the metric names.
+	namespace = "pipeline"
+	// shutdownTimeout is how long Serve waits for in-flight scrapes.
+	shutdownTimeout = 5 * time.Second
+	// readHeaderTimeout protects the endpoint from slow clients.
+	readHeaderTimeout = 5 * time.Second
+)
+
+// Metrics holds the per-node collectors. The zero value is not usable, use New.
+type Metrics struct {
+	duration *prometheus.HistogramVec
+	exits    *prometheus.CounterVec
+	lastExit *prometheus.GaugeVec
+	registry *prometheus.Registry
+	restarts *prometheus.CounterVec
+	starts   *prometheus.CounterVec
+	up       *prometheus.GaugeVec
+}
+
+// New returns a set of collectors, registered in a dedicated registry.
+func New() *Metrics {
+	node := []string{"node"}
+
+	inst := &Metrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{ // nolint:exhaustruct // defaults
+			Namespace: namespace,
+			Name:      "node_run_duration_seconds",
+			Help:      "Duration of the node processes.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 4, 10), // nolint:gomnd // 100ms to ~7h
+		}, node),
+		exits: prometheus.NewCounterVec(prometheus.CounterOpts{ // nolint:exhaustruct // defaults
+			Namespace: namespace,
+			Name:      "node_exits_total",
+			Help:      "Number of process exits, by exit code (-1 when killed by a signal).",
+		}, []string{"node", "code"}),
+		lastExit: prometheus.NewGaugeVec(prometheus.GaugeOpts{ // nolint:exhaustruct // defaults
+			Namespace: namespace,
+			Name:      "node_last_exit_timestamp_seconds",
+			Help:      "Time of the last process exit, in seconds since the epoch.",
+		}, node),
+		registry: prometheus.NewRegistry(),
+		restarts: prometheus.NewCounterVec(prometheus.CounterOpts{ // nolint:exhaustruct // defaults
+			Namespace: namespace,
+			Name:      "node_restarts_total",
+			Help:      "Number of restarts triggered by the exit policy.",
+		}, node),
+		starts: prometheus.NewCounterVec(prometheus.CounterOpts{ // nolint:exhaustruct // defaults
+			Namespace: namespace,
+			Name:      "node_starts_total",
+			Help:      "Number of process starts.",
+		}, node),
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{ // nolint:exhaustruct // defaults
+			Namespace: namespace,
+			Name:      "node_up",
+			Help:      "Whether the node process is running (1) or not (0).",
+		}, node),
+	}
+
+	inst.registry.MustRegister(inst.duration, inst.exits, inst.lastExit, inst.restarts, inst.starts, inst.up)
+
+	return inst
+}
+
+// Handler returns the HTTP handler serving the metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}) // nolint:exhaustruct // defaults
+}
+
+// Serve exposes /metrics on addr until the context is done.
+func (m *Metrics) Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: readHeaderTimeout} // nolint:exhaustruct // defaults
+
+	go func() {
+		<-ctx.Done()
+
+		shutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		_ = srv.Shutdown(shutdown) // nolint:contextcheck // the parent context is done
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err, "metrics")
+	}
+
+	return nil
+}
+
+// NodeStarted implements event.Observer (nodes are only measured through their processes). Like the other event
+// methods, it is a no-op on a nil receiver.
+func (m *Metrics) NodeStarted(string) {}
+
+// NodeFinished implements event.Observer.
+func (m *Metrics) NodeFinished(string, error) {}
+
+// ProcessSpawned implements event.Observer: it records a process start.
+func (m *Metrics) ProcessSpawned(node string, _ int) {
+	if m == nil {
+		return
+	}
+
+	m.starts.WithLabelValues(node).Inc()
+	m.up.WithLabelValues(node).Set(1)
+}
+
+// ProcessExited implements event.Observer: it records a process exit.
+func (m *Metrics) ProcessExited(node string, state *os.ProcessState, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+
+	code := -1
+	if state != nil {
+		code = state.ExitCode()
+	}
+
+	m.exits.WithLabelValues(node, strconv.Itoa(code)).Inc()
+	m.up.WithLabelValues(node).Set(0)
+	m.lastExit.WithLabelValues(node).SetToCurrentTime()
+	m.duration.WithLabelValues(node).Observe(elapsed.Seconds())
+}
+
+// Restarting implements event.Observer: it records a restart.
+func (m *Metrics) Restarting(node string, _ int) {
+	if m == nil {
+		return
+	}
+
+	m.restarts.WithLabelValues(node).Inc()
+}
+
+// Skipped implements event.Observer.
+func (m *Metrics) Skipped(string) {}
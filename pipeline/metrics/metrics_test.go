@@ -0,0 +1,95 @@
+package metrics_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	cmd := exec.Command("sh", "-c", "exit 3")
+	require.Error(t, cmd.Run())
+
+	collectors := metrics.New()
+	collectors.ProcessSpawned("root/daemon-1", 1)
+	collectors.ProcessExited("root/daemon-1", cmd.ProcessState, 2*time.Second)
+	collectors.Restarting("root/daemon-1", 1)
+	collectors.ProcessSpawned("root/daemon-1", 2)
+	collectors.ProcessExited("root/daemon-1", nil, time.Second)
+	collectors.ProcessSpawned("root/daemon-2", 3)
+
+	body := scrape(t, collectors)
+
+	for _, line := range []string{
+		`pipeline_node_starts_total{node="root/daemon-1"} 2`,
+		`pipeline_node_starts_total{node="root/daemon-2"} 1`,
+		`pipeline_node_restarts_total{node="root/daemon-1"} 1`,
+		`pipeline_node_exits_total{code="3",node="root/daemon-1"} 1`,
+		`pipeline_node_exits_total{code="-1",node="root/daemon-1"} 1`,
+		`pipeline_node_up{node="root/daemon-1"} 0`,
+		`pipeline_node_up{node="root/daemon-2"} 1`,
+		`pipeline_node_run_duration_seconds_sum{node="root/daemon-1"} 3`,
+		`pipeline_node_run_duration_seconds_count{node="root/daemon-1"} 2`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+
+	assert.Contains(t, body, `pipeline_node_last_exit_timestamp_seconds{node="root/daemon-1"} `)
+}
+
+func TestNilMetrics(t *testing.T) {
+	t.Parallel()
+
+	var collectors *metrics.Metrics
+
+	assert.NotPanics(t, func() {
+		collectors.NodeStarted("root")
+		collectors.ProcessSpawned("root", 1)
+		collectors.ProcessExited("root", nil, 0)
+		collectors.Restarting("root", 1)
+		collectors.NodeFinished("root", nil)
+		collectors.Skipped("root")
+	})
+}
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	// A cancelled context shuts the server down.
+	assert.NoError(t, metrics.New().Serve(ctx, "127.0.0.1:0"))
+	assert.EqualError(t, metrics.New().Serve(context.TODO(), "-1:-1"), "metrics: listen tcp: address -1: invalid port")
+}
+
+func scrape(t *testing.T, collectors *metrics.Metrics) string {
+	t.Helper()
+
+	srv := httptest.NewServer(collectors.Handler())
+	defer srv.Close()
+
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return strings.TrimSpace(string(body)) + "\n"
+}
@@ -3,28 +3,99 @@ package pipeline
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
 	"time"
 
+	"bitbucket.org/lucacontini/z6/pipeline/event"
 	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"bitbucket.org/lucacontini/z6/pipeline/metrics"
 	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+	"bitbucket.org/lucacontini/z6/pipeline/trace"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
 // Node represents the pipeline execution.
 type Node struct {
-	Args      []string      `yaml:"args,flow"`
-	Command   string        `yaml:"path"`
-	LogConfig LogConfig     `yaml:"log"`
-	Name      string        `yaml:"name"`
-	OnExit    string        `yaml:"onExit"`
-	Parallel  []Node        `yaml:"parallel,flow"`
-	Stderr    string        `yaml:"stderr"`
-	Stdout    string        `yaml:"stdout"`
-	Steps     []Node        `yaml:"steps,flow"`
-	Timeout   time.Duration `yaml:"timeout"`
+	Args          []string               `json:"args,omitempty"        toml:"args,omitempty"        yaml:"args,flow,omitempty"`
+	CacheConfig   CacheConfig            `json:"cache"                 toml:"cache,omitempty"       yaml:"cache,omitempty"`
+	Capture       string                 `json:"capture,omitempty"     toml:"capture,omitempty"     yaml:"capture,omitempty"`
+	CaptureJSON   bool                   `json:"captureJSON,omitempty" toml:"captureJSON,omitempty" yaml:"captureJSON,omitempty"`
+	Command       string                 `json:"path,omitempty"        toml:"path,omitempty"        yaml:"path,omitempty"`
+	ControlConfig ControlConfig          `json:"control"               toml:"control,omitempty"     yaml:"control,omitempty"`
+	Deadline      *time.Time             `json:"deadline,omitempty"    toml:"deadline,omitempty"    yaml:"deadline,omitempty"`
+	Delay         Duration               `json:"delay,omitempty"       toml:"delay,omitempty"       yaml:"delay,omitempty"`
+	Every         Duration               `json:"every,omitempty"       toml:"every,omitempty"       yaml:"every,omitempty"`
+	Hooks         Hooks                  `json:"hooks"                 toml:"hooks,omitempty"       yaml:"hooks,omitempty"`
+	Inputs        []string               `json:"inputs,omitempty"      toml:"inputs,omitempty"      yaml:"inputs,omitempty"`
+	LogConfig     LogConfig              `json:"log"                   toml:"log,omitempty"         yaml:"log,omitempty"`
+	Name          string                 `json:"name,omitempty"        toml:"name,omitempty"        yaml:"name,omitempty"`
+	OnExit        loop.ExitPolicy        `json:"onExit,omitempty"      toml:"onExit,omitempty"      yaml:"onExit,omitempty"`
+	Outputs       []string               `json:"outputs,omitempty"     toml:"outputs,omitempty"     yaml:"outputs,omitempty"`
+	Overlap       loop.OverlapPolicy     `json:"overlap,omitempty"     toml:"overlap,omitempty"     yaml:"overlap,omitempty"`
+	Parallel      []Node                 `json:"parallel,omitempty"    toml:"parallel,omitempty"    yaml:"parallel,omitempty"`
+	Schedule      string                 `json:"schedule,omitempty"    toml:"schedule,omitempty"    yaml:"schedule,omitempty"`
+	Stderr        subprocess.Sinks       `json:"stderr,omitempty"      toml:"stderr,omitempty"      yaml:"stderr,omitempty"`
+	Stdout        subprocess.Sinks       `json:"stdout,omitempty"      toml:"stdout,omitempty"      yaml:"stdout,omitempty"`
+	Steps         []Node                 `json:"steps,omitempty"       toml:"steps,omitempty"       yaml:"steps,omitempty"`
+	StopTimeout   Duration               `json:"stopTimeout,omitempty" toml:"stopTimeout,omitempty" yaml:"stopTimeout,omitempty"`
+	Timeout       Duration               `json:"timeout,omitempty"     toml:"timeout,omitempty"     yaml:"timeout,omitempty"`
+	Type          string                 `json:"type,omitempty"        toml:"type,omitempty"        yaml:"type,omitempty"`
+	Vars          map[string]string      `json:"vars,omitempty"        toml:"vars,omitempty"        yaml:"vars,omitempty"`
+	Watch         []string               `json:"watch,omitempty"       toml:"watch,omitempty"       yaml:"watch,omitempty"`
+	With          map[string]interface{} `json:"with,omitempty"        toml:"with,omitempty"        yaml:"with,omitempty"`
 
-	logger *zap.Logger
+	cache      *Cache
+	captured   *captureBuffer
+	captures   *captures
+	checkpoint *Checkpoint
+	env        []string
+	logger     *zap.Logger
+	observer   event.Observer
+	overrides  map[string]string
+	path       string
+	proc       *subprocess.Proc
+	restarts   int
+	result     *Result
+	skipped    bool
+	step       bool
+	supervisor *Supervisor
+	tracer     *trace.Tracer
+	watching   *watchConfig
+}
+
+// MarshalJSON implements json.Marshaler, leaving out the empty configuration objects (encoding/json ignores
+// omitempty on structs).
+func (n Node) MarshalJSON() ([]byte, error) {
+	type plain Node
+
+	node := struct {
+		plain
+		CacheConfig   *CacheConfig   `json:"cache,omitempty"`
+		ControlConfig *ControlConfig `json:"control,omitempty"`
+		Hooks         *Hooks         `json:"hooks,omitempty"`
+		LogConfig     *LogConfig     `json:"log,omitempty"`
+	}{plain: plain(n)} // nolint:exhaustruct // set below
+
+	if n.CacheConfig != (CacheConfig{}) { // nolint:exhaustruct // zero value
+		node.CacheConfig = &n.CacheConfig
+	}
+
+	if n.ControlConfig != (ControlConfig{}) { // nolint:exhaustruct // zero value
+		node.ControlConfig = &n.ControlConfig
+	}
+
+	if n.Hooks != (Hooks{}) { // nolint:exhaustruct // zero value
+		node.Hooks = &n.Hooks
+	}
+
+	if n.LogConfig != (LogConfig{}) { // nolint:exhaustruct // zero value
+		node.LogConfig = &n.LogConfig
+	}
+
+	return json.Marshal(node) // nolint:wrapcheck // not relevant
 }
 
 // ID returns the identifier (name) of the current node.
@@ -34,6 +105,8 @@ func (n *Node) ID() string {
 		return n.Name
 	case n.IsCommand():
 		return n.Command
+	case n.IsPlugin():
+		return n.Type
 	case n.IsParallel():
 		return "parallel"
 	case n.IsSerial():
@@ -48,6 +121,11 @@ func (n *Node) IsCommand() bool {
 	return n.Command != ""
 }
 
+// IsPlugin returns whether the node represents a task type registered with loop.Register.
+func (n *Node) IsPlugin() bool {
+	return n.Type != ""
+}
+
 // IsParallel returns whether the node represents a list of parallel tasks.
 func (n *Node) IsParallel() bool {
 	return len(n.Parallel) > 0
@@ -60,17 +138,72 @@ func (n *Node) IsSerial() bool {
 
 // Run executes the pipeline.
 func (n Node) Run(ctx context.Context) error {
+	if n.skipped {
+		n.logger.Info("skipped")
+		n.notifySkipped()
+
+		return nil
+	}
+
+	key, cached := n.cache.lookup(&n)
+	if cached {
+		n.logger.Info("up to date, skipped", zap.String("key", key))
+		n.notifySkipped()
+
+		return nil
+	}
+
 	ctl := ctx
 
 	if n.Timeout > 0 {
-		n.logger.Info("set timeout", zap.Any("seconds", n.Timeout))
-		c, cancel := context.WithTimeout(ctx, n.Timeout)
+		n.logger.Info("set timeout", zap.Any("seconds", n.Timeout.Duration()))
+		c, cancel := context.WithTimeout(ctx, n.Timeout.Duration())
 		ctl = c
 
 		defer cancel()
 	}
 
-	if err := n.Task().Run(ctl); err != nil {
+	if n.Deadline != nil {
+		n.logger.Info("set deadline", zap.Time("deadline", *n.Deadline))
+		c, cancel := context.WithDeadline(ctl, *n.Deadline)
+		ctl = c
+
+		defer cancel()
+	}
+
+	ctl, span := n.startSpan(ctl)
+
+	n.events().NodeStarted(n.Path())
+	n.result.start()
+	n.runHook(ctl, HookOnStart, nil)
+
+	err := n.scheduled(n.watched(n.task(ctl))).Run(ctl)
+	if err == nil {
+		err = n.storeCapture()
+	}
+
+	n.result.finish(ctl, err)
+	span.End(err)
+	n.events().NodeFinished(n.Path(), err)
+
+	if err := n.checkpoint.record(n.Path(), &n, err); err != nil {
+		n.logger.Warn("checkpoint failed", zap.Error(err))
+	}
+
+	if err == nil {
+		if err := n.cache.store(&n, key); err != nil {
+			n.logger.Warn("cache failed", zap.Error(err))
+		}
+	}
+
+	// The node timeout does not apply to its hooks.
+	if err != nil {
+		n.runHook(ctx, HookOnFailure, err)
+	} else {
+		n.runHook(ctx, HookOnSuccess, nil)
+	}
+
+	if err != nil {
 		return errors.Wrapf(err, "task %s", n.ID())
 	}
 
@@ -79,31 +212,121 @@ func (n Node) Run(ctx context.Context) error {
 
 // Task return the current node as a loop.
 func (n *Node) Task() loop.Task { //nolint:ireturn // Legit interface
+	return n.task(context.Background())
+}
+
+// task returns the current node as a loop; ctx is the one of its restart hook.
+func (n *Node) task(ctx context.Context) loop.Task { //nolint:ireturn // Legit interface
 	n.logBogusConfig()
-	n.propagateLogger()
+	n.propagate()
+
+	// The events of a command (or plugin) also update the node.
+	observer := &nodeObserver{Observer: n.events(), ctx: ctx, node: n}
 
 	switch {
 	case n.IsCommand():
 		cmd := &subprocess.Proc{
-			Args:    n.Args,
-			Command: n.Command,
-			Stderr:  n.Stderr,
-			Stdout:  n.Stdout,
+			Args:        n.captures.expandList(n.Args),
+			Capture:     nil,
+			Command:     n.captures.expand(n.Command),
+			Env:         n.environ(),
+			Logger:      n.logger,
+			Name:        n.Path(),
+			Observer:    observer,
+			Stderr:      n.captures.expandSinks(n.Stderr),
+			Stdout:      n.captures.expandSinks(n.Stdout),
+			StopTimeout: n.stopTimeout(),
+		}
+		n.proc = cmd
+
+		if n.Capture != "" {
+			n.captured = newCaptureBuffer()
+			cmd.Capture = n.captured
+
+			if len(cmd.Stdout) == 0 {
+				cmd.Stdout = subprocess.To(n.captureStream())
+			}
 		}
 
-		return loop.Loop(cmd).WithLogger(n.logger).WithPolicy(n.OnExit)
+		return loop.Loop(loop.Named(n.Path(), n.traceAttempts(n.result.track(cmd), cmd))).
+			WithControl(n.supervisor.attach(n.Path(), cmd)).
+			WithLogger(n.logger).
+			WithObserver(observer).
+			WithPolicy(n.OnExit).
+			WithDelay(n.Delay.Duration())
+	case n.IsPlugin():
+		task, err := loop.NewTask(n.Type, n.captures.expandWith(n.With))
+		if err != nil {
+			task = loop.TaskFunc(func(context.Context) error { return err })
+		}
+
+		return loop.Loop(loop.Named(n.Path(), n.traceAttempts(n.result.track(task), nil))).
+			WithControl(n.supervisor.attach(n.Path(), nil)).
+			WithLogger(n.logger).
+			WithObserver(observer).
+			WithPolicy(n.OnExit).
+			WithDelay(n.Delay.Duration())
 	case n.IsParallel():
 		tasks := typecast(n.Parallel)
 
 		// Maybe TODO? n.OnExit has no effect on this node.
-		return loop.Parallel(tasks).WithLogger(n.logger)
+		return loop.Parallel(tasks).WithLogger(n.logger).WithObserver(n.observer)
 	case !n.IsSerial():
 		n.logger.Warn("noop node")
 	}
 
 	tasks := typecast(n.Steps)
 
-	return loop.Serial(tasks).WithLogger(n.logger).WithPolicy(n.OnExit)
+	return loop.Serial(tasks).WithLogger(n.logger).WithObserver(n.observer).WithPolicy(n.OnExit)
+}
+
+// ChildPath returns the path of the i-th child (see Children), given the path of the node: its ID, suffixed with its
+// index when a sibling has the same ID (eg: root/sh[0] and root/sh[1]).
+func (n *Node) ChildPath(path string, i int) string {
+	return siblingPath(path, n.Children(), i)
+}
+
+// siblingPath returns the path of the i-th sibling (see ChildPath).
+func siblingPath(path string, siblings []Node, i int) string {
+	id := siblings[i].ID()
+
+	for j := range siblings {
+		if j != i && siblings[j].ID() == id {
+			return fmt.Sprintf("%s/%s[%d]", path, id, i)
+		}
+	}
+
+	return path + "/" + id
+}
+
+// Path returns the node names from the root to the current node, separated by slashes.
+func (n *Node) Path() string {
+	if n.path == "" {
+		return n.ID()
+	}
+
+	return n.path
+}
+
+// WithMetrics attaches the Prometheus collectors to the node and, when it runs, to its children, as an observer
+// (along with any other).
+func (n *Node) WithMetrics(m *metrics.Metrics) *Node {
+	switch {
+	case m == nil:
+	case n.observer == nil:
+		n.observer = m
+	default:
+		n.observer = event.Multi(n.observer, m)
+	}
+
+	return n
+}
+
+// WithObserver sets up the lifecycle observer (nil disables it).
+func (n *Node) WithObserver(observer event.Observer) *Node {
+	n.observer = observer
+
+	return n
 }
 
 // WithLogger sets up the logger.
@@ -124,27 +347,85 @@ func (n *Node) logBogusConfig() {
 		n.logger.Warn("bogus `parallel` list")
 	case n.IsCommand() && n.IsSerial():
 		n.logger.Warn("bogus `steps` list")
+	case n.IsCommand() && n.IsPlugin():
+		n.logger.Warn("bogus `type`")
 	case n.IsParallel() && n.IsSerial():
 		n.logger.Warn("bogus `steps` list")
 	}
 }
 
-// propagateLogger attaches the node logger instance to its children.
-func (n *Node) propagateLogger() {
-	for i := range n.Parallel {
-		n.Parallel[i].WithLogger(n.logger)
+// propagate attaches the node logger, observer (metrics included), supervisor, tracer, checkpoint and cache instances
+// to its children, along with their path, environment, watch mode and captured outputs.
+func (n *Node) propagate() {
+	if n.captures == nil {
+		n.captures = &captures{mtx: sync.Mutex{}, values: make(map[string]capturedValue)}
 	}
 
+	for _, children := range [][]Node{n.Parallel, n.Steps} {
+		for i := range children {
+			children[i].WithLogger(n.logger).WithObserver(n.observer).WithTracer(n.tracer)
+			children[i].cache = n.cache
+			children[i].captures = n.captures
+			children[i].checkpoint = n.checkpoint
+			children[i].env = n.env
+			children[i].supervisor = n.supervisor
+			children[i].path = siblingPath(n.Path(), children, i)
+			children[i].watching = n.watching
+		}
+	}
+
+	// The serial groups watch the patterns of their steps (see WithWatch).
 	for i := range n.Steps {
-		n.Steps[i].WithLogger(n.logger)
+		n.Steps[i].step = true
+	}
+}
+
+// notifySkipped reports the node and its descendants as skipped.
+func (n *Node) notifySkipped() {
+	n.propagate()
+	n.events().Skipped(n.Path())
+
+	children := n.Children()
+	for i := range children {
+		children[i].notifySkipped()
 	}
 }
 
-// typecast converts a list of nodes into a loop.
+// events returns the lifecycle observer, or a no-op one.
+func (n *Node) events() event.Observer { // nolint:ireturn // observer
+	if n.observer == nil {
+		return event.Nop{}
+	}
+
+	return n.observer
+}
+
+// nodeObserver passes the events of a command (or plugin) node on, after updating the node: its captured output is
+// reset when its process starts, and its restart hook runs when it restarts.
+type nodeObserver struct {
+	event.Observer
+	ctx  context.Context // nolint:containedctx // the one of the restart hook
+	node *Node
+}
+
+// ProcessSpawned implements event.Observer.
+func (o *nodeObserver) ProcessSpawned(node string, pid int) {
+	o.node.captured.reset()
+	o.Observer.ProcessSpawned(node, pid)
+}
+
+// Restarting implements event.Observer.
+func (o *nodeObserver) Restarting(node string, restarts int) {
+	o.node.restarts = restarts
+	o.Observer.Restarting(node, restarts)
+	o.node.runHook(o.ctx, HookOnRestart, nil)
+}
+
+// typecast converts a list of nodes into a loop, identified by their path.
 func typecast(nodes []Node) []loop.Task {
 	loopTasks := make([]loop.Task, 0)
 	for _, task := range nodes {
-		loopTasks = append(loopTasks, task)
+		loopTasks = append(loopTasks, loop.Named(task.Path(), task))
 	}
 
 	return loopTasks
@@ -1,16 +1,31 @@
-// package pipeline provides a way to load a procedural list of tasks from a YAML file.
+// package pipeline provides a way to load a procedural list of tasks from a YAML, JSON or TOML file.
 package pipeline
 
 import (
 	"io/ioutil"
 	"os"
 
+	"bitbucket.org/lucacontini/z6/pipeline/event"
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v3"
 )
 
-// NewFromFile reads a YAML file and parse it as a Node.
-func NewFromFile(file string) (*Node, error) {
+// Option configures the root node when it is loaded.
+type Option func(*Node)
+
+// WithObserver sets up the lifecycle observer of the whole tree.
+func WithObserver(observer event.Observer) Option {
+	return func(n *Node) {
+		n.WithObserver(observer)
+	}
+}
+
+// NewFromFile reads a file and parse it as a Node, detecting the format from the file extension.
+func NewFromFile(file string, opts ...Option) (*Node, error) {
+	return NewFromFileWithFormat(file, FormatOf(file), opts...)
+}
+
+// NewFromFileWithFormat reads a file (or the standard input with "-") and parse it as a Node.
+func NewFromFileWithFormat(file string, format Format, opts ...Option) (*Node, error) {
 	var (
 		str []byte
 		err error
@@ -26,26 +41,42 @@ func NewFromFile(file string) (*Node, error) {
 		return nil, errors.Wrapf(err, "cannot open %s", file)
 	}
 
-	exec, err := New(string(str))
+	exec, err := NewWithFormat(string(str), format, opts...)
 	if err != nil {
-		return nil, errors.Wrapf(err, "invalid YAML in %s", file)
+		return nil, errors.Wrapf(err, "invalid %s in %s", format, file)
 	}
 
 	return exec, nil
 }
 
 // New parses a YAML string and returns the root node.
-func New(str string) (*Node, error) {
+func New(str string, opts ...Option) (*Node, error) {
+	return NewWithFormat(str, FormatYAML, opts...)
+}
+
+// NewWithFormat parses a string in the given format and returns the root node.
+func NewWithFormat(str string, format Format, opts ...Option) (*Node, error) {
 	var exec Node
 
-	if err := yaml.Unmarshal([]byte(str), &exec); err != nil {
+	if err := format.Unmarshal([]byte(str), &exec); err != nil {
 		return nil, errors.Wrap(err, "cannot unmarshal")
 	}
 
-	logger, err := exec.LogConfig.Logger()
+	for _, opt := range opts {
+		opt(&exec)
+	}
+
+	exec.expandVars(nil, exec.overrides)
+
+	return exec.withConfiguredLogger()
+}
+
+// withConfiguredLogger attaches the logger described by the node's LogConfig.
+func (n *Node) withConfiguredLogger() (*Node, error) {
+	logger, err := n.LogConfig.Logger()
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot create logger")
 	}
 
-	return exec.WithLogger(logger), nil
+	return n.WithLogger(logger), nil
 }
@@ -2,10 +2,19 @@ package pipeline_test
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/metrics"
+	"bitbucket.org/lucacontini/z6/pipeline/trace"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -24,6 +33,8 @@ func TestRun(t *testing.T) {
 		}
 	)
 
+	deadline := time.Now().Add(100 * time.Millisecond)
+
 	testTable := map[string]struct {
 		fields
 		want
@@ -83,6 +94,33 @@ func TestRun(t *testing.T) {
 				err: errors.New("task parallel: task sh: exit status 4"),
 			},
 		},
+		"With deadline": {
+			fields: fields{
+				instance: pipeline.Node{
+					Args:     []string{"1"},
+					Command:  "sleep",
+					Deadline: &deadline,
+					Name:     "sleep-1",
+				},
+			},
+			want: want{
+				err: errors.New("task sleep-1: context deadline exceeded"),
+			},
+		},
+		"With restart delay": {
+			fields: fields{
+				instance: pipeline.Node{
+					Command: "false",
+					Delay:   pipeline.Duration(time.Hour),
+					Name:    "false-1",
+					OnExit:  "restart",
+					Timeout: pipeline.Duration(100 * time.Millisecond),
+				},
+			},
+			want: want{
+				err: errors.New("task false-1: context deadline exceeded"),
+			},
+		},
 		"With file": {
 			fields: fields{
 				instance: load(t, "../testdata/test-pipeline-001.yaml"),
@@ -137,3 +175,205 @@ func load(t *testing.T, file string) pipeline.Node {
 
 	return *n
 }
+
+func TestRunWithMetrics(t *testing.T) {
+	t.Parallel()
+
+	collectors := metrics.New()
+	node := pipeline.Node{
+		Name: "root",
+		Steps: []pipeline.Node{
+			{Args: []string{"-c", "exit 2"}, Command: "sh", Delay: pipeline.Duration(time.Hour), Name: "exit-2", OnExit: "restart"},
+		},
+		Timeout: pipeline.Duration(200 * time.Millisecond),
+	}
+
+	err := node.WithLogger(nil).WithMetrics(collectors).Run(context.TODO())
+	assert.EqualError(t, err, "task root: iteration aborted: task exit-2: context deadline exceeded")
+
+	srv := httptest.NewServer(collectors.Handler())
+	defer srv.Close()
+
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(body), `pipeline_node_starts_total{node="root/exit-2"} 1`)
+	assert.Contains(t, string(body), `pipeline_node_exits_total{code="2",node="root/exit-2"} 1`)
+}
+
+func TestRunStopTimeout(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		timeout time.Duration
+		stopped bool
+	}{
+		"default":      {timeout: 0, stopped: false},
+		"stop timeout": {timeout: 5 * time.Second, stopped: true},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			file := filepath.Join(t.TempDir(), "stopped")
+			node := pipeline.Command("sh", "-c", "trap 'echo stopped > $0; exit 0' TERM; sleep 10 & wait", file).
+				Name("daemon").StopTimeout(tt.timeout).Timeout(200 * time.Millisecond).Node()
+
+			err := node.WithLogger(nil).Run(context.TODO())
+			assert.EqualError(t, err, "task daemon: context deadline exceeded")
+
+			if tt.stopped {
+				assert.FileExists(t, file)
+			} else {
+				assert.NoFileExists(t, file)
+			}
+		})
+	}
+}
+
+// spanRecorder keeps the exported spans in memory.
+type spanRecorder struct {
+	mtx   sync.Mutex
+	spans map[string]trace.SpanData
+}
+
+func (r *spanRecorder) Export(span trace.SpanData) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.spans[span.Name] = span
+}
+
+func (r *spanRecorder) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestRunWithTracer(t *testing.T) {
+	t.Parallel()
+
+	exporter := &spanRecorder{mtx: sync.Mutex{}, spans: make(map[string]trace.SpanData)}
+	node := pipeline.Node{
+		Name: "root",
+		Steps: []pipeline.Node{
+			{Args: []string{"-c", `test "${TRACEPARENT%-*}" != "" || exit 9`}, Command: "sh", Name: "check"},
+			{Args: []string{"-c", "exit 2"}, Command: "sh", Name: "exit-2"},
+		},
+	}
+
+	err := node.WithLogger(nil).WithTracer(trace.New(exporter, "")).Run(context.TODO())
+	assert.EqualError(t, err, "task root: iteration aborted: task exit-2: exit status 2")
+
+	require.Len(t, exporter.spans, 5)
+
+	root, check, attempt := exporter.spans["root"], exporter.spans["check"], exporter.spans["check #1"]
+	assert.Equal(t, "iteration aborted: task exit-2: exit status 2", root.Error)
+	assert.Equal(t, root.SpanID, check.ParentID)
+	assert.Equal(t, check.SpanID, attempt.ParentID)
+	assert.Equal(t, root.TraceID, attempt.TraceID)
+	assert.Equal(t, map[string]interface{}{
+		pipeline.AttrArgs:     []string{"-c", `test "${TRACEPARENT%-*}" != "" || exit 9`},
+		pipeline.AttrCommand:  "sh",
+		pipeline.AttrExitCode: 0,
+		pipeline.AttrKind:     "command",
+		pipeline.AttrPath:     "root/check",
+		pipeline.AttrRestarts: 0,
+	}, check.Attributes)
+	assert.Equal(t, 1, attempt.Attributes[pipeline.AttrAttempt])
+	assert.Equal(t, 2, exporter.spans["exit-2"].Attributes[pipeline.AttrExitCode])
+	assert.Equal(t, "exit status 2", exporter.spans["exit-2 #1"].Error)
+}
+
+// eventRecorder records the lifecycle events.
+type eventRecorder struct {
+	mtx    sync.Mutex
+	events []string
+}
+
+func (r *eventRecorder) add(evt string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.events = append(r.events, evt)
+}
+
+func (r *eventRecorder) NodeStarted(node string) { r.add("started " + node) }
+
+func (r *eventRecorder) NodeFinished(node string, err error) {
+	r.add(fmt.Sprintf("finished %s %v", node, err))
+}
+
+func (r *eventRecorder) ProcessSpawned(node string, _ int) { r.add("spawned " + node) }
+
+func (r *eventRecorder) ProcessExited(node string, state *os.ProcessState, _ time.Duration) {
+	r.add(fmt.Sprintf("exited %s %d", node, state.ExitCode()))
+}
+
+func (r *eventRecorder) Restarting(node string, restarts int) {
+	r.add(fmt.Sprintf("restarting %s %d", node, restarts))
+}
+
+func (r *eventRecorder) Skipped(node string) { r.add("skipped " + node) }
+
+func TestObserver(t *testing.T) {
+	t.Parallel()
+
+	obs := &eventRecorder{mtx: sync.Mutex{}, events: nil}
+	node, err := pipeline.New(`
+name: root
+steps:
+  - name: flaky
+    path: sh
+    args: ["-c", "test -e $0 || { touch $0; exit 1; }", "`+filepath.Join(t.TempDir(), "flag")+`"]
+    onExit: restart-if-err
+  - name: exit-2
+    path: sh
+    args: ["-c", "exit 2"]
+  - name: never
+    path: "true"
+`, pipeline.WithObserver(obs))
+	require.NoError(t, err)
+
+	assert.Error(t, node.Run(context.TODO()))
+	assert.Equal(t, []string{
+		"started root",
+		"started root/flaky",
+		"spawned root/flaky",
+		"exited root/flaky 1",
+		"restarting root/flaky 1",
+		"spawned root/flaky",
+		"exited root/flaky 0",
+		"finished root/flaky <nil>",
+		"started root/exit-2",
+		"spawned root/exit-2",
+		"exited root/exit-2 2",
+		"finished root/exit-2 exit status 2",
+		"skipped root/never",
+		"finished root iteration aborted: task exit-2: exit status 2",
+	}, obs.events)
+}
+
+func TestObserverSiblings(t *testing.T) {
+	t.Parallel()
+
+	// Siblings with the same ID are told apart by their index.
+	obs := &eventRecorder{mtx: sync.Mutex{}, events: nil}
+	node := pipeline.Serial(pipeline.Command("true"), pipeline.Command("true"), pipeline.Command("echo")).
+		Name("root").
+		Node()
+
+	require.NoError(t, node.WithLogger(nil).WithObserver(obs).Run(context.TODO()))
+	assert.Contains(t, obs.events, "finished root/true[0] <nil>")
+	assert.Contains(t, obs.events, "finished root/true[1] <nil>")
+	assert.Contains(t, obs.events, "finished root/echo <nil>")
+}
@@ -0,0 +1,165 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ansiEscaper escapes the $'...' shell strings.
+	ansiEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	// safeArg matches the arguments that need no shell quoting.
+	safeArg = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+)
+
+// WritePlan describes what the tree would execute, without running anything: the serial and parallel structure, the
+// commands with their expanded arguments, the stream targets, the cache inputs and outputs, the exit policies, the
+// schedules, the watch patterns, the timeouts and the hooks. Skipped nodes (see Select) are only listed.
+func (n *Node) WritePlan(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	n.writePlan(buf, "", "")
+
+	return errors.Wrap(buf.Flush(), "cannot write plan")
+}
+
+// writePlan writes the node, prefixed with indent and marker, and its children.
+func (n *Node) writePlan(w io.Writer, indent, marker string) {
+	if n.skipped {
+		fmt.Fprintf(w, "%s%s%s: %s (skipped)\n", indent, marker, n.ID(), n.summary())
+
+		return
+	}
+
+	fmt.Fprintf(w, "%s%s%s: %s\n", indent, marker, n.ID(), n.summary())
+
+	indent += strings.Repeat(" ", len([]rune(marker)))
+	detail := func(key, value string) {
+		fmt.Fprintf(w, "%s  %-9s %s\n", indent, key, value)
+	}
+
+	switch n.Kind() {
+	case KindCommand:
+		detail("run", shellQuote(append([]string{n.Command}, n.Args...)))
+
+		if _, err := exec.LookPath(n.Command); err != nil {
+			detail("warning", "command not found")
+		}
+
+		detail("stdout", n.Stdout.Describe(n.captureStream()))
+		detail("stderr", n.Stderr.Describe("stderr"))
+
+		if n.Capture != "" {
+			detail("capture", n.captureSummary())
+		}
+
+		if len(n.Inputs) > 0 {
+			detail("inputs", strings.Join(n.Inputs, " "))
+		}
+
+		if len(n.Outputs) > 0 {
+			detail("outputs", strings.Join(n.Outputs, " "))
+		}
+	case KindPlugin:
+		if len(n.With) > 0 {
+			with, _ := json.Marshal(n.With)
+			detail("with", string(with))
+		}
+	}
+
+	if n.Kind() != KindParallel && n.Kind() != KindNoop {
+		policy, _ := loop.ParseExitPolicy(string(n.OnExit))
+		detail("onExit", string(policy))
+	}
+
+	if n.Delay > 0 && (n.IsCommand() || n.IsPlugin()) {
+		detail("delay", n.Delay.String())
+	}
+
+	if schedule := n.scheduleSummary(); schedule != "" {
+		detail("schedule", schedule)
+	}
+
+	if len(n.Watch) > 0 {
+		detail("watch", strings.Join(n.Watch, " "))
+	}
+
+	if n.Timeout > 0 {
+		detail("timeout", n.Timeout.String())
+	}
+
+	if n.StopTimeout > 0 && n.IsCommand() {
+		detail("stop", "SIGTERM, killed after "+n.StopTimeout.String())
+	}
+
+	if n.Deadline != nil {
+		detail("deadline", n.Deadline.Format(time.RFC3339))
+	}
+
+	hooks := n.Hooks.list()
+	names := make([]string, 0, len(hooks))
+
+	for name := range hooks {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "%s  %s hook:\n", indent, name)
+		hooks[name].writePlan(w, indent+"    ", "")
+	}
+
+	children := n.Children()
+	for i := range children {
+		marker := "- "
+		if n.IsSerial() {
+			marker = strconv.Itoa(i+1) + ". "
+		}
+
+		children[i].writePlan(w, indent+"  ", marker)
+	}
+}
+
+// summary describes the kind of node.
+func (n *Node) summary() string {
+	switch n.Kind() {
+	case KindParallel:
+		return fmt.Sprintf("parallel, %d tasks at once", len(n.Parallel))
+	case KindSerial:
+		return fmt.Sprintf("serial, %d steps", len(n.Steps))
+	case KindPlugin:
+		return "plugin " + n.Type
+	default:
+		return n.Kind()
+	}
+}
+
+// shellQuote joins the arguments, quoted for a shell when needed (multi-line arguments use the $'...' form, to keep
+// the plan one line per command).
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
+
+	for i, arg := range args {
+		switch {
+		case safeArg.MatchString(arg):
+			quoted[i] = arg
+		case strings.ContainsAny(arg, "\n\r\t"):
+			quoted[i] = "$'" + ansiEscaper.Replace(arg) + "'"
+		default:
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+
+	return strings.Join(quoted, " ")
+}
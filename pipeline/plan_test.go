@@ -0,0 +1,65 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePlan(t *testing.T) {
+	t.Parallel()
+
+	out := filepath.Join(t.TempDir(), "build.log")
+
+	// Plugin types are not checked by WritePlan.
+	node := pipeline.Serial(
+		pipeline.Command("sh", "-c", "echo 'hello world'\nexit 0").Name("build").Stdout(out).Stderr("devnul").
+			Timeout(time.Minute).StopTimeout(5*time.Second),
+		pipeline.Parallel(
+			pipeline.Command("/nonexistent/daemon", "--port", "8080").Name("daemon").
+				StdoutSinks(subprocess.Sink{Path: "stdout", Prefix: "[daemon] ", Timestamps: true}, subprocess.Sink{Path: out}).
+				OnExit(loop.ExitPolicyRestart).Delay(time.Second).
+				OnRestart(pipeline.Command("echo", "restarted")),
+			pipeline.Plugin("sleep", map[string]interface{}{"duration": "1s"}).Name("pause"),
+		).Name("services"),
+	).Name("root").OnExit(loop.ExitPolicyNone).Node()
+
+	var buf bytes.Buffer
+
+	require.NoError(t, node.WritePlan(&buf))
+	assert.Equal(t, `root: serial, 2 steps
+  onExit    none
+  1. build: command
+       run       sh -c $'echo \'hello world\'\nexit 0'
+       stdout    `+out+` (append)
+       stderr    discarded
+       onExit    propagate-if-err
+       timeout   1m0s
+       stop      SIGTERM, killed after 5s
+  2. services: parallel, 2 tasks at once
+       - daemon: command
+           run       /nonexistent/daemon --port 8080
+           warning   command not found
+           stdout    standard output (timestamps, prefix "[daemon] "), `+out+` (append)
+           stderr    standard error
+           onExit    restart
+           delay     1s
+           onRestart hook:
+             echo: command
+               run       echo restarted
+               stdout    standard output
+               stderr    standard error
+               onExit    propagate-if-err
+       - pause: plugin sleep
+           with      {"duration":"1s"}
+           onExit    propagate-if-err
+`, buf.String())
+	assert.NoFileExists(t, out)
+}
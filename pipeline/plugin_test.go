@@ -0,0 +1,130 @@
+package pipeline_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errUnhealthy = errors.New("unhealthy")
+	registerOnce sync.Once // nolint:gochecknoglobals // test fixture
+)
+
+// registerHealthCheck registers the `test-health-check` task type.
+func registerHealthCheck(t *testing.T) {
+	t.Helper()
+
+	registerOnce.Do(func() {
+		require.NoError(t, loop.Register("test-health-check", func(params map[string]interface{}) (loop.Task, error) {
+			healthy, _ := params["healthy"].(bool)
+
+			return loop.TaskFunc(func(context.Context) error {
+				if healthy {
+					return nil
+				}
+
+				return errUnhealthy
+			}), nil
+		}))
+	})
+}
+
+func TestPlugin(t *testing.T) {
+	t.Parallel()
+
+	registerHealthCheck(t)
+
+	type want struct {
+		err      string
+		buildErr string
+	}
+
+	testTable := map[string]struct {
+		str string
+		want
+	}{
+		"Healthy": {
+			str: `
+name: root
+steps:
+  - path: "true"
+  - type: test-health-check
+    with:
+      healthy: true
+  - path: "true"
+`,
+		},
+		"Unhealthy": {
+			str: `
+name: root
+steps:
+  - path: "true"
+  - type: test-health-check
+    with:
+      healthy: false
+  - path: "true"
+`,
+			want: want{err: "task root: iteration aborted: task test-health-check: unhealthy"},
+		},
+		"Unknown type": {
+			str: `
+name: root
+steps:
+  - type: does-not-exist
+`,
+			want: want{
+				buildErr: "root.steps[0]: does-not-exist: unknown task type",
+				err:      "task root: iteration aborted: task does-not-exist: does-not-exist: unknown task type",
+			},
+		},
+	}
+
+	for name, unit := range testTable {
+		unit := unit
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			node, err := pipeline.New(unit.str)
+			require.NoError(t, err)
+
+			if unit.want.buildErr != "" {
+				assert.EqualError(t, node.Validate(), unit.want.buildErr)
+			} else {
+				assert.NoError(t, node.Validate())
+			}
+
+			ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+			defer cancel()
+
+			err = node.WithLogger(nil).Run(ctx)
+			if unit.want.err != "" {
+				assert.EqualError(t, err, unit.want.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPluginBuilder(t *testing.T) {
+	t.Parallel()
+
+	registerHealthCheck(t)
+
+	node, err := pipeline.Serial(
+		pipeline.Command("true"),
+		pipeline.Plugin("test-health-check", map[string]interface{}{"healthy": true}),
+	).Log(pipeline.LogConfig{Disabled: true}).Build()
+	require.NoError(t, err)
+
+	assert.NoError(t, node.Run(context.TODO()))
+}
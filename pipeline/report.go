@@ -0,0 +1,245 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/pkg/errors"
+)
+
+// Status is the outcome of a node.
+type Status string
+
+const (
+	StatusCancelled Status = "cancelled"
+	StatusFailed    Status = "failed"
+	StatusSkipped   Status = "skipped"
+	StatusSuccess   Status = "success"
+	StatusTimedOut  Status = "timed-out"
+)
+
+// Kinds of nodes, as reported in Result.
+const (
+	KindCommand  = "command"
+	KindNoop     = "noop"
+	KindParallel = "parallel"
+	KindPlugin   = "plugin"
+	KindSerial   = "serial"
+)
+
+// Result is the report of a node execution. It mirrors the Node tree.
+type Result struct {
+	Args     []string  `json:"args,omitempty"`
+	Children []*Result `json:"children,omitempty"`
+	Command  string    `json:"command,omitempty"`
+	Duration Duration  `json:"duration"`
+	End      time.Time `json:"end"`
+	Error    string    `json:"error,omitempty"`
+	ExitCode *int      `json:"exitCode,omitempty"`
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	Restarts int       `json:"restarts"`
+	Signal   string    `json:"signal,omitempty"`
+	Start    time.Time `json:"start"`
+	Status   Status    `json:"status"`
+	Stderr   string    `json:"stderr,omitempty"`
+
+	attempts int
+	lastErr  error
+}
+
+type (
+	// processStater is implemented by tasks wrapping an OS process (eg: subprocess.Proc).
+	processStater interface {
+		ProcessState() *os.ProcessState
+	}
+
+	// stderrTailer is implemented by tasks retaining their standard error (eg: subprocess.Proc).
+	stderrTailer interface {
+		StderrTail() []byte
+	}
+)
+
+// RunWithResult executes the pipeline and returns a report of every node, along with the error returned by Run.
+func (n Node) RunWithResult(ctx context.Context) (*Result, error) {
+	report := newResult(&n)
+	exec := n.withResult(report)
+
+	err := exec.Run(ctx)
+
+	report.skipPending()
+
+	return report, err
+}
+
+// Kind returns the node kind, as reported in Result.
+func (n *Node) Kind() string {
+	switch {
+	case n.IsCommand():
+		return KindCommand
+	case n.IsPlugin():
+		return KindPlugin
+	case n.IsParallel():
+		return KindParallel
+	case n.IsSerial():
+		return KindSerial
+	default:
+		return KindNoop
+	}
+}
+
+// Children returns the child nodes that are actually executed.
+func (n *Node) Children() []Node {
+	switch n.Kind() {
+	case KindParallel:
+		return n.Parallel
+	case KindSerial:
+		return n.Steps
+	default:
+		return nil
+	}
+}
+
+// withResult returns a copy of the node tree where every node records into the matching result.
+func (n Node) withResult(res *Result) Node {
+	n.result = res
+
+	switch n.Kind() {
+	case KindParallel:
+		n.Parallel = withResults(n.Parallel, res.Children)
+	case KindSerial:
+		n.Steps = withResults(n.Steps, res.Children)
+	}
+
+	return n
+}
+
+// withResults applies withResult to a list of nodes.
+func withResults(nodes []Node, results []*Result) []Node {
+	list := make([]Node, 0, len(nodes))
+	for i := range nodes {
+		list = append(list, nodes[i].withResult(results[i]))
+	}
+
+	return list
+}
+
+// newResult returns an empty result tree for the node.
+func newResult(n *Node) *Result {
+	res := &Result{ // nolint:exhaustruct // filled during the execution
+		Args:    n.Args,
+		Command: n.Command,
+		Kind:    n.Kind(),
+		Name:    n.ID(),
+	}
+
+	nodes := n.Children()
+	for i := range nodes {
+		res.Children = append(res.Children, newResult(&nodes[i]))
+	}
+
+	return res
+}
+
+// start records the beginning of the node execution.
+func (r *Result) start() {
+	if r == nil {
+		return
+	}
+
+	r.Start = time.Now()
+}
+
+// finish records the end of the node execution; ctx is the node context.
+func (r *Result) finish(ctx context.Context, err error) {
+	if r == nil {
+		return
+	}
+
+	r.End = time.Now()
+	r.Duration = Duration(r.End.Sub(r.Start))
+
+	if err == nil {
+		// Commands may fail without propagating the error (eg: with ExitPolicyNone).
+		err = r.lastErr
+	}
+
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	r.Status = status(err)
+
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// track wraps a command (or plugin) task to record its attempts and exit codes.
+func (r *Result) track(task loop.Task) loop.Task { // nolint:ireturn // wrapper
+	if r == nil {
+		return task
+	}
+
+	return loop.TaskFunc(func(ctx context.Context) error {
+		r.attempts++
+		r.Restarts = r.attempts - 1
+
+		err := task.Run(ctx)
+		r.lastErr = err
+
+		if proc, ok := task.(processStater); ok {
+			r.exited(proc.ProcessState())
+		}
+
+		if proc, ok := task.(stderrTailer); ok {
+			r.Stderr = string(proc.StderrTail())
+		}
+
+		return err
+	})
+}
+
+// exited records the exit code and signal of a process.
+func (r *Result) exited(state *os.ProcessState) {
+	r.ExitCode, r.Signal = nil, ""
+
+	if state == nil {
+		return
+	}
+
+	code := state.ExitCode()
+	r.ExitCode = &code
+
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		r.Signal = ws.Signal().String()
+	}
+}
+
+// skipPending marks the nodes that never started as skipped.
+func (r *Result) skipPending() {
+	if r.Start.IsZero() {
+		r.Status = StatusSkipped
+	}
+
+	for _, child := range r.Children {
+		child.skipPending()
+	}
+}
+
+// status converts an error into a Status.
+func status(err error) Status {
+	switch {
+	case err == nil:
+		return StatusSuccess
+	case errors.Is(err, context.DeadlineExceeded):
+		return StatusTimedOut
+	case errors.Is(err, context.Canceled):
+		return StatusCancelled
+	default:
+		return StatusFailed
+	}
+}
@@ -0,0 +1,174 @@
+package pipeline_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunWithResult(t *testing.T) {
+	t.Parallel()
+
+	type (
+		fields struct {
+			instance pipeline.Node
+		}
+
+		want struct {
+			err string
+			// results is a flattened view of the tree: name => status/restarts/exit code.
+			results map[string]string
+		}
+	)
+
+	testTable := map[string]struct {
+		fields
+		want
+	}{
+		"Success": {
+			fields: fields{
+				instance: pipeline.Node{Command: "true", Name: "true-1"},
+			},
+			want: want{
+				results: map[string]string{"true-1": "success/0/0"},
+			},
+		},
+		"With policy none": {
+			fields: fields{
+				instance: pipeline.Node{
+					Name: "root",
+					Steps: []pipeline.Node{
+						{Command: "false", Name: "false-1", OnExit: "none"},
+						{Command: "true", Name: "true-1"},
+					},
+				},
+			},
+			want: want{
+				results: map[string]string{
+					"root":    "success/0/-",
+					"false-1": "failed/0/1",
+					"true-1":  "success/0/0",
+				},
+			},
+		},
+		"With skipped steps": {
+			fields: fields{
+				instance: pipeline.Node{
+					Name: "root",
+					Steps: []pipeline.Node{
+						{Args: []string{"-c", "exit 3"}, Command: "sh", Name: "exit-3"},
+						{Command: "true", Name: "true-1"},
+					},
+				},
+			},
+			want: want{
+				err: "task root: iteration aborted: task exit-3: exit status 3",
+				results: map[string]string{
+					"root":   "failed/0/-",
+					"exit-3": "failed/0/3",
+					"true-1": "skipped/0/-",
+				},
+			},
+		},
+		"With restarts and cancellation": {
+			fields: fields{
+				instance: pipeline.Node{
+					Name: "root",
+					Parallel: []pipeline.Node{
+						{Args: []string{"-c", "exit 1"}, Command: "sh", Delay: pipeline.Duration(200 * time.Millisecond), Name: "restart-1", OnExit: "restart"},
+						{Args: []string{"-c", "sleep 0.5; exit 5"}, Command: "sh", Name: "exit-5"},
+						{Args: []string{"10"}, Command: "sleep", Name: "sleep-1"},
+					},
+				},
+			},
+			want: want{
+				err: "task root: task exit-5: exit status 5",
+				results: map[string]string{
+					"root":      "failed/0/-",
+					"restart-1": "cancelled/2/1",
+					"exit-5":    "failed/0/5",
+					"sleep-1":   "cancelled/0/-1",
+				},
+			},
+		},
+		"With timeout": {
+			fields: fields{
+				instance: pipeline.Node{
+					Name:    "root",
+					Timeout: pipeline.Duration(100 * time.Millisecond),
+					Steps: []pipeline.Node{
+						{Args: []string{"10"}, Command: "sleep", Name: "sleep-1"},
+					},
+				},
+			},
+			want: want{
+				err: "task root: iteration aborted: task sleep-1: context deadline exceeded",
+				results: map[string]string{
+					"root":    "timed-out/0/-",
+					"sleep-1": "timed-out/0/-1",
+				},
+			},
+		},
+	}
+
+	for name, unit := range testTable {
+		unit := unit
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.TODO(), 2*time.Second)
+			defer cancel()
+
+			result, err := unit.fields.instance.WithLogger(nil).RunWithResult(ctx)
+			if unit.want.err != "" {
+				assert.EqualError(t, err, unit.want.err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			require.NotNil(t, result)
+
+			got := make(map[string]string)
+			flatten(result, func(res *pipeline.Result) {
+				code := "-"
+				if res.ExitCode != nil {
+					code = strconv.Itoa(*res.ExitCode)
+				}
+
+				got[res.Name] = fmt.Sprintf("%s/%d/%s", res.Status, res.Restarts, code)
+			})
+
+			assert.Equal(t, unit.want.results, got)
+		})
+	}
+}
+
+func TestRunWithResultSignal(t *testing.T) {
+	t.Parallel()
+
+	node := pipeline.Node{Args: []string{"-c", "kill -TERM $$"}, Command: "sh", Name: "kill-1"}
+
+	result, err := node.WithLogger(nil).RunWithResult(context.TODO())
+	assert.EqualError(t, err, "task kill-1: signal: terminated")
+
+	assert.Equal(t, pipeline.StatusFailed, result.Status)
+	assert.Equal(t, "terminated", result.Signal)
+	assert.Equal(t, "signal: terminated", result.Error)
+	assert.False(t, result.End.Before(result.Start))
+}
+
+// flatten calls fn for every result in the tree.
+func flatten(res *pipeline.Result, fn func(*pipeline.Result)) {
+	fn(res)
+
+	for _, child := range res.Children {
+		flatten(child, fn)
+	}
+}
@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+)
+
+// scheduled wraps the task of a node with a schedule (see Node.Schedule and Node.Every) so that it runs periodically,
+// until the context is done. The next run is logged and reported by the supervisor.
+func (n *Node) scheduled(task loop.Task) loop.Task { // nolint:ireturn // wrapper
+	schedule, err := n.schedule()
+
+	switch {
+	case err != nil:
+		return loop.TaskFunc(func(context.Context) error { return err })
+	case schedule == nil:
+		return task
+	}
+
+	return loop.Scheduled(loop.Named(n.Path(), task), schedule).
+		WithLogger(n.logger).
+		WithObserver(n.observer).
+		WithOverlap(n.Overlap).
+		WithNextRunHook(func(next time.Time) { n.supervisor.scheduled(n.Path(), next) })
+}
+
+// schedule returns the schedule of the node, or nil.
+func (n *Node) schedule() (loop.Schedule, error) { // nolint:ireturn // cron or interval
+	switch {
+	case n.Schedule != "":
+		cron, err := loop.ParseCron(n.Schedule)
+		if err != nil {
+			return nil, err // nolint:wrapcheck // already wrapped
+		}
+
+		return cron, nil
+	case n.Every > 0:
+		return loop.Every(n.Every.Duration()), nil
+	default:
+		return nil, nil
+	}
+}
+
+// scheduleSummary describes the schedule of the node, along with its overlap policy, or returns an empty string.
+func (n *Node) scheduleSummary() string {
+	schedule, err := n.schedule()
+	if err != nil || schedule == nil {
+		return ""
+	}
+
+	overlap := n.Overlap
+	if overlap == "" {
+		overlap = loop.OverlapSkip
+	}
+
+	return fmt.Sprintf("%s (overlap: %s)", schedule, overlap)
+}
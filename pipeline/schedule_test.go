@@ -0,0 +1,67 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule(t *testing.T) {
+	t.Parallel()
+
+	counter := filepath.Join(t.TempDir(), "counter")
+
+	node, err := pipeline.Parallel(
+		pipeline.Command("sh", "-c", "echo run >> "+counter).Name("job").Every(40*time.Millisecond),
+		pipeline.Command("sleep", "10").Name("daemon"),
+	).Name("root").Log(pipeline.LogConfig{Disabled: true}).Build()
+	require.NoError(t, err)
+
+	supervisor := pipeline.NewSupervisor(node)
+	node.WithSupervisor(supervisor)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		_ = node.Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(counter)
+
+		return len(strings.Fields(string(data))) >= 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	state, err := supervisor.Node("job")
+	require.NoError(t, err)
+	require.NotNil(t, state.NextRun)
+	assert.WithinDuration(t, time.Now(), *state.NextRun, time.Second)
+	assert.GreaterOrEqual(t, state.Restarts, 2)
+
+	cancel()
+	<-done
+}
+
+func TestSchedulePlan(t *testing.T) {
+	t.Parallel()
+
+	node := pipeline.Command("backup.sh").Schedule("0 3 * * *").Overlap("queue").Name("backup").Node()
+
+	var buf bytes.Buffer
+
+	require.NoError(t, node.WritePlan(&buf))
+	assert.Contains(t, buf.String(), "  schedule  0 3 * * * (overlap: queue)\n")
+}
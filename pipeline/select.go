@@ -0,0 +1,148 @@
+package pipeline
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// errNothingSelected is returned when a selection leaves nothing to run.
+var errNothingSelected = errors.New("no node selected")
+
+// Selection picks the nodes to run. Nodes are identified by name, by path (eg: root/stage1/daemon-1) or by path
+// suffix (eg: stage1/daemon-1).
+type Selection struct {
+	// Only runs just these nodes, along with their descendants (and the groups containing them).
+	Only []string
+	// Skip skips these nodes, along with their descendants.
+	Skip []string
+	// From skips the serial steps before this node (in every serial group containing it).
+	From string
+	// Until skips the serial steps after this node (in every serial group containing it).
+	Until string
+}
+
+// Select marks the nodes left out by the selection as skipped, before the tree runs: they are reported as such (see
+// Result and event.Observer) without running, and groups whose nodes are all skipped are skipped too. Every name must
+// match a node; From and Until must match a single one.
+func (n *Node) Select(sel Selection) error {
+	for _, name := range append(append([]string(nil), sel.Only...), sel.Skip...) {
+		if len(n.locate(name, n.ID(), nil)) == 0 {
+			return errors.Wrap(ErrUnknownNode, name)
+		}
+	}
+
+	for before, name := range map[bool]string{true: sel.From, false: sel.Until} {
+		if name == "" {
+			continue
+		}
+
+		switch found := n.locate(name, n.ID(), nil); len(found) {
+		case 0:
+			return errors.Wrap(ErrUnknownNode, name)
+		case 1:
+			n.skipAround(found[0], before)
+		default:
+			return errors.Wrap(ErrAmbiguousNode, name)
+		}
+	}
+
+	if !n.selectNodes(sel, n.ID(), len(sel.Only) == 0) {
+		return errNothingSelected
+	}
+
+	return nil
+}
+
+// Skipped returns whether the node was left out by Select.
+func (n *Node) Skipped() bool {
+	return n.skipped
+}
+
+// selectNodes marks the skipped nodes of the subtree and returns whether any node runs. selected is set when an
+// ancestor matched Only.
+func (n *Node) selectNodes(sel Selection, path string, selected bool) bool {
+	if n.skipped || matches(sel.Skip, n, path) {
+		n.skipAll()
+
+		return false
+	}
+
+	selected = selected || matches(sel.Only, n, path)
+
+	children := n.Children()
+	if len(children) == 0 {
+		n.skipped = !selected
+
+		return selected
+	}
+
+	running := false
+
+	for i := range children {
+		if children[i].selectNodes(sel, n.ChildPath(path, i), selected) {
+			running = true
+		}
+	}
+
+	n.skipped = !running
+
+	return running
+}
+
+// skipAround skips the serial steps before (or after) the node at the end of the chain of child indices.
+func (n *Node) skipAround(chain []int, before bool) {
+	if len(chain) == 0 {
+		return
+	}
+
+	children := n.Children()
+
+	if n.IsSerial() {
+		for i := range children {
+			if (before && i < chain[0]) || (!before && i > chain[0]) {
+				children[i].skipAll()
+			}
+		}
+	}
+
+	children[chain[0]].skipAround(chain[1:], before)
+}
+
+// skipAll marks the node and its descendants as skipped.
+func (n *Node) skipAll() {
+	n.skipped = true
+
+	children := n.Children()
+	for i := range children {
+		children[i].skipAll()
+	}
+}
+
+// locate returns the chains of child indices leading to the nodes matching name; chain leads to the node.
+func (n *Node) locate(name, path string, chain []int) [][]int {
+	var found [][]int
+
+	if matches([]string{name}, n, path) {
+		found = append(found, append([]int(nil), chain...))
+	}
+
+	children := n.Children()
+	for i := range children {
+		next := append(append([]int(nil), chain...), i)
+		found = append(found, children[i].locate(name, n.ChildPath(path, i), next)...)
+	}
+
+	return found
+}
+
+// matches returns whether a name of the list matches the node.
+func matches(names []string, n *Node, path string) bool {
+	for _, name := range names {
+		if name == n.ID() || name == path || strings.HasSuffix(path, "/"+name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,161 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelect(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		sel  pipeline.Selection
+		err  string
+		want []string
+	}{
+		"all": {
+			want: []string{"root", "root/lint", "root/build", "root/test", "root/test/unit", "root/test/e2e", "root/deploy"},
+		},
+		"only by name": {
+			sel:  pipeline.Selection{Only: []string{"build"}},
+			want: []string{"root", "root/build"},
+		},
+		"only a group": {
+			sel:  pipeline.Selection{Only: []string{"test"}},
+			want: []string{"root", "root/test", "root/test/unit", "root/test/e2e"},
+		},
+		"only by path": {
+			sel:  pipeline.Selection{Only: []string{"lint", "root/test/e2e"}},
+			want: []string{"root", "root/lint", "root/test", "root/test/e2e"},
+		},
+		"skip": {
+			sel:  pipeline.Selection{Skip: []string{"lint", "test/unit"}},
+			want: []string{"root", "root/build", "root/test", "root/test/e2e", "root/deploy"},
+		},
+		"from": {
+			sel:  pipeline.Selection{From: "test"},
+			want: []string{"root", "root/test", "root/test/unit", "root/test/e2e", "root/deploy"},
+		},
+		"until": {
+			sel:  pipeline.Selection{Until: "build"},
+			want: []string{"root", "root/lint", "root/build"},
+		},
+		"from a parallel task": {
+			sel:  pipeline.Selection{From: "e2e", Until: "e2e"},
+			want: []string{"root", "root/test", "root/test/unit", "root/test/e2e"},
+		},
+		"from and skip": {
+			sel:  pipeline.Selection{From: "build", Skip: []string{"test"}},
+			want: []string{"root", "root/build", "root/deploy"},
+		},
+		"skip a whole group": {
+			sel: pipeline.Selection{Only: []string{"test"}, Skip: []string{"unit", "e2e"}},
+			err: "no node selected",
+		},
+		"unknown": {
+			sel: pipeline.Selection{Skip: []string{"nope"}},
+			err: "nope: unknown node",
+		},
+		"unknown from": {
+			sel: pipeline.Selection{From: "nope"},
+			err: "nope: unknown node",
+		},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			node := selectionTree(t)
+
+			err := node.Select(tt.sel)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, running(node, node.ID()))
+		})
+	}
+}
+
+func TestSelectAmbiguous(t *testing.T) {
+	t.Parallel()
+
+	node := pipeline.Serial(pipeline.Command("true"), pipeline.Command("true")).Node()
+
+	assert.EqualError(t, node.Select(pipeline.Selection{Until: "true"}), "true: ambiguous node name, use its path")
+	// Only and Skip apply to every matching node.
+	assert.EqualError(t, node.Select(pipeline.Selection{Skip: []string{"true"}}), "no node selected")
+}
+
+func TestRunSelection(t *testing.T) {
+	t.Parallel()
+
+	node := selectionTree(t)
+	observer := &eventRecorder{}
+
+	node.WithObserver(observer)
+	require.NoError(t, node.Select(pipeline.Selection{From: "test", Skip: []string{"e2e"}}))
+
+	report, err := node.RunWithResult(context.TODO())
+	require.NoError(t, err)
+
+	statuses := make(map[string]pipeline.Status)
+	for _, res := range append(report.Children, report.Children[2].Children...) {
+		statuses[res.Name] = res.Status
+	}
+
+	assert.Equal(t, map[string]pipeline.Status{
+		"lint":   pipeline.StatusSkipped,
+		"build":  pipeline.StatusSkipped,
+		"test":   pipeline.StatusSuccess,
+		"unit":   pipeline.StatusSuccess,
+		"e2e":    pipeline.StatusSkipped,
+		"deploy": pipeline.StatusSuccess,
+	}, statuses)
+	assert.Subset(t, observer.events, []string{"skipped root/lint", "skipped root/build", "skipped root/test/e2e"})
+	assert.NotContains(t, observer.events, "started root/test/e2e")
+}
+
+// selectionTree returns a serial pipeline of commands, with a parallel group.
+func selectionTree(t *testing.T) *pipeline.Node {
+	t.Helper()
+
+	node, err := pipeline.Serial(
+		pipeline.Command("true").Name("lint"),
+		pipeline.Command("true").Name("build"),
+		pipeline.Parallel(
+			pipeline.Command("true").Name("unit"),
+			pipeline.Command("true").Name("e2e"),
+		).Name("test"),
+		pipeline.Command("true").Name("deploy"),
+	).Name("root").Log(pipeline.LogConfig{Disabled: true}).Build()
+	require.NoError(t, err)
+
+	return node
+}
+
+// running lists the paths of the nodes that are not skipped, depth first.
+func running(node *pipeline.Node, path string) []string {
+	if node.Skipped() {
+		return nil
+	}
+
+	list := []string{path}
+
+	children := node.Children()
+	for i := range children {
+		list = append(list, running(&children[i], path+"/"+children[i].ID())...)
+	}
+
+	return list
+}
@@ -0,0 +1,4 @@
+package subprocess
+
+// Compress exposes compress to the tests.
+var Compress = compress
@@ -0,0 +1,530 @@
+package subprocess
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/units"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// FileMode controls how a file sink is opened, whenever the process starts.
+type FileMode string
+
+const (
+	// FileModeAppend appends to the file (default).
+	FileModeAppend FileMode = "append"
+	// FileModePerRun writes into a new file per run, named after the sink path and the start time.
+	FileModePerRun FileMode = "per-run"
+	// FileModeTruncate truncates the file.
+	FileModeTruncate FileMode = "truncate"
+)
+
+const (
+	// runSuffix is the format of the suffix of the per-run files.
+	runSuffix = "20060102-150405.000"
+	// gzipSuffix is the suffix of the compressed files.
+	gzipSuffix = ".gz"
+)
+
+var (
+	// ErrFileMode is returned for unknown file modes.
+	ErrFileMode = errors.New("unknown file mode")
+	// errFileOptions is returned when file options are set on a stream alias.
+	errFileOptions = errors.New("mode, rotation and permissions are only supported by files")
+	// errInvalidPerm is returned when a permission cannot be parsed.
+	errInvalidPerm = errors.New("invalid permissions, expected an octal number (eg: 0640)")
+	// errInvalidSize is returned when a size cannot be parsed.
+	errInvalidSize = errors.New("invalid size, expected a number of bytes (eg: 512K, 10MB or 1GiB)")
+	// errNegative is returned when a size or a count is negative.
+	errNegative = errors.New("negative size or count")
+	// globChars matches the glob metacharacters.
+	globChars = regexp.MustCompile(`[*?\[\\]`)
+	// runFile matches the per-run file names, after the sink path.
+	runFile = regexp.MustCompile(`^\.\d{8}-\d{6}\.\d{3}$`)
+	// sizeUnits are the multipliers of the size units (powers of 1024).
+	sizeUnits = map[string]int64{"": 1, "B": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+)
+
+type (
+	// Duration is a time.Duration encoded as a string (eg: 24h), like the durations of the pipeline.
+	Duration = units.Duration
+
+	// Perm is a file permission, encoded as an octal string (eg: 0640).
+	Perm os.FileMode
+
+	// Size is a number of bytes, encoded with a unit (eg: 10MiB; KB, MB and GB are powers of 1024 as well).
+	Size int64
+
+	// rotatingFile is a file sink rotated by size or age: the current file becomes <path>.1, the previous <path>.1
+	// becomes <path>.2 and so on (with a .gz suffix once compressed), up to the number of retained files. The age of
+	// an existing file is counted from its last modification.
+	//
+	// When a rotation fails, the output goes on into the current file (logged once, until a rotation succeeds) and
+	// the rotation is tried again on the next write.
+	rotatingFile struct {
+		mtx    sync.Mutex
+		sink   Sink
+		logger *zap.Logger
+		path   string
+		file   *os.File
+		size   int64
+		opened time.Time
+		failed bool
+	}
+)
+
+// Validate returns ErrFileMode when the mode is unknown. An empty mode is valid.
+func (m FileMode) Validate() error {
+	switch m {
+	case "", FileModeAppend, FileModePerRun, FileModeTruncate:
+		return nil
+	default:
+		return errors.Wrap(ErrFileMode, string(m))
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler (used by the JSON and TOML decoders).
+func (m *FileMode) UnmarshalText(text []byte) error {
+	mode := FileMode(text)
+	if err := mode.Validate(); err != nil {
+		return err
+	}
+
+	*m = mode
+
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (m *FileMode) UnmarshalYAML(value *yaml.Node) error {
+	return errors.Wrapf(m.UnmarshalText([]byte(value.Value)), "line %d", value.Line)
+}
+
+// String returns the permission in octal.
+func (p Perm) String() string {
+	return fmt.Sprintf("%04o", uint32(p))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p Perm) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler (used by the JSON and TOML decoders).
+func (p *Perm) UnmarshalText(text []byte) error {
+	val, err := strconv.ParseUint(strings.TrimPrefix(string(text), "0o"), 8, 32)
+	if err != nil || os.FileMode(val)&^os.ModePerm != 0 {
+		return errors.Wrap(errInvalidPerm, string(text))
+	}
+
+	*p = Perm(val)
+
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, reading the number as octal even without the 0o prefix.
+func (p *Perm) UnmarshalYAML(value *yaml.Node) error {
+	return errors.Wrapf(p.UnmarshalText([]byte(value.Value)), "line %d", value.Line)
+}
+
+// String returns the size with the largest exact unit.
+func (s Size) String() string {
+	for _, unit := range []string{"G", "M", "K"} {
+		if mul := sizeUnits[unit]; s != 0 && int64(s)%mul == 0 {
+			return strconv.FormatInt(int64(s)/mul, 10) + unit + "iB"
+		}
+	}
+
+	return strconv.FormatInt(int64(s), 10) + "B"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Size) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler (used by the JSON and TOML decoders).
+func (s *Size) UnmarshalText(text []byte) error {
+	str := strings.ToUpper(strings.TrimSpace(string(text)))
+	digits := strings.TrimRightFunc(str, func(r rune) bool { return r < '0' || r > '9' })
+	unit := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(str[len(digits):]), "B"), "I")
+
+	mul, ok := sizeUnits[unit]
+	val, err := strconv.ParseInt(digits, 10, 64)
+
+	if !ok || err != nil {
+		return errors.Wrap(errInvalidSize, string(text))
+	}
+
+	*s = Size(val * mul)
+
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (s *Size) UnmarshalYAML(value *yaml.Node) error {
+	return errors.Wrapf(s.UnmarshalText([]byte(value.Value)), "line %d", value.Line)
+}
+
+// isFile returns whether the sink is a file (rather than a stream alias).
+func (s Sink) isFile() bool {
+	switch s.Path {
+	case devnul, logSink, stderr, stdout:
+		return false
+	default:
+		return true
+	}
+}
+
+// fileOptions returns whether any of the file options is set.
+func (s Sink) fileOptions() bool {
+	return s.Mode != "" || s.MaxSize != 0 || s.MaxAge != 0 || s.Keep != 0 || s.Compress || s.Perm != 0
+}
+
+// Validate checks the sink options.
+func (s Sink) Validate() error {
+	switch {
+	case s.fileOptions() && !s.isFile():
+		return errors.Wrap(errFileOptions, s.Path)
+	case s.MaxSize < 0 || s.MaxAge < 0 || s.Keep < 0 || s.MaxLine < 0:
+		return errors.Wrap(errNegative, s.Path)
+	}
+
+	return s.Mode.Validate()
+}
+
+// fileDetails describes the mode, rotation and permissions of a file sink.
+func (s Sink) fileDetails() []string {
+	details := []string{string(FileModeAppend)}
+
+	if s.Mode != "" {
+		details[0] = string(s.Mode)
+	}
+
+	if s.MaxSize > 0 {
+		details = append(details, "rotated at "+s.MaxSize.String())
+	}
+
+	if s.MaxAge > 0 {
+		details = append(details, "rotated every "+s.MaxAge.String())
+	}
+
+	if s.Keep > 0 {
+		details = append(details, "keeping "+strconv.Itoa(s.Keep))
+	}
+
+	if s.Compress {
+		details = append(details, "gzip")
+	}
+
+	if s.Perm != 0 {
+		details = append(details, "perm "+s.Perm.String())
+	}
+
+	return details
+}
+
+// openFile opens a file sink, according to its mode, rotation and permissions; rotation failures are logged into
+// logger.
+func (s Sink) openFile(logger *zap.Logger) (io.WriteCloser, error) {
+	path, flags := s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND // nolint:nosnakecase // go package
+
+	switch s.Mode {
+	case FileModePerRun:
+		if err := s.pruneRuns(); err != nil {
+			return nil, err
+		}
+
+		path += "." + time.Now().Format(runSuffix)
+	case FileModeTruncate:
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC // nolint:nosnakecase // go package
+	case "", FileModeAppend:
+	}
+
+	file, err := os.OpenFile(path, flags, s.perm())
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open %s", path)
+	}
+
+	if s.MaxSize == 0 && s.MaxAge == 0 {
+		return writeCloser{file, file}, nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return nil, errors.Wrapf(err, "cannot open %s", path)
+	}
+
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	return &rotatingFile{
+		mtx:    sync.Mutex{},
+		sink:   s,
+		logger: logger,
+		path:   path,
+		file:   file,
+		size:   info.Size(),
+		opened: info.ModTime(),
+		failed: false,
+	}, nil
+}
+
+// perm returns the permissions of the new files.
+func (s Sink) perm() os.FileMode {
+	if s.Perm == 0 {
+		return perm
+	}
+
+	return os.FileMode(s.Perm)
+}
+
+// pruneRuns removes the oldest per-run files (and their rotated files), keeping Keep of them besides the new one.
+func (s Sink) pruneRuns() error {
+	if s.Keep == 0 {
+		return nil
+	}
+
+	matches, err := filepath.Glob(escapeGlob(s.Path) + ".*")
+	if err != nil {
+		return errors.Wrap(err, "cannot list runs")
+	}
+
+	runs := make([]string, 0, len(matches))
+
+	for _, match := range matches {
+		if runFile.MatchString(strings.TrimPrefix(match, s.Path)) {
+			runs = append(runs, match)
+		}
+	}
+
+	sort.Strings(runs)
+
+	for len(runs) > s.Keep {
+		rotated, _ := filepath.Glob(escapeGlob(runs[0]) + ".*")
+
+		for _, file := range append(rotated, runs[0]) {
+			if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return errors.Wrap(err, "cannot remove run")
+			}
+		}
+
+		runs = runs[1:]
+	}
+
+	return nil
+}
+
+// Write writes into the current file, rotating it first if it is too big or too old.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if r.due(len(p)) {
+		if err := r.rotate(); err != nil {
+			if !r.failed {
+				r.logger.Warn("rotation failed", zap.String("path", r.path), zap.Error(err))
+			}
+
+			r.failed = true
+
+			if err := r.reopen(); err != nil {
+				return 0, err
+			}
+		} else {
+			r.failed = false
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+
+	return n, err // nolint:wrapcheck // not relevant
+}
+
+// Close closes the current file.
+func (r *rotatingFile) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err // nolint:wrapcheck // not relevant
+}
+
+// due returns whether the file must be rotated before writing n bytes (an empty file is never rotated).
+func (r *rotatingFile) due(n int) bool {
+	switch {
+	case r.size == 0:
+		return false
+	case r.sink.MaxSize > 0 && r.size+int64(n) > int64(r.sink.MaxSize):
+		return true
+	default:
+		return r.sink.MaxAge > 0 && time.Since(r.opened) >= time.Duration(r.sink.MaxAge)
+	}
+}
+
+// rotate shifts the previous files, moves the current one to <path>.1 (compressed if required) and starts a new one.
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return errors.Wrap(err, "cannot rotate")
+	}
+
+	r.file = nil
+
+	last := r.sink.Keep
+	if last == 0 {
+		// Keep them all: shift up to the first missing one.
+		last = 1
+
+		for r.rotated(last) != "" {
+			last++
+		}
+	}
+
+	for i := last; i > 0; i-- {
+		file := r.rotated(i)
+
+		switch {
+		case file == "":
+			continue
+		case i == last:
+			if err := os.Remove(file); err != nil {
+				return errors.Wrap(err, "cannot rotate")
+			}
+		default:
+			next := r.path + "." + strconv.Itoa(i+1) + strings.TrimPrefix(file, r.path+"."+strconv.Itoa(i))
+			if err := os.Rename(file, next); err != nil {
+				return errors.Wrap(err, "cannot rotate")
+			}
+		}
+	}
+
+	first := r.path + ".1"
+
+	if err := os.Rename(r.path, first); err != nil {
+		return errors.Wrap(err, "cannot rotate")
+	}
+
+	if r.sink.Compress {
+		if err := compress(first, r.sink.perm()); err != nil {
+			return errors.Wrap(err, "cannot compress")
+		}
+	}
+
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, r.sink.perm()) // nolint:nosnakecase // go package
+	if err != nil {
+		return errors.Wrapf(err, "cannot open %s", r.path)
+	}
+
+	r.file, r.size, r.opened = file, 0, time.Now()
+
+	return nil
+}
+
+// reopen opens the current file again after a failed rotation, unless it is still open (it is created if it was
+// moved already).
+func (r *rotatingFile) reopen() error {
+	if r.file != nil {
+		return nil
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND // nolint:nosnakecase // go package
+
+	file, err := os.OpenFile(r.path, flags, r.sink.perm())
+	if err != nil {
+		return errors.Wrapf(err, "cannot open %s", r.path)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return errors.Wrapf(err, "cannot open %s", r.path)
+	}
+
+	r.file, r.size = file, info.Size()
+
+	if r.size == 0 {
+		r.opened = time.Now()
+	}
+
+	return nil
+}
+
+// rotated returns the i-th rotated file, compressed or not, or an empty string if missing.
+func (r *rotatingFile) rotated(i int) string {
+	name := r.path + "." + strconv.Itoa(i)
+
+	for _, file := range []string{name, name + gzipSuffix} {
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+
+	return ""
+}
+
+// compress replaces a file with its gzipped copy. On failure, the file is kept and the partial copy is removed (it
+// would be taken for a rotated file).
+func compress(path string, perm os.FileMode) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err // nolint:wrapcheck // wrapped by the caller
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(path+gzipSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm) // nolint:nosnakecase // go package
+	if err != nil {
+		return err // nolint:wrapcheck // wrapped by the caller
+	}
+
+	zw := gzip.NewWriter(out)
+
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(path + gzipSuffix)
+
+		return err // nolint:wrapcheck // wrapped by the caller
+	}
+
+	return os.Remove(path) // nolint:wrapcheck // wrapped by the caller
+}
+
+// escapeGlob escapes the glob metacharacters of a path.
+func escapeGlob(path string) string {
+	return globChars.ReplaceAllString(path, `\$0`)
+}
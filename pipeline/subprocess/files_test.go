@@ -0,0 +1,225 @@
+package subprocess_test
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestFileModes(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		mode subprocess.FileMode
+		want map[string]string
+	}{
+		"Append": {
+			mode: subprocess.FileModeAppend,
+			want: map[string]string{"out.log": "run 1\nrun 2\nrun 3\n"},
+		},
+		"Truncate": {
+			mode: subprocess.FileModeTruncate,
+			want: map[string]string{"out.log": "run 3\n"},
+		},
+		"Per run": {
+			// The first run is dropped, keeping one besides the last one.
+			mode: subprocess.FileModePerRun,
+			want: map[string]string{"out.log.<run 1>": "run 2\n", "out.log.<run 2>": "run 3\n"},
+		},
+	}
+
+	for name, unit := range testTable {
+		unit := unit
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			sink := subprocess.Sink{Path: filepath.Join(dir, "out.log"), Mode: unit.mode, Keep: 1, Perm: 0o600}
+
+			for _, run := range []string{"run 1\n", "run 2\n", "run 3\n"} {
+				writeAll(t, subprocess.Sinks{sink}, run)
+				// The per-run files are named after the start time, in milliseconds.
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			assert.Equal(t, unit.want, readDir(t, dir))
+
+			info, err := os.Stat(filepath.Join(dir, "out.log"))
+			if unit.mode != subprocess.FileModePerRun {
+				require.NoError(t, err)
+				assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	sinks := subprocess.Sinks{{Path: filepath.Join(dir, "out.log"), MaxSize: 10, Keep: 2, Compress: true}}
+
+	writeAll(t, sinks, "0123456\n", "abcdefg\n", "ABCDEFG\n", "last\n")
+
+	// The oldest file is dropped, past the retained ones.
+	assert.Equal(t, map[string]string{
+		"out.log":      "last\n",
+		"out.log.1.gz": "ABCDEFG\n",
+		"out.log.2.gz": "abcdefg\n",
+	}, readDir(t, dir))
+}
+
+func TestFileRotationByAge(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.log")
+
+	// The age of an existing file is counted from its last modification, not from the start of the process.
+	require.NoError(t, os.WriteFile(path, []byte("old\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour)))
+
+	sinks := subprocess.Sinks{{Path: path, MaxAge: subprocess.Duration(time.Hour)}}
+	writeAll(t, sinks, "new\n", "newer\n")
+
+	assert.Equal(t, map[string]string{
+		"out.log":   "new\nnewer\n",
+		"out.log.1": "old\n",
+	}, readDir(t, dir))
+}
+
+func TestFileRotationFailure(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.log")
+
+	// The oldest file cannot be removed: the output goes on into the current file.
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "busy"), 0o700))
+
+	sinks := subprocess.Sinks{{Path: path, MaxSize: 10, Keep: 1}}
+	writeAll(t, sinks, "0123456\n", "abcdefg\n", "ABCDEFG\n")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456\nabcdefg\nABCDEFG\n", string(data))
+}
+
+func TestCompressFailure(t *testing.T) {
+	t.Parallel()
+
+	// Reading a directory fails once the compressed copy is created.
+	path := filepath.Join(t.TempDir(), "out.log.1")
+	require.NoError(t, os.Mkdir(path, 0o700))
+
+	assert.Error(t, subprocess.Compress(path, 0o600))
+	assert.DirExists(t, path)
+	assert.NoFileExists(t, path+".gz")
+}
+
+func TestSinkOptionsDecode(t *testing.T) {
+	t.Parallel()
+
+	var sinks subprocess.Sinks
+
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- path: out.log
+  mode: per-run
+  maxSize: 10MB
+  maxAge: 24h
+  keep: 5
+  compress: true
+  perm: 0640
+`), &sinks))
+	assert.Equal(t, subprocess.Sinks{{
+		Path:     "out.log",
+		Mode:     subprocess.FileModePerRun,
+		MaxSize:  10 << 20,
+		MaxAge:   subprocess.Duration(24 * time.Hour),
+		Keep:     5,
+		Compress: true,
+		Perm:     0o640,
+	}}, sinks)
+	assert.Equal(t, "out.log (per-run, rotated at 10MiB, rotated every 24h0m0s, keeping 5, gzip, perm 0640)",
+		sinks.Describe("stdout"))
+
+	data, err := yaml.Marshal(sinks)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "maxSize: 10MiB\n")
+	assert.Contains(t, string(data), "perm: \"0640\"\n")
+
+	assert.ErrorContains(t, yaml.Unmarshal([]byte(`{path: out.log, mode: rotate}`), &sinks), "rotate: unknown file mode")
+	assert.ErrorContains(t, yaml.Unmarshal([]byte(`{path: out.log, maxSize: 1.5G}`), &sinks), "invalid size")
+	assert.ErrorContains(t, yaml.Unmarshal([]byte(`{path: out.log, perm: 0999}`), &sinks), "invalid permissions")
+	assert.ErrorContains(t, yaml.Unmarshal([]byte(`{path: out.log, maxAge: 3600}`), &sinks), "duration without a unit")
+	assert.ErrorContains(t, subprocess.Sink{Path: "log", Keep: 1}.Validate(), "only supported by files")
+}
+
+// writeAll opens the sinks, writes the chunks in order and closes them.
+func writeAll(t *testing.T, sinks subprocess.Sinks, chunks ...string) {
+	t.Helper()
+
+	stream, err := sinks.Open("stdout")
+	require.NoError(t, err)
+
+	for _, chunk := range chunks {
+		_, err := stream.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, stream.Close())
+}
+
+// readDir returns the (uncompressed) content of the files of a directory, by name; the per-run suffixes are replaced
+// by their position.
+func readDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	sort.Strings(names)
+
+	files, runs := make(map[string]string, len(names)), 0
+
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(dir, name))
+		require.NoError(t, err)
+
+		var reader io.Reader = f
+
+		if filepath.Ext(name) == ".gz" {
+			reader, err = gzip.NewReader(f)
+			require.NoError(t, err)
+		}
+
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		f.Close()
+
+		if matched, _ := filepath.Match("out.log.????????-??????.???", name); matched {
+			runs++
+			name = fmt.Sprintf("out.log.<run %d>", runs)
+		}
+
+		files[name] = string(data)
+	}
+
+	return files
+}
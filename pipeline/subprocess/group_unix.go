@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package subprocess
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setGroup starts the process in its own group, so that its children are signalled (and killed) along with it.
+func setGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // nolint:exhaustruct // defaults
+}
+
+// signalGroup sends a signal to the process group led by pid.
+func signalGroup(pid int, sig syscall.Signal) error {
+	return syscall.Kill(-pid, sig) // nolint:wrapcheck // wrapped by the callers
+}
@@ -0,0 +1,32 @@
+//go:build windows
+// +build windows
+
+package subprocess
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// errUnsupportedSignal is returned when sending a signal other than SIGKILL.
+var errUnsupportedSignal = errors.New("signal not supported on windows")
+
+// setGroup does nothing: Windows has no process groups, the children of the process are not stopped with it.
+func setGroup(*exec.Cmd) {}
+
+// signalGroup kills the process on SIGKILL, the only signal supported.
+func signalGroup(pid int, sig syscall.Signal) error {
+	if sig != syscall.SIGKILL {
+		return errors.Wrap(errUnsupportedSignal, sig.String())
+	}
+
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err // nolint:wrapcheck // wrapped by the callers
+	}
+
+	return proc.Kill() // nolint:wrapcheck // ditto
+}
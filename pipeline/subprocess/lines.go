@@ -0,0 +1,144 @@
+package subprocess
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// maxLine is the default length after which a line is split.
+	maxLine = 64 << 10
+	// lineTimestamp is the format of the line timestamps.
+	lineTimestamp = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// lineWriter splits a stream into lines (without their newline), handed over to emit. Lines longer than limit are
+// split, and the last one is handed over by Close even without a newline: such chunks are partial.
+type lineWriter struct {
+	mtx     sync.Mutex
+	pending []byte
+	limit   int
+	emit    func(line []byte, partial bool) error
+	closer  io.Closer
+}
+
+// newLineWriter returns a writer splitting lines longer than limit (maxLine if not positive); closer (if any) is
+// closed by Close.
+func newLineWriter(limit int, emit func(line []byte, partial bool) error, closer io.Closer) *lineWriter {
+	if limit <= 0 {
+		limit = maxLine
+	}
+
+	return &lineWriter{mtx: sync.Mutex{}, pending: nil, limit: limit, emit: emit, closer: closer}
+}
+
+// newPrefixWriter returns a writer prefixing every line written into out with a string and (optionally) a timestamp.
+// Only whole lines are written, so that the lines of concurrent processes sharing a stream are not mixed up.
+func newPrefixWriter(out io.WriteCloser, limit int, prefix string, timestamps bool) *lineWriter {
+	return newLineWriter(limit, func(line []byte, _ bool) error {
+		var buf bytes.Buffer
+
+		if timestamps {
+			buf.WriteString(time.Now().Format(lineTimestamp))
+			buf.WriteByte(' ')
+		}
+
+		buf.WriteString(prefix)
+		buf.Write(line)
+		buf.WriteByte('\n')
+
+		_, err := out.Write(buf.Bytes())
+
+		return err // nolint:wrapcheck // not relevant
+	}, out)
+}
+
+// newLogWriter returns a writer logging every line as an entry, along with the stream name and the process ID.
+func newLogWriter(logger *zap.Logger, limit int, level zapcore.Level, stream string, pid func() int) *lineWriter {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	logger = logger.With(zap.String("stream", stream))
+
+	return newLineWriter(limit, func(line []byte, partial bool) error {
+		entry := logger.Check(level, string(line))
+		if entry == nil {
+			return nil
+		}
+
+		fields := []zap.Field{zap.Int("pid", pid())}
+		if partial {
+			fields = append(fields, zap.Bool("partial", true))
+		}
+
+		entry.Write(fields...)
+
+		return nil
+	}, nil)
+}
+
+// Write implements io.Writer.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.pending = append(w.pending, p...)
+	rest := w.pending
+
+	for {
+		end := bytes.IndexByte(rest, '\n')
+
+		switch {
+		case end >= 0 && end <= w.limit:
+			if err := w.emit(rest[:end], false); err != nil {
+				return 0, err
+			}
+
+			rest = rest[end+1:]
+
+			continue
+		case len(rest) >= w.limit:
+			if err := w.emit(rest[:w.limit], true); err != nil {
+				return 0, err
+			}
+
+			rest = rest[w.limit:]
+
+			continue
+		}
+
+		break
+	}
+
+	// The remainder is copied, so that the buffer does not grow forever.
+	w.pending = append([]byte(nil), rest...)
+
+	return len(p), nil
+}
+
+// Close hands over the last line, if any, and closes the stream.
+func (w *lineWriter) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	var err error
+
+	if len(w.pending) > 0 {
+		err = w.emit(w.pending, true)
+		w.pending = nil
+	}
+
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,331 @@
+package subprocess
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// logSink is the alias of the sink writing into the structured logger.
+const logSink = "log"
+
+// errInvalidSink is returned when a sink cannot be decoded.
+var errInvalidSink = errors.New("invalid sink, expected a stream name or an object with a path")
+
+type (
+	// Sink is a destination of a stream: a file (appended to by default) or one of the stdout, stderr, devnul and log
+	// aliases. Its lines can be prefixed with a fixed string (eg: `[daemon-2] `) and a timestamp.
+	//
+	// The log alias writes every line as an entry of the process logger (see Proc.Logger), at Level, along with the
+	// stream name and the process ID. Lines longer than MaxLine (64KiB by default) are split, and an unterminated last
+	// line is written when the process exits: such entries are marked as partial.
+	//
+	// Files are opened according to Mode whenever the process starts, with Perm (0644 by default) if new. They are
+	// rotated once bigger than MaxSize or older than MaxAge (since their last modification, when they exist already),
+	// keeping Keep previous files (all of them by default), gzipped if Compress. In per-run mode, Keep is the number of
+	// previous runs retained.
+	//
+	// A sink decodes from a stream name as well as from an object.
+	Sink struct {
+		Compress   bool          `json:"compress,omitempty"   toml:"compress,omitempty"   yaml:"compress,omitempty"`
+		Keep       int           `json:"keep,omitempty"       toml:"keep,omitempty"       yaml:"keep,omitempty"`
+		Level      zapcore.Level `json:"level,omitempty"      toml:"level,omitempty"      yaml:"level,omitempty"`
+		MaxAge     Duration      `json:"maxAge,omitempty"     toml:"maxAge,omitempty"     yaml:"maxAge,omitempty"`
+		MaxLine    int           `json:"maxLine,omitempty"    toml:"maxLine,omitempty"    yaml:"maxLine,omitempty"`
+		MaxSize    Size          `json:"maxSize,omitempty"    toml:"maxSize,omitempty"    yaml:"maxSize,omitempty"`
+		Mode       FileMode      `json:"mode,omitempty"       toml:"mode,omitempty"       yaml:"mode,omitempty"`
+		Path       string        `json:"path"                 toml:"path"                 yaml:"path"`
+		Perm       Perm          `json:"perm,omitempty"       toml:"perm,omitempty"       yaml:"perm,omitempty"`
+		Prefix     string        `json:"prefix,omitempty"     toml:"prefix,omitempty"     yaml:"prefix,omitempty"`
+		Timestamps bool          `json:"timestamps,omitempty" toml:"timestamps,omitempty" yaml:"timestamps,omitempty"`
+	}
+
+	// Sinks is the list of destinations of a stream, each receiving a copy of it. It decodes from a single sink as
+	// well as from a list (in TOML, a stream name or a list of tables).
+	Sinks []Sink
+
+	// sinkFields decodes a sink object, without recursing into Sink.UnmarshalYAML and Sink.UnmarshalJSON.
+	sinkFields Sink
+)
+
+// To returns the sinks writing into the named streams, as they are.
+func To(streams ...string) Sinks {
+	sinks := make(Sinks, 0, len(streams))
+	for _, stream := range streams {
+		sinks = append(sinks, Sink{Path: stream}) // nolint:exhaustruct // defaults
+	}
+
+	return sinks
+}
+
+// Open returns a stream writing into every sink, or into the fallback stream when there is none (the log sink is
+// discarded, see Proc.Logger).
+func (s Sinks) Open(fallback string) (io.WriteCloser, error) {
+	return s.open(fallback, nil, nil)
+}
+
+// open returns a stream writing into every sink, or into the stream (stdout or stderr) when there is none; the log
+// sink writes into logger, with the stream name and the process ID returned by pid.
+func (s Sinks) open(stream string, logger *zap.Logger, pid func() int) (io.WriteCloser, error) {
+	if len(s) == 0 {
+		s = To(stream)
+	}
+
+	streams := newMultiWriteCloser(logger, stream, len(s))
+
+	for _, sink := range s {
+		out, err := sink.open(stream, logger, pid)
+		if err != nil {
+			streams.Close()
+
+			return nil, err
+		}
+
+		streams.add(out, sink.Path)
+	}
+
+	if len(streams.streams) == 1 {
+		return streams.streams[0], nil
+	}
+
+	return streams, nil
+}
+
+// Describe tells where a stream writes (see Target), with the fallback stream when there is no sink.
+func (s Sinks) Describe(fallback string) string {
+	if len(s) == 0 {
+		return Target(fallback)
+	}
+
+	targets := make([]string, 0, len(s))
+	for _, sink := range s {
+		targets = append(targets, sink.Describe())
+	}
+
+	return strings.Join(targets, ", ")
+}
+
+// open returns a stream writing into the sink; name is the stream in the log entries.
+func (s Sink) open(name string, logger *zap.Logger, pid func() int) (io.WriteCloser, error) {
+	if s.Path == logSink {
+		if pid == nil {
+			pid = func() int { return 0 }
+		}
+
+		return newLogWriter(logger, s.MaxLine, s.Level, name, pid), nil
+	}
+
+	open := func() (io.WriteCloser, error) { return s.openFile(logger) }
+	if !s.isFile() {
+		open = func() (io.WriteCloser, error) { return WriteCloser(s.Path) }
+	}
+
+	stream, err := open()
+	if err != nil || (s.Prefix == "" && !s.Timestamps) {
+		return stream, err
+	}
+
+	return newPrefixWriter(stream, s.MaxLine, s.Prefix, s.Timestamps), nil
+}
+
+// Describe tells where the sink writes, and how.
+func (s Sink) Describe() string {
+	target, details := Target(s.Path), make([]string, 0)
+
+	if s.isFile() {
+		target, details = s.Path, append(details, s.fileDetails()...)
+	}
+
+	if s.Path == logSink {
+		details = append(details, "level "+s.Level.String())
+	}
+
+	if s.Timestamps {
+		details = append(details, "timestamps")
+	}
+
+	if s.Prefix != "" {
+		details = append(details, fmt.Sprintf("prefix %q", s.Prefix))
+	}
+
+	if len(details) == 0 {
+		return target
+	}
+
+	return target + " (" + strings.Join(details, ", ") + ")"
+}
+
+// plain returns whether the sink is just a stream name.
+func (s Sink) plain() bool {
+	return s.Level == zapcore.InfoLevel && s.MaxLine == 0 && s.Prefix == "" && !s.Timestamps && !s.fileOptions()
+}
+
+// MarshalJSON implements json.Marshaler, encoding a plain sink as its stream name.
+func (s Sink) MarshalJSON() ([]byte, error) {
+	if s.plain() {
+		return json.Marshal(s.Path) // nolint:wrapcheck // not relevant
+	}
+
+	return json.Marshal(sinkFields(s)) // nolint:wrapcheck // not relevant
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Sink) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &s.Path); err == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, (*sinkFields)(s)); err != nil {
+		return errors.Wrap(errInvalidSink, err.Error())
+	}
+
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler, encoding a plain sink as its stream name.
+func (s Sink) MarshalYAML() (interface{}, error) {
+	if s.plain() {
+		return s.Path, nil
+	}
+
+	return sinkFields(s), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (s *Sink) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind { // nolint:exhaustive // the others are invalid
+	case yaml.ScalarNode:
+		s.Path = value.Value
+
+		return nil
+	case yaml.MappingNode:
+		return value.Decode((*sinkFields)(s)) // nolint:wrapcheck // errors are already prefixed with "yaml:"
+	default:
+		return errors.Wrapf(errInvalidSink, "line %d", value.Line)
+	}
+}
+
+// MarshalJSON implements json.Marshaler, encoding a single sink on its own.
+func (s Sinks) MarshalJSON() ([]byte, error) {
+	if len(s) == 1 {
+		return s[0].MarshalJSON()
+	}
+
+	return json.Marshal([]Sink(s)) // nolint:wrapcheck // not relevant
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Sinks) UnmarshalJSON(data []byte) error {
+	var (
+		name string
+		sink Sink
+	)
+
+	if err := json.Unmarshal(data, &name); err == nil {
+		return s.UnmarshalText([]byte(name))
+	}
+
+	if err := json.Unmarshal(data, (*[]Sink)(s)); err == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, &sink); err != nil {
+		return errors.Wrap(errInvalidSink, err.Error())
+	}
+
+	*s = Sinks{sink}
+
+	return nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler (used by the TOML decoder for a single stream name).
+func (s *Sinks) UnmarshalText(text []byte) error {
+	*s = To(string(text))
+
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler, encoding a single sink on its own.
+func (s Sinks) MarshalYAML() (interface{}, error) {
+	if len(s) == 1 {
+		return s[0].MarshalYAML()
+	}
+
+	return []Sink(s), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (s *Sinks) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode || value.Kind == yaml.MappingNode {
+		*s = make(Sinks, 1)
+
+		return (*s)[0].UnmarshalYAML(value)
+	}
+
+	return value.Decode((*[]Sink)(s)) // nolint:wrapcheck // errors are already prefixed with "yaml:"
+}
+
+// multiWriteCloser writes into several streams. A stream failing to write is dropped (and its error logged), so that
+// the others keep receiving the output.
+type multiWriteCloser struct {
+	logger  *zap.Logger
+	names   []string
+	streams []io.WriteCloser
+	failed  []bool
+}
+
+// newMultiWriteCloser returns an empty multiWriteCloser, logging the write errors into logger with the stream name.
+func newMultiWriteCloser(logger *zap.Logger, stream string, size int) *multiWriteCloser {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	return &multiWriteCloser{
+		logger:  logger.With(zap.String("stream", stream)),
+		names:   make([]string, 0, size),
+		streams: make([]io.WriteCloser, 0, size),
+		failed:  make([]bool, 0, size),
+	}
+}
+
+// add appends a stream, identified by name (the sink path) in the logs.
+func (m *multiWriteCloser) add(stream io.WriteCloser, name string) {
+	m.names = append(m.names, name)
+	m.streams = append(m.streams, stream)
+	m.failed = append(m.failed, false)
+}
+
+// Write writes into every stream that did not fail yet. It never fails.
+func (m *multiWriteCloser) Write(p []byte) (int, error) {
+	for i, stream := range m.streams {
+		if m.failed[i] {
+			continue
+		}
+
+		if _, err := stream.Write(p); err != nil {
+			m.failed[i] = true
+			m.logger.Warn("output dropped", zap.String("sink", m.names[i]), zap.Error(err))
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close closes every stream, and returns the first error.
+func (m *multiWriteCloser) Close() error {
+	var first error
+
+	for _, stream := range m.streams {
+		if err := stream.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,192 @@
+package subprocess_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	logobserver "go.uber.org/zap/zaptest/observer"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSinksDecode(t *testing.T) {
+	t.Parallel()
+
+	type want struct {
+		err   string
+		sinks subprocess.Sinks
+	}
+
+	testTable := map[string]struct {
+		json string
+		yaml string
+		want
+	}{
+		"Stream name": {
+			json: `"out.log"`,
+			yaml: `out.log`,
+			want: want{sinks: subprocess.To("out.log")},
+		},
+		"List": {
+			json: `["stdout", {"path": "out.log", "prefix": "[daemon-2] ", "timestamps": true}]`,
+			yaml: "- stdout\n- {path: out.log, prefix: '[daemon-2] ', timestamps: true}",
+			want: want{sinks: subprocess.Sinks{
+				{Path: "stdout"},
+				{Path: "out.log", Prefix: "[daemon-2] ", Timestamps: true},
+			}},
+		},
+		"Log sink": {
+			json: `{"path": "log", "level": "warn", "maxLine": 1024}`,
+			yaml: `{path: log, level: warn, maxLine: 1024}`,
+			want: want{sinks: subprocess.Sinks{{Path: "log", Level: zapcore.WarnLevel, MaxLine: 1024}}},
+		},
+		"Invalid": {
+			json: `[["stdout"]]`,
+			yaml: `[[stdout]]`,
+			want: want{err: "invalid sink"},
+		},
+	}
+
+	for name, unit := range testTable {
+		unit := unit
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var fromJSON, fromYAML subprocess.Sinks
+
+			errJSON := json.Unmarshal([]byte(unit.json), &fromJSON)
+			errYAML := yaml.Unmarshal([]byte(unit.yaml), &fromYAML)
+
+			if unit.want.err != "" {
+				assert.ErrorContains(t, errJSON, unit.want.err)
+				assert.ErrorContains(t, errYAML, unit.want.err)
+
+				return
+			}
+
+			require.NoError(t, errJSON)
+			require.NoError(t, errYAML)
+			assert.Equal(t, unit.want.sinks, fromJSON)
+			assert.Equal(t, unit.want.sinks, fromYAML)
+
+			// Plain sinks are encoded back as stream names, and single sinks on their own.
+			data, err := json.Marshal(fromJSON)
+			require.NoError(t, err)
+			assert.JSONEq(t, unit.json, string(data))
+		})
+	}
+}
+
+func TestSinksOpen(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	plain, prefixed := filepath.Join(dir, "plain.log"), filepath.Join(dir, "prefixed.log")
+
+	stream, err := subprocess.Sinks{
+		{Path: plain},
+		{Path: prefixed, Prefix: "[daemon-2] ", Timestamps: true},
+	}.Open("stdout")
+	require.NoError(t, err)
+
+	for _, chunk := range []string{"first ", "line\nsecond line\nthird", " line"} {
+		_, err := stream.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+
+	// Only whole lines are written, until the stream is closed.
+	data, err := os.ReadFile(prefixed)
+	require.NoError(t, err)
+	assert.Regexp(t, `^\S+ \[daemon-2\] first line\n\S+ \[daemon-2\] second line\n$`, string(data))
+
+	require.NoError(t, stream.Close())
+
+	data, err = os.ReadFile(plain)
+	require.NoError(t, err)
+	assert.Equal(t, "first line\nsecond line\nthird line", string(data))
+
+	data, err = os.ReadFile(prefixed)
+	require.NoError(t, err)
+
+	lines := regexp.MustCompile(`(?m)^(\S+) \[daemon-2\] (.*)$`).FindAllStringSubmatch(string(data), -1)
+	require.Len(t, lines, 3)
+	assert.Equal(t, "third line", lines[2][2])
+	assert.Regexp(t, `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}`, lines[0][1])
+}
+
+func TestLogSink(t *testing.T) {
+	t.Parallel()
+
+	core, logs := logobserver.New(zapcore.DebugLevel)
+	proc := &subprocess.Proc{
+		Args:    []string{"-c", `printf 'hello\nsplit me\nunterminated'; echo oops >&2`},
+		Command: "/bin/sh",
+		Logger:  zap.New(core).With(zap.String("task", "greeter")),
+		Stderr:  subprocess.Sinks{{Path: "log", Level: zapcore.WarnLevel}},
+		Stdout:  subprocess.Sinks{{Path: "log", MaxLine: 5}},
+	}
+
+	require.NoError(t, proc.Run(context.TODO()))
+
+	pid := int64(proc.ProcessState().Pid())
+	byStream := make(map[string][]string)
+
+	for _, entry := range logs.AllUntimed() {
+		fields := entry.ContextMap()
+		assert.Equal(t, "greeter", fields["task"])
+		assert.Equal(t, pid, fields["pid"])
+
+		stream, _ := fields["stream"].(string)
+		if stream == "stderr" {
+			assert.Equal(t, zapcore.WarnLevel, entry.Level)
+		}
+
+		if fields["partial"] == true {
+			entry.Message += "…"
+		}
+
+		byStream[stream] = append(byStream[stream], entry.Message)
+	}
+
+	assert.Equal(t, map[string][]string{
+		"stderr": {"oops"},
+		"stdout": {"hello", "split…", " me", "unter…", "minat…", "ed…"},
+	}, byStream)
+}
+
+func TestFailingSink(t *testing.T) {
+	t.Parallel()
+
+	// Writing into /dev/full fails: the other sink keeps receiving the output.
+	file := filepath.Join(t.TempDir(), "out.log")
+	core, logs := logobserver.New(zapcore.DebugLevel)
+	proc := &subprocess.Proc{
+		Args:    []string{"-c", "echo first; sleep 0.1; echo second"},
+		Command: "/bin/sh",
+		Logger:  zap.New(core),
+		Stdout:  subprocess.To("/dev/full", file),
+	}
+
+	require.NoError(t, proc.Run(context.TODO()))
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(data))
+
+	dropped := logs.FilterMessage("output dropped").AllUntimed()
+	require.Len(t, dropped, 1)
+	assert.Equal(t, map[string]interface{}{
+		"error":  "write /dev/full: no space left on device",
+		"sink":   "/dev/full",
+		"stream": "stdout",
+	}, dropped[0].ContextMap())
+}
@@ -10,9 +10,9 @@ import (
 const (
 	// devnul is a string alias to `/dev/null`.
 	devnul = "devnul"
-	// mode is how files are open.
+	// mode is how WriteCloser opens files (see Sink.Mode for the sinks).
 	mode = os.O_APPEND | os.O_CREATE | os.O_RDWR // nolint:nosnakecase // go package
-	// perm is new files' permissions.
+	// perm is the default permission of new files (see Sink.Perm).
 	perm = 0o644
 	// stderr is a string alias to `/dev/stderr`.
 	stderr = "stderr"
@@ -42,6 +42,30 @@ func (s writeCloser) Close() error {
 	return s.c.Close()
 }
 
+// handOver returns the writer of a process stream, and whether it goes through a pipe: the file of the stream itself
+// when there is no copy to write (nil for devnul, see exec.Cmd.Stdout), or a writer into the stream and every copy.
+func handOver(stream io.Writer, copies ...io.Writer) (io.Writer, bool) {
+	writers := []io.Writer{stream}
+
+	for _, copied := range copies {
+		if copied != nil {
+			writers = append(writers, copied)
+		}
+	}
+
+	if s, ok := stream.(writeCloser); ok && len(writers) == 1 {
+		if file, ok := s.w.(*os.File); ok {
+			return file, false
+		}
+
+		if s.w == io.Discard {
+			return nil, false
+		}
+	}
+
+	return io.MultiWriter(writers...), true
+}
+
 // WriteCloser returns a new open stream.
 func WriteCloser(args ...string) (io.WriteCloser, error) {
 	for _, stream := range args {
@@ -66,3 +90,25 @@ func WriteCloser(args ...string) (io.WriteCloser, error) {
 
 	return nil, errors.New("missing stream name")
 }
+
+// Target describes where a stream writes, choosing among args like WriteCloser (without opening anything).
+func Target(args ...string) string {
+	for _, stream := range args {
+		switch stream {
+		case "":
+			continue
+		case devnul:
+			return "discarded"
+		case logSink:
+			return "structured log"
+		case stderr:
+			return "standard error"
+		case stdout:
+			return "standard output"
+		default:
+			return stream + " (append)"
+		}
+	}
+
+	return ""
+}
@@ -4,27 +4,65 @@ package subprocess
 import (
 	"context"
 	"io"
+	"os"
 	"os/exec"
+	"sync"
+	"syscall"
+	"time"
 
+	"bitbucket.org/lucacontini/z6/pipeline/event"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 )
 
 // Proc represents an OS command.
+//
+// A stream writing into a single file (or standard stream) is handed over to the process as it is. The others go
+// through a pipe (eg: with several sinks, a prefix, rotation, the log sink, Capture or Output): Run then waits until
+// every process writing into it exits, including the ones started in the background (eg: `sh -c "daemon &"`).
+//
+// On Unix, the process runs in its own process group: signals sent by the terminal (eg: Ctrl-C) only reach the
+// pipeline, which stops the group once the context is done (see StopTimeout).
 type Proc struct {
 	Args    []string
 	Command string
-	Stderr  string
-	Stdout  string
+	Stderr  Sinks
+	Stdout  Sinks
+
+	// Env, if set, is the environment of the process (see exec.Cmd.Env).
+	Env []string
+	// Name identifies the process in the Observer events (the command by default).
+	Name string
+	// Logger, if set, receives the output written into the log sinks (see Sink).
+	Logger *zap.Logger
+	// Observer, if set, is notified when the process starts and exits.
+	Observer event.Observer
+	// Capture, if set, receives a copy of the standard output.
+	Capture io.Writer
+	// Output, if set, receives a copy of both the standard output and error (it must be safe for concurrent use).
+	Output io.Writer
+	// StopTimeout, if positive, is how long the process group can take to exit on SIGTERM once the context is done,
+	// before being killed (it is killed right away otherwise).
+	StopTimeout time.Duration
+
+	mtx     sync.Mutex
+	pid     int
+	lastPID int
+	state   *os.ProcessState
+	tail    *tailWriter
 }
 
+// ErrNotRunning is returned when signalling a process that is not running.
+var ErrNotRunning = errors.New("process not running")
+
 // OpenStreams prepares the standard output and error streams.
 func (p *Proc) OpenStreams() (io.WriteCloser, io.WriteCloser, error) {
-	stderr, err := WriteCloser(p.Stderr, stderr)
+	stderr, err := p.Stderr.open(stderr, p.Logger, p.startedPID)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "cannot open stderr")
 	}
 
-	stdout, err := WriteCloser(p.Stdout, stdout)
+	stdout, err := p.Stdout.open(stdout, p.Logger, p.startedPID)
 	if err == nil {
 		return stderr, stdout, nil
 	}
@@ -34,8 +72,46 @@ func (p *Proc) OpenStreams() (io.WriteCloser, io.WriteCloser, error) {
 	return nil, nil, errors.Wrap(err, "cannot open stdout")
 }
 
+// PID returns the ID of the running process, or 0.
+func (p *Proc) PID() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.pid
+}
+
+// Signal sends a signal to the process group of the running process.
+func (p *Proc) Signal(sig syscall.Signal) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.pid == 0 {
+		return ErrNotRunning
+	}
+
+	return errors.Wrap(signalGroup(p.pid, sig), "cannot signal")
+}
+
+// ProcessState returns the exit state of the last run, or nil if the process did not start.
+func (p *Proc) ProcessState() *os.ProcessState {
+	return p.state
+}
+
+// StderrTail returns the last bytes written to the standard error by the last run, when it went through a pipe (see
+// Proc).
+func (p *Proc) StderrTail() []byte {
+	if p.tail == nil {
+		return nil
+	}
+
+	return p.tail.Bytes()
+}
+
 // Run executes the OS command.
 func (p *Proc) Run(ctx context.Context) error {
+	// A run that does not start reports no exit state, rather than the one of the previous run.
+	p.state, p.tail = nil, nil
+
 	stderr, stdout, err := p.OpenStreams()
 	if err != nil {
 		return errors.Wrap(err, "stream error")
@@ -44,20 +120,121 @@ func (p *Proc) Run(ctx context.Context) error {
 	defer stderr.Close()
 	defer stdout.Close()
 
+	if err := ctx.Err(); err != nil {
+		return err // nolint:wrapcheck // not relevant
+	}
+
 	// nolint: gosec // ok
-	cmd := exec.CommandContext(ctx, p.Command, p.Args...)
-	cmd.Stderr = stderr
-	cmd.Stdout = stdout
+	cmd := exec.Command(p.Command, p.Args...)
+	cmd.Env = p.Env
 
-	err = cmd.Run()
-	if err == nil {
-		return nil
+	setGroup(cmd)
+
+	var piped bool
+
+	cmd.Stdout, _ = handOver(stdout, p.Output, p.Capture)
+	cmd.Stderr, piped = handOver(stderr, p.Output)
+
+	// The tail of the standard error is only retained when it goes through a pipe anyway.
+	if piped {
+		p.tail = newTailWriter(tailSize)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, p.tail)
 	}
 
+	err = p.run(ctx, cmd)
+	p.state = cmd.ProcessState
+
 	select {
 	case <-ctx.Done():
+		// Stopped, even when the process exits cleanly on SIGTERM.
 		return ctx.Err() // nolint:wrapcheck // not relevant
 	default:
 		return err // nolint:wrapcheck // not relevant
 	}
 }
+
+// run starts the command, waits for it and notifies the observer. The process group is stopped when the context is
+// done.
+func (p *Proc) run(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err // nolint:wrapcheck // not relevant
+	}
+
+	started := time.Now()
+
+	p.setPID(cmd.Process.Pid)
+
+	if p.Observer != nil {
+		p.Observer.ProcessSpawned(p.name(), cmd.Process.Pid)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.stop(cmd.Process.Pid, done)
+		case <-done:
+		}
+	}()
+
+	err := cmd.Wait()
+
+	p.setPID(0)
+	close(done)
+
+	elapsed := time.Since(started)
+
+	if p.Observer != nil {
+		p.Observer.ProcessExited(p.name(), cmd.ProcessState, elapsed)
+	}
+
+	return err // nolint:wrapcheck // not relevant
+}
+
+// stop terminates the process group with SIGTERM, then SIGKILL after StopTimeout; done is closed once the process
+// exits.
+func (p *Proc) stop(pid int, done <-chan struct{}) {
+	// Without SIGTERM (eg: on Windows), the process is killed right away.
+	if p.StopTimeout > 0 && signalGroup(pid, syscall.SIGTERM) == nil {
+		timer := time.NewTimer(p.StopTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+	}
+
+	_ = signalGroup(pid, syscall.SIGKILL)
+}
+
+// setPID records the ID of the running process.
+func (p *Proc) setPID(pid int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.pid = pid
+
+	if pid != 0 {
+		p.lastPID = pid
+	}
+}
+
+// startedPID returns the ID of the last process started, even if it exited (eg: for its last output line).
+func (p *Proc) startedPID() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.lastPID
+}
+
+// name returns the process name in the events.
+func (p *Proc) name() string {
+	if p.Name == "" {
+		return p.Command
+	}
+
+	return p.Name
+}
@@ -2,8 +2,14 @@ package subprocess_test
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"bitbucket.org/lucacontini/z6/pipeline/event"
 	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -35,8 +41,8 @@ func TestOpenStreams(t *testing.T) {
 		"With /dev/null": {
 			fields: fields{
 				process: &subprocess.Proc{
-					Stderr: "devnul",
-					Stdout: "devnul",
+					Stderr: subprocess.To("devnul"),
+					Stdout: subprocess.To("devnul"),
 				},
 			},
 			want: want{},
@@ -44,7 +50,7 @@ func TestOpenStreams(t *testing.T) {
 		"With invalid standard error": {
 			fields: fields{
 				process: &subprocess.Proc{
-					Stderr: "/",
+					Stderr: subprocess.To("/"),
 				},
 			},
 			want: want{
@@ -54,7 +60,7 @@ func TestOpenStreams(t *testing.T) {
 		"With invalid standard output": {
 			fields: fields{
 				process: &subprocess.Proc{
-					Stdout: "/tmp/",
+					Stdout: subprocess.To("/tmp/"),
 				},
 			},
 			want: want{
@@ -148,7 +154,7 @@ func TestRun(t *testing.T) {
 		"With invalid stream": {
 			fields: fields{
 				process: func() *subprocess.Proc {
-					return &subprocess.Proc{Command: "true", Stderr: "/not/a/file"}
+					return &subprocess.Proc{Command: "true", Stderr: subprocess.To("/not/a/file")}
 				},
 			},
 			want: want{
@@ -173,3 +179,176 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+func TestStderrTail(t *testing.T) {
+	t.Parallel()
+
+	// The tail is only retained when the standard error goes through a pipe, eg: into the log sink.
+	proc := &subprocess.Proc{Command: "/bin/sh", Stderr: subprocess.To("log")}
+	assert.Nil(t, proc.StderrTail())
+
+	proc.Args = []string{"-c", "echo first >&2; exit 1"}
+	assert.EqualError(t, proc.Run(context.TODO()), "exit status 1")
+	assert.Equal(t, "first\n", string(proc.StderrTail()))
+
+	// The tail is reset by every run, and capped.
+	proc.Args = []string{"-c", "head -c 5000 /dev/zero | tr '\\0' a >&2; printf END >&2"}
+	require.NoError(t, proc.Run(context.TODO()))
+
+	tail := proc.StderrTail()
+	assert.Len(t, tail, 4096)
+	assert.Equal(t, "aaaEND", string(tail[len(tail)-6:]))
+}
+
+func TestBackgroundChild(t *testing.T) {
+	t.Parallel()
+
+	// The background process inherits the streams, which are files rather than pipes: Run does not wait for it.
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	proc := &subprocess.Proc{
+		Args:    []string{"-c", "sleep 30 & echo $!"},
+		Command: "/bin/sh",
+		Stderr:  subprocess.To("devnul"),
+		Stdout:  subprocess.To(pidFile),
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- proc.Run(context.TODO()) }()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "still waiting for the background process")
+	}
+
+	data, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	require.NoError(t, err)
+
+	child, err := os.FindProcess(pid)
+	require.NoError(t, err)
+	assert.NoError(t, child.Kill())
+}
+
+func TestProcessStateReset(t *testing.T) {
+	t.Parallel()
+
+	proc := &subprocess.Proc{Command: "/bin/sh", Stderr: subprocess.To("devnul")}
+	proc.Args = []string{"-c", "echo oops >&2; exit 3"}
+	assert.EqualError(t, proc.Run(context.TODO()), "exit status 3")
+	require.NotNil(t, proc.ProcessState())
+
+	// The next attempt fails before starting the process.
+	proc.Stdout = subprocess.To(filepath.Join(t.TempDir(), "missing", "out.log"))
+	assert.ErrorContains(t, proc.Run(context.TODO()), "stream error")
+	assert.Nil(t, proc.ProcessState())
+	assert.Nil(t, proc.StderrTail())
+}
+
+// observer records the process events.
+type observer struct {
+	event.Nop
+	events []string
+}
+
+func (o *observer) ProcessSpawned(node string, _ int) {
+	o.events = append(o.events, "spawned "+node)
+}
+
+func (o *observer) ProcessExited(node string, state *os.ProcessState, _ time.Duration) {
+	o.events = append(o.events, "exited "+node+" "+state.String())
+}
+
+func TestObserver(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		name string
+		want []string
+	}{
+		"named":   {name: "root/exit-3", want: []string{"spawned root/exit-3", "exited root/exit-3 exit status 3"}},
+		"unnamed": {name: "", want: []string{"spawned /bin/sh", "exited /bin/sh exit status 3"}},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			obs := &observer{}
+			proc := &subprocess.Proc{Args: []string{"-c", "exit 3"}, Command: "/bin/sh", Name: tt.name, Observer: obs}
+
+			assert.EqualError(t, proc.Run(context.TODO()), "exit status 3")
+			assert.Equal(t, tt.want, obs.events)
+		})
+	}
+}
+
+func TestProcessGroup(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+
+	// The grandchild keeps the standard error open: Run only returns once it is killed too.
+	proc := &subprocess.Proc{Args: []string{"-c", "sleep 10; true"}, Command: "/bin/sh"}
+	started := time.Now()
+
+	assert.ErrorIs(t, proc.Run(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(started), 5*time.Second)
+}
+
+func TestStopTimeout(t *testing.T) {
+	t.Parallel()
+
+	const (
+		graceful = "trap 'echo stopped > $0; exit 0' TERM; sleep 10 & wait"
+		stubborn = "trap '' TERM; sleep 10 & wait; echo stopped > $0"
+	)
+
+	tests := map[string]struct {
+		script  string
+		timeout time.Duration
+		stopped bool
+	}{
+		// SIGKILL right away, by default.
+		"default":  {script: graceful},
+		"negative": {script: graceful, timeout: -1},
+		// SIGTERM first with a timeout, then SIGKILL once it expires.
+		"with term": {script: graceful, timeout: 5 * time.Second, stopped: true},
+		"with kill": {script: stubborn, timeout: 100 * time.Millisecond},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			file := filepath.Join(t.TempDir(), "stopped")
+
+			ctx, cancel := context.WithTimeout(context.TODO(), 200*time.Millisecond)
+			defer cancel()
+
+			proc := &subprocess.Proc{
+				Args:        []string{"-c", tt.script, file},
+				Command:     "/bin/sh",
+				StopTimeout: tt.timeout,
+			}
+			started := time.Now()
+
+			assert.ErrorIs(t, proc.Run(ctx), context.DeadlineExceeded)
+			assert.Less(t, time.Since(started), 5*time.Second)
+
+			if tt.stopped {
+				assert.FileExists(t, file)
+			} else {
+				assert.NoFileExists(t, file)
+			}
+		})
+	}
+}
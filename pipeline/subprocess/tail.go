@@ -0,0 +1,46 @@
+package subprocess
+
+import "sync"
+
+// tailSize is the number of bytes retained by tailWriter.
+const tailSize = 4096
+
+// tailWriter retains the last bytes written into it.
+type tailWriter struct {
+	mtx  sync.Mutex
+	buf  []byte
+	size int
+}
+
+// newTailWriter returns a writer that keeps the last size bytes.
+func newTailWriter(size int) *tailWriter {
+	return &tailWriter{mtx: sync.Mutex{}, buf: make([]byte, 0, size), size: size}
+}
+
+// Write implements io.Writer.
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if len(p) >= t.size {
+		t.buf = append(t.buf[:0], p[len(p)-t.size:]...)
+
+		return len(p), nil
+	}
+
+	if overflow := len(t.buf) + len(p) - t.size; overflow > 0 {
+		t.buf = append(t.buf[:0], t.buf[overflow:]...)
+	}
+
+	t.buf = append(t.buf, p...)
+
+	return len(p), nil
+}
+
+// Bytes returns a copy of the retained bytes.
+func (t *tailWriter) Bytes() []byte {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return append([]byte(nil), t.buf...)
+}
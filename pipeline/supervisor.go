@@ -0,0 +1,368 @@
+package pipeline
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/event"
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+	"github.com/pkg/errors"
+)
+
+// Live statuses, as reported by Supervisor (along with the final ones).
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusStopped Status = "stopped"
+)
+
+var (
+	// ErrAmbiguousNode is returned when a name matches several nodes.
+	ErrAmbiguousNode = errors.New("ambiguous node name, use its path")
+	// ErrNotControllable is returned when acting on a node that is not a running command or plugin.
+	ErrNotControllable = errors.New("node not controllable")
+	// ErrUnknownNode is returned when a name matches no node.
+	ErrUnknownNode = errors.New("unknown node")
+)
+
+type (
+	// Supervisor tracks the live state of a pipeline and controls its commands and plugins while it runs. It is
+	// attached to the tree with WithSupervisor.
+	Supervisor struct {
+		mtx   sync.Mutex
+		nodes map[string]*supervised
+		root  *supervised
+	}
+
+	// NodeState is the live state of a node.
+	NodeState struct {
+		Children []*NodeState `json:"children,omitempty"`
+		Error    string       `json:"error,omitempty"`
+		ExitCode *int         `json:"exitCode,omitempty"`
+		Kind     string       `json:"kind"`
+		Name     string       `json:"name"`
+		NextRun  *time.Time   `json:"nextRun,omitempty"`
+		Path     string       `json:"path"`
+		Paused   bool         `json:"paused,omitempty"`
+		PID      int          `json:"pid,omitempty"`
+		Restarts int          `json:"restarts"`
+		Since    time.Time    `json:"since,omitempty"`
+		Status   Status       `json:"status"`
+	}
+
+	// ControlConfig describes the control API of a pipeline (only relevant for the root node).
+	ControlConfig struct {
+		// Socket is the path of the Unix socket served while the pipeline runs, and used by `pipeline ctl`.
+		Socket string `json:"socket,omitempty" toml:"socket,omitempty" yaml:"socket,omitempty"`
+	}
+
+	// supervised is the state of a node, along with its controls.
+	supervised struct {
+		children []*supervised
+		control  *loop.Control
+		logs     *logBuffer
+		proc     *subprocess.Proc
+		state    NodeState
+	}
+)
+
+// NewSupervisor returns a supervisor for the node tree (nodes are identified by their Path).
+func NewSupervisor(root *Node) *Supervisor {
+	inst := &Supervisor{mtx: sync.Mutex{}, nodes: make(map[string]*supervised), root: nil}
+	inst.root = inst.add(root, root.ID())
+
+	return inst
+}
+
+// add registers a node and its children.
+func (s *Supervisor) add(node *Node, path string) *supervised {
+	entry := &supervised{ // nolint:exhaustruct // set on start
+		state: NodeState{Kind: node.Kind(), Name: node.ID(), Path: path, Status: StatusPending}, // nolint:exhaustruct // ditto
+	}
+
+	if node.IsCommand() {
+		entry.logs = newLogBuffer()
+	}
+
+	children := node.Children()
+	for i := range children {
+		entry.children = append(entry.children, s.add(&children[i], node.ChildPath(path, i)))
+	}
+
+	s.nodes[path] = entry
+
+	return entry
+}
+
+// Tree returns a snapshot of the whole tree.
+func (s *Supervisor) Tree() *NodeState {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.root.snapshot()
+}
+
+// Node returns a snapshot of a node, identified by its path or by a unique name.
+func (s *Supervisor) Node(name string) (*NodeState, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	entry, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.snapshot(), nil
+}
+
+// Pause prevents a node from being restarted, until Resume.
+func (s *Supervisor) Pause(name string) error {
+	return s.control(name, (*loop.Control).Pause)
+}
+
+// Resume undoes Pause.
+func (s *Supervisor) Resume(name string) error {
+	return s.control(name, (*loop.Control).Resume)
+}
+
+// Restart stops a node and starts it again, regardless of its exit policy.
+func (s *Supervisor) Restart(name string) error {
+	return s.control(name, (*loop.Control).Restart)
+}
+
+// Start starts a stopped node.
+func (s *Supervisor) Start(name string) error {
+	return s.control(name, (*loop.Control).Start)
+}
+
+// Stop stops a node (killing its process) until Start; its parent keeps waiting for it.
+func (s *Supervisor) Stop(name string) error {
+	return s.control(name, (*loop.Control).Stop)
+}
+
+// Signal sends a signal to the process group of a running command.
+func (s *Supervisor) Signal(name string, sig syscall.Signal) error {
+	s.mtx.Lock()
+	entry, err := s.lookup(name)
+	s.mtx.Unlock()
+
+	switch {
+	case err != nil:
+		return err
+	case entry.proc == nil:
+		return errors.Wrap(ErrNotControllable, name)
+	}
+
+	return errors.Wrap(entry.proc.Signal(sig), name)
+}
+
+// Logs returns the last output (standard output and error) of a command, along with a channel receiving the next
+// output until stop is called.
+func (s *Supervisor) Logs(name string) (history []byte, next <-chan []byte, stop func(), err error) {
+	s.mtx.Lock()
+	entry, err := s.lookup(name)
+	s.mtx.Unlock()
+
+	switch {
+	case err != nil:
+		return nil, nil, nil, err
+	case entry.logs == nil:
+		return nil, nil, nil, errors.Wrap(ErrNotControllable, name)
+	}
+
+	history, next, stop = entry.logs.follow()
+
+	return history, next, stop, nil
+}
+
+// control applies an action to the loop of a node.
+func (s *Supervisor) control(name string, action func(*loop.Control)) error {
+	s.mtx.Lock()
+	entry, err := s.lookup(name)
+	s.mtx.Unlock()
+
+	switch {
+	case err != nil:
+		return err
+	case entry.control == nil:
+		return errors.Wrap(ErrNotControllable, name)
+	}
+
+	action(entry.control)
+
+	return nil
+}
+
+// lookup finds a node by path, by path suffix or by name (with the lock held), like the node selection: siblings with
+// the same name are ambiguous.
+func (s *Supervisor) lookup(name string) (*supervised, error) {
+	if entry, ok := s.nodes[name]; ok {
+		return entry, nil
+	}
+
+	var found *supervised
+
+	for path, entry := range s.nodes {
+		if entry.state.Name != name && !strings.HasSuffix(path, "/"+name) {
+			continue
+		}
+
+		if found != nil {
+			return nil, errors.Wrap(ErrAmbiguousNode, name)
+		}
+
+		found = entry
+	}
+
+	if found == nil {
+		return nil, errors.Wrap(ErrUnknownNode, name)
+	}
+
+	return found, nil
+}
+
+// attach registers the control and the process of a command (or plugin) node, captures the process output and
+// returns the control.
+func (s *Supervisor) attach(path string, proc *subprocess.Proc) *loop.Control {
+	if s == nil {
+		return nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	entry, ok := s.nodes[path]
+	if !ok {
+		return nil
+	}
+
+	entry.control = loop.NewControl()
+	entry.proc = proc
+
+	if proc != nil && entry.logs != nil {
+		proc.Output = entry.logs
+	}
+
+	return entry.control
+}
+
+// scheduled records the next run of a scheduled node (none for the zero time).
+func (s *Supervisor) scheduled(path string, next time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.update(path, func(state *NodeState) {
+		state.NextRun = nil
+
+		if !next.IsZero() {
+			state.NextRun = &next
+		}
+	})
+}
+
+// update changes the state of a node, if known.
+func (s *Supervisor) update(path string, change func(state *NodeState)) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if entry, ok := s.nodes[path]; ok {
+		change(&entry.state)
+	}
+}
+
+// NodeStarted implements event.Observer.
+func (s *Supervisor) NodeStarted(node string) {
+	s.update(node, func(state *NodeState) {
+		state.Status, state.Since = StatusRunning, time.Now()
+	})
+}
+
+// NodeFinished implements event.Observer.
+func (s *Supervisor) NodeFinished(node string, err error) {
+	s.update(node, func(state *NodeState) {
+		state.Status, state.Since, state.Error = StatusSuccess, time.Now(), ""
+
+		if err != nil {
+			state.Status, state.Error = StatusFailed, err.Error()
+		}
+	})
+}
+
+// ProcessSpawned implements event.Observer.
+func (s *Supervisor) ProcessSpawned(node string, pid int) {
+	s.update(node, func(state *NodeState) {
+		state.PID = pid
+	})
+}
+
+// ProcessExited implements event.Observer.
+func (s *Supervisor) ProcessExited(node string, ps *os.ProcessState, _ time.Duration) {
+	s.update(node, func(state *NodeState) {
+		state.PID, state.ExitCode = 0, nil
+
+		if ps != nil {
+			code := ps.ExitCode()
+			state.ExitCode = &code
+		}
+	})
+}
+
+// Restarting implements event.Observer.
+func (s *Supervisor) Restarting(node string, restarts int) {
+	s.update(node, func(state *NodeState) {
+		state.Restarts = restarts
+	})
+}
+
+// Skipped implements event.Observer.
+func (s *Supervisor) Skipped(node string) {
+	s.update(node, func(state *NodeState) {
+		state.Status = StatusSkipped
+	})
+}
+
+// snapshot copies the state of the node and its children (with the supervisor lock held).
+func (e *supervised) snapshot() *NodeState {
+	state := e.state
+	state.Children = nil
+
+	if ctl := e.control.State(); ctl.Stopped && state.Status == StatusRunning {
+		state.Status = StatusStopped
+	} else {
+		state.Paused = ctl.Paused
+	}
+
+	for _, child := range e.children {
+		state.Children = append(state.Children, child.snapshot())
+	}
+
+	return &state
+}
+
+// WithSupervisor attaches the supervisor to the tree, as an observer (along with any other) and as the controller of
+// its commands and plugins.
+func WithSupervisor(supervisor *Supervisor) Option {
+	return func(n *Node) {
+		n.WithSupervisor(supervisor)
+	}
+}
+
+// WithSupervisor attaches the supervisor to the node and, when it runs, to its children.
+func (n *Node) WithSupervisor(supervisor *Supervisor) *Node {
+	n.supervisor = supervisor
+
+	switch {
+	case supervisor == nil:
+	case n.observer == nil:
+		n.observer = supervisor
+	default:
+		n.observer = event.Multi(n.observer, supervisor)
+	}
+
+	return n
+}
@@ -0,0 +1,120 @@
+package pipeline_test
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupervisor(t *testing.T) {
+	t.Parallel()
+
+	node, err := pipeline.Parallel(
+		pipeline.Command("sleep", "10").Name("daemon-1").OnExit(loop.ExitPolicyRestart),
+		pipeline.Serial(pipeline.Command("sleep", "10").Name("daemon-2")).Name("group"),
+	).Name("root").Log(pipeline.LogConfig{Disabled: true}).Build()
+	require.NoError(t, err)
+
+	supervisor := pipeline.NewSupervisor(node)
+	node.WithSupervisor(supervisor)
+
+	tree := supervisor.Tree()
+	assert.Equal(t, pipeline.StatusPending, tree.Status)
+	require.Len(t, tree.Children, 2)
+	assert.Equal(t, "root/group/daemon-2", tree.Children[1].Children[0].Path)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan error)
+
+	go func() { done <- node.Run(ctx) }()
+
+	pid := func(name string) int {
+		state, err := supervisor.Node(name)
+		require.NoError(t, err)
+
+		return state.PID
+	}
+
+	require.Eventually(t, func() bool { return pid("daemon-1") != 0 && pid("daemon-2") != 0 }, time.Second, time.Millisecond)
+
+	first := pid("root/daemon-1")
+
+	// A signal kills the process, restarted by its exit policy.
+	require.NoError(t, supervisor.Signal("daemon-1", syscall.SIGTERM))
+	require.Eventually(t, func() bool { return pid("daemon-1") != 0 && pid("daemon-1") != first }, time.Second, time.Millisecond)
+
+	state, err := supervisor.Node("daemon-1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, state.Restarts)
+	assert.Equal(t, -1, *state.ExitCode)
+
+	// Stopped nodes keep their parents waiting.
+	require.NoError(t, supervisor.Stop("daemon-2"))
+	require.Eventually(t, func() bool { return pid("daemon-2") == 0 }, time.Second, time.Millisecond)
+
+	state, err = supervisor.Node("daemon-2")
+	require.NoError(t, err)
+	assert.Equal(t, pipeline.StatusStopped, state.Status)
+
+	require.NoError(t, supervisor.Start("daemon-2"))
+	require.Eventually(t, func() bool { return pid("daemon-2") != 0 }, time.Second, time.Millisecond)
+
+	assert.ErrorIs(t, supervisor.Restart("group"), pipeline.ErrNotControllable)
+	assert.ErrorIs(t, supervisor.Pause("nope"), pipeline.ErrUnknownNode)
+
+	cancel()
+	<-done
+
+	assert.Zero(t, pid("daemon-1"))
+	assert.Zero(t, pid("daemon-2"))
+}
+
+func TestSupervisorLookup(t *testing.T) {
+	t.Parallel()
+
+	node := pipeline.Serial(
+		pipeline.Serial(pipeline.Command("true").Name("job")).Name("a"),
+		pipeline.Serial(pipeline.Command("true").Name("job")).Name("b"),
+		pipeline.Parallel(pipeline.Command("true"), pipeline.Command("true")).Name("c"),
+	).Name("root").Node()
+
+	supervisor := pipeline.NewSupervisor(&node)
+
+	tests := map[string]struct {
+		name string
+		path string
+		err  error
+	}{
+		"path":      {name: "root/a/job", path: "root/a/job"},
+		"suffix":    {name: "b/job", path: "root/b/job"},
+		"name":      {name: "a", path: "root/a"},
+		"ambiguous": {name: "job", err: pipeline.ErrAmbiguousNode},
+		"sibling":   {name: "c/true[1]", path: "root/c/true[1]"},
+		"siblings":  {name: "true", err: pipeline.ErrAmbiguousNode},
+		"unknown":   {name: "d", err: pipeline.ErrUnknownNode},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			state, err := supervisor.Node(tt.name)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.path, state.Path)
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// batchSize is the number of spans sent in a single OTLP request.
+	batchSize = 100
+	// flushInterval is how often the OTLP exporter sends incomplete batches.
+	flushInterval = 5 * time.Second
+	// queueSize is the number of spans waiting to be sent, beyond which they are dropped.
+	queueSize = 10 * batchSize
+	// scopeName is the instrumentation scope of the spans.
+	scopeName = "go-pipeline"
+	// statusCodeError and statusCodeOK are OTLP span status codes.
+	statusCodeError = 2
+	statusCodeOK    = 1
+	// spanKindInternal is the OTLP span kind of all the spans.
+	spanKindInternal = 1
+)
+
+var (
+	// errDropped is returned when spans were dropped because the export queue was full.
+	errDropped = errors.New("spans dropped, the export queue was full")
+	// errExport is returned when the collector rejects spans.
+	errExport = errors.New("export failed")
+)
+
+type (
+	// JSONLines writes a span per line, in the OTLP JSON encoding.
+	JSONLines struct {
+		mtx sync.Mutex
+		w   io.WriteCloser
+		err error
+	}
+
+	// OTLP sends batches of spans to an OTLP/HTTP collector, in the JSON encoding.
+	OTLP struct {
+		client   *http.Client
+		closed   bool
+		closing  sync.RWMutex
+		done     chan struct{}
+		dropped  int
+		endpoint string
+		errs     []error
+		mtx      sync.Mutex
+		queue    chan SpanData
+		service  string
+	}
+
+	otlpValue struct {
+		ArrayValue  *otlpArray `json:"arrayValue,omitempty"`
+		BoolValue   *bool      `json:"boolValue,omitempty"`
+		DoubleValue *float64   `json:"doubleValue,omitempty"`
+		IntValue    string     `json:"intValue,omitempty"`
+		StringValue *string    `json:"stringValue,omitempty"`
+	}
+
+	otlpArray struct {
+		Values []otlpValue `json:"values"`
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpSpan struct {
+		Attributes        []otlpAttribute `json:"attributes"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Kind              int             `json:"kind"`
+		Name              string          `json:"name"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		SpanID            string          `json:"spanId"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		Status            otlpStatus      `json:"status"`
+		TraceID           string          `json:"traceId"`
+	}
+)
+
+// NewJSONLines returns an exporter writing into w, which is closed on Shutdown.
+func NewJSONLines(w io.WriteCloser) *JSONLines {
+	return &JSONLines{mtx: sync.Mutex{}, w: w, err: nil}
+}
+
+// Export writes the span. The first write error is returned by Shutdown.
+func (j *JSONLines) Export(span SpanData) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if j.err != nil {
+		return
+	}
+
+	data, err := json.Marshal(span.otlp())
+	if err == nil {
+		_, err = j.w.Write(append(data, '\n'))
+	}
+
+	j.err = err
+}
+
+// Shutdown closes the writer.
+func (j *JSONLines) Shutdown(_ context.Context) error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if err := j.w.Close(); err != nil && j.err == nil {
+		j.err = err
+	}
+
+	return errors.Wrap(j.err, "trace file")
+}
+
+// NewOTLP returns an exporter posting to endpoint (eg: http://localhost:4318), on behalf of the named service.
+func NewOTLP(endpoint, service string) *OTLP {
+	inst := &OTLP{
+		client:   &http.Client{Timeout: flushInterval}, // nolint:exhaustruct // defaults
+		closed:   false,
+		closing:  sync.RWMutex{},
+		done:     make(chan struct{}),
+		dropped:  0,
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		errs:     nil,
+		mtx:      sync.Mutex{},
+		queue:    make(chan SpanData, queueSize),
+		service:  service,
+	}
+
+	go inst.loop()
+
+	return inst
+}
+
+// Export queues the span, unless the exporter is shut down. It never blocks the caller: when the queue is full (eg:
+// the collector is slow), the span is dropped and counted.
+func (o *OTLP) Export(span SpanData) {
+	o.closing.RLock()
+	defer o.closing.RUnlock()
+
+	if o.closed {
+		return
+	}
+
+	select {
+	case o.queue <- span:
+	default:
+		o.mtx.Lock()
+		o.dropped++
+		o.mtx.Unlock()
+	}
+}
+
+// Shutdown sends the queued spans and returns the first export error, if any, or reports the dropped spans. It can be
+// called several times.
+func (o *OTLP) Shutdown(ctx context.Context) error {
+	o.closing.Lock()
+
+	if !o.closed {
+		o.closed = true
+		close(o.queue)
+	}
+
+	o.closing.Unlock()
+
+	select {
+	case <-o.done:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "otlp")
+	}
+
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	switch {
+	case len(o.errs) > 0:
+		return errors.Wrapf(o.errs[0], "otlp (%d failed requests)", len(o.errs))
+	case o.dropped > 0:
+		return errors.Wrapf(errDropped, "otlp: %d", o.dropped)
+	default:
+		return nil
+	}
+}
+
+// loop batches the queued spans.
+func (o *OTLP) loop() {
+	defer close(o.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+
+	for {
+		select {
+		case span, ok := <-o.queue:
+			if !ok {
+				o.send(batch)
+
+				return
+			}
+
+			if batch = append(batch, span); len(batch) >= batchSize {
+				o.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			o.send(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// send posts a batch of spans.
+func (o *OTLP) send(batch []SpanData) {
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := o.post(batch); err != nil {
+		o.mtx.Lock()
+		o.errs = append(o.errs, err)
+		o.mtx.Unlock()
+	}
+}
+
+// post sends an OTLP/HTTP JSON request.
+func (o *OTLP) post(batch []SpanData) error {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		spans = append(spans, span.otlp())
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": attributes(map[string]interface{}{"service.name": o.service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": scopeName},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot encode spans")
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "cannot create request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := o.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "cannot send spans")
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 { // nolint:gomnd // 2xx
+		return errors.Wrap(errExport, res.Status)
+	}
+
+	return nil
+}
+
+// otlp converts the span to the OTLP JSON encoding.
+func (d SpanData) otlp() otlpSpan {
+	span := otlpSpan{
+		Attributes:        attributes(d.Attributes),
+		EndTimeUnixNano:   strconv.FormatInt(d.End.UnixNano(), 10),
+		Kind:              spanKindInternal,
+		Name:              d.Name,
+		ParentSpanID:      "",
+		SpanID:            d.SpanID.String(),
+		StartTimeUnixNano: strconv.FormatInt(d.Start.UnixNano(), 10),
+		Status:            otlpStatus{Code: statusCodeOK, Message: ""},
+		TraceID:           d.TraceID.String(),
+	}
+
+	if !d.ParentID.IsZero() {
+		span.ParentSpanID = d.ParentID.String()
+	}
+
+	if d.Error != "" {
+		span.Status = otlpStatus{Code: statusCodeError, Message: d.Error}
+	}
+
+	return span
+}
+
+// attributes converts a map into sorted OTLP attributes.
+func attributes(attrs map[string]interface{}) []otlpAttribute {
+	list := make([]otlpAttribute, 0, len(attrs))
+	for key, val := range attrs {
+		list = append(list, otlpAttribute{Key: key, Value: value(val)})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+
+	return list
+}
+
+// value converts a Go value into an OTLP value.
+func value(val interface{}) otlpValue {
+	var res otlpValue
+
+	switch typed := val.(type) {
+	case bool:
+		res.BoolValue = &typed
+	case int:
+		res.IntValue = strconv.Itoa(typed)
+	case float64:
+		res.DoubleValue = &typed
+	case []string:
+		res.ArrayValue = &otlpArray{Values: make([]otlpValue, 0, len(typed))}
+		for _, item := range typed {
+			res.ArrayValue.Values = append(res.ArrayValue.Values, value(item))
+		}
+	case string:
+		res.StringValue = &typed
+	default:
+		str := fmt.Sprint(typed)
+		res.StringValue = &str
+	}
+
+	return res
+}
+
+// multiExporter sends the spans to several exporters.
+type multiExporter []Exporter
+
+// Multi returns an exporter sending the spans to all the given exporters.
+func Multi(exporters ...Exporter) Exporter { // nolint:ireturn // composite
+	return multiExporter(exporters)
+}
+
+// Export sends the span to all the exporters.
+func (m multiExporter) Export(span SpanData) {
+	for _, exporter := range m {
+		exporter.Export(span)
+	}
+}
+
+// Shutdown shuts all the exporters down and returns the first error.
+func (m multiExporter) Shutdown(ctx context.Context) error {
+	var first error
+
+	for _, exporter := range m {
+		if err := exporter.Shutdown(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,216 @@
+// package trace records OpenTelemetry-style spans of a pipeline execution.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvTraceParent is the W3C trace context environment variable, propagated to child processes.
+const EnvTraceParent = "TRACEPARENT"
+
+type (
+	// TraceID identifies a trace.
+	TraceID [16]byte
+
+	// SpanID identifies a span.
+	SpanID [8]byte
+
+	// Exporter receives the finished spans.
+	Exporter interface {
+		Export(span SpanData)
+		Shutdown(ctx context.Context) error
+	}
+
+	// SpanData is a finished span.
+	SpanData struct {
+		Attributes map[string]interface{}
+		End        time.Time
+		Error      string
+		Name       string
+		ParentID   SpanID
+		SpanID     SpanID
+		Start      time.Time
+		TraceID    TraceID
+	}
+
+	// Span is an operation in progress. A nil span is valid and records nothing.
+	Span struct {
+		mtx    sync.Mutex
+		data   SpanData
+		tracer *Tracer
+	}
+
+	// Tracer creates spans. A nil tracer is valid and creates nil spans.
+	Tracer struct {
+		exporter Exporter
+		remote   *SpanData
+	}
+
+	// spanKey is the context key of the current span.
+	spanKey struct{}
+)
+
+// String returns the hex encoding of the ID.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// String returns the hex encoding of the ID.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsZero returns whether the ID is empty.
+func (s SpanID) IsZero() bool {
+	return s == SpanID{}
+}
+
+// New returns a tracer sending spans to the exporter. When traceParent is a valid W3C traceparent (eg: the
+// TRACEPARENT variable of the current process), root spans join that trace.
+func New(exporter Exporter, traceParent string) *Tracer {
+	remote, ok := ParseTraceParent(traceParent)
+	if !ok {
+		remote = nil
+	}
+
+	return &Tracer{exporter: exporter, remote: remote}
+}
+
+// Start creates a span, child of the span in ctx (if any).
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{ // nolint:exhaustruct // mutex
+		data: SpanData{ // nolint:exhaustruct // set on End
+			Attributes: make(map[string]interface{}),
+			Name:       name,
+			SpanID:     newSpanID(),
+			Start:      time.Now(),
+		},
+		tracer: t,
+	}
+
+	switch parent := SpanFromContext(ctx); {
+	case parent != nil:
+		span.data.TraceID = parent.data.TraceID
+		span.data.ParentID = parent.data.SpanID
+	case t.remote != nil:
+		span.data.TraceID = t.remote.TraceID
+		span.data.ParentID = t.remote.SpanID
+	default:
+		span.data.TraceID = newTraceID()
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Shutdown flushes the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	return t.exporter.Shutdown(ctx)
+}
+
+// SpanFromContext returns the current span, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+
+	return span
+}
+
+// SetAttribute records an attribute (string, bool, int, float64 or []string).
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.data.Attributes[key] = value
+}
+
+// End finishes the span, with an error status if err is not nil, and exports it.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	s.mtx.Lock()
+	s.data.End = time.Now()
+
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+
+	data := s.data
+	s.mtx.Unlock()
+
+	s.tracer.exporter.Export(data)
+}
+
+// TraceParent returns the W3C traceparent header of the span, or an empty string for nil spans.
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("00-%s-%s-01", s.data.TraceID, s.data.SpanID)
+}
+
+// ParseTraceParent parses a W3C traceparent header (version 00).
+func ParseTraceParent(header string) (*SpanData, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" { // nolint:gomnd // version-trace-span-flags
+		return nil, false
+	}
+
+	var data SpanData
+
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(data.TraceID) {
+		return nil, false
+	}
+
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(data.SpanID) {
+		return nil, false
+	}
+
+	copy(data.TraceID[:], traceID)
+	copy(data.SpanID[:], spanID)
+
+	if data.TraceID == (TraceID{}) || data.SpanID.IsZero() {
+		return nil, false
+	}
+
+	return &data, true
+}
+
+// newSpanID returns a random span ID.
+func newSpanID() SpanID {
+	var id SpanID
+
+	_, _ = rand.Read(id[:])
+
+	return id
+}
+
+// newTraceID returns a random trace ID.
+func newTraceID() TraceID {
+	var id TraceID
+
+	_, _ = rand.Read(id[:])
+
+	return id
+}
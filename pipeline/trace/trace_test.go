@@ -0,0 +1,249 @@
+package trace_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"bitbucket.org/lucacontini/z6/pipeline/trace"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder keeps the exported spans in memory.
+type recorder struct {
+	mtx   sync.Mutex
+	spans []trace.SpanData
+}
+
+func (r *recorder) Export(span trace.SpanData) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.spans = append(r.spans, span)
+}
+
+func (r *recorder) Shutdown(context.Context) error {
+	return nil
+}
+
+// nopCloser turns a buffer into an io.WriteCloser.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func TestParseTraceParent(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		header string
+		ok     bool
+	}{
+		"valid":        {header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ok: true},
+		"empty":        {header: "", ok: false},
+		"version":      {header: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ok: false},
+		"short trace":  {header: "00-4bf92f3577b34da6-00f067aa0ba902b7-01", ok: false},
+		"zero span":    {header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", ok: false},
+		"not hex":      {header: "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", ok: false},
+		"missing part": {header: "00-4bf92f3577b34da6a3ce929d0e0e4736-01", ok: false},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			data, ok := trace.ParseTraceParent(tt.header)
+			assert.Equal(t, tt.ok, ok)
+
+			if ok {
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", data.TraceID.String())
+				assert.Equal(t, "00f067aa0ba902b7", data.SpanID.String())
+			}
+		})
+	}
+}
+
+func TestTracer(t *testing.T) {
+	t.Parallel()
+
+	exporter := &recorder{mtx: sync.Mutex{}, spans: nil}
+	tracer := trace.New(exporter, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, root := tracer.Start(context.TODO(), "root")
+	_, child := tracer.Start(ctx, "child")
+
+	child.SetAttribute("process.exit.code", 3)
+	child.End(errors.New("exit status 3"))
+	root.End(nil)
+
+	require.Len(t, exporter.spans, 2)
+
+	childData, rootData := exporter.spans[0], exporter.spans[1]
+	assert.Equal(t, "child", childData.Name)
+	assert.Equal(t, "exit status 3", childData.Error)
+	assert.Equal(t, 3, childData.Attributes["process.exit.code"])
+	assert.Equal(t, rootData.SpanID, childData.ParentID)
+	assert.Equal(t, rootData.TraceID, childData.TraceID)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", rootData.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", rootData.ParentID.String())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+childData.SpanID.String()+"-01", child.TraceParent())
+}
+
+func TestNilTracer(t *testing.T) {
+	t.Parallel()
+
+	var tracer *trace.Tracer
+
+	ctx, span := tracer.Start(context.TODO(), "root")
+	assert.Nil(t, span)
+	assert.Nil(t, trace.SpanFromContext(ctx))
+
+	span.SetAttribute("key", "value")
+	span.End(nil)
+	assert.Empty(t, span.TraceParent())
+	assert.NoError(t, tracer.Shutdown(context.TODO()))
+}
+
+func TestJSONLines(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	tracer := trace.New(trace.NewJSONLines(nopCloser{&buf}), "")
+
+	ctx, root := tracer.Start(context.TODO(), "root")
+	_, child := tracer.Start(ctx, "child")
+
+	child.SetAttribute("process.command_args", []string{"-c", "exit 1"})
+	child.End(errors.New("exit status 1"))
+	root.End(nil)
+	require.NoError(t, tracer.Shutdown(context.TODO()))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var spans [2]map[string]interface{}
+
+	for i, line := range lines {
+		require.NoError(t, json.Unmarshal([]byte(line), &spans[i]))
+	}
+
+	assert.Equal(t, "child", spans[0]["name"])
+	assert.Equal(t, spans[1]["spanId"], spans[0]["parentSpanId"])
+	assert.Equal(t, map[string]interface{}{"code": 2.0, "message": "exit status 1"}, spans[0]["status"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"key": "process.command_args",
+			"value": map[string]interface{}{"arrayValue": map[string]interface{}{"values": []interface{}{
+				map[string]interface{}{"stringValue": "-c"},
+				map[string]interface{}{"stringValue": "exit 1"},
+			}}},
+		},
+	}, spans[0]["attributes"])
+	assert.NotContains(t, spans[1], "parentSpanId")
+}
+
+func TestOTLP(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mtx    sync.Mutex
+		bodies []map[string]interface{}
+		paths  []string
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		mtx.Lock()
+		bodies = append(bodies, body)
+		paths = append(paths, r.URL.Path)
+		mtx.Unlock()
+	}))
+	defer srv.Close()
+
+	tracer := trace.New(trace.NewOTLP(srv.URL+"/", "test"), "")
+
+	_, span := tracer.Start(context.TODO(), "root")
+	span.SetAttribute("process.pid", 42)
+	span.End(nil)
+	require.NoError(t, tracer.Shutdown(context.TODO()))
+
+	require.Len(t, bodies, 1)
+	assert.Equal(t, []string{"/v1/traces"}, paths)
+
+	resource := bodies[0]["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"attributes": []interface{}{
+			map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "test"}},
+		},
+	}, resource["resource"])
+
+	spans := resource["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "root", spans[0].(map[string]interface{})["name"])
+}
+
+func TestOTLPError(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	tracer := trace.New(trace.NewOTLP(srv.URL, "test"), "")
+
+	_, span := tracer.Start(context.TODO(), "root")
+	span.End(nil)
+
+	assert.EqualError(t, tracer.Shutdown(context.TODO()), "otlp (1 failed requests): 400 Bad Request: export failed")
+
+	// Once shut down, the spans are dropped and the same error is returned again.
+	_, span = tracer.Start(context.TODO(), "late")
+	span.End(nil)
+
+	assert.EqualError(t, tracer.Shutdown(context.TODO()), "otlp (1 failed requests): 400 Bad Request: export failed")
+}
+
+func TestOTLPDropped(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+
+	// The collector hangs until released, so that the queue fills up.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+
+	tracer := trace.New(trace.NewOTLP(srv.URL, "test"), "")
+
+	// A batch is sent (and hangs), the queue holds up to 10 batches: the rest is dropped without blocking.
+	for i := 0; i < 12*100; i++ {
+		_, span := tracer.Start(context.TODO(), "span")
+		span.End(nil)
+	}
+
+	close(release)
+
+	err := tracer.Shutdown(context.TODO())
+	assert.ErrorContains(t, err, "spans dropped, the export queue was full")
+	assert.True(t, strings.HasPrefix(err.Error(), "otlp: "))
+}
@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+	"bitbucket.org/lucacontini/z6/pipeline/trace"
+)
+
+// Span attributes.
+const (
+	AttrArgs     = "process.command_args"
+	AttrAttempt  = "pipeline.restart.attempt"
+	AttrCommand  = "process.command"
+	AttrExitCode = "process.exit.code"
+	AttrKind     = "pipeline.node.kind"
+	AttrPath     = "pipeline.node.path"
+	AttrPID      = "process.pid"
+	AttrPolicy   = "pipeline.node.policy"
+	AttrRestarts = "pipeline.node.restarts"
+	AttrType     = "pipeline.plugin.type"
+)
+
+// WithTracer sets up the tracer (nil disables tracing).
+func (n *Node) WithTracer(t *trace.Tracer) *Node {
+	n.tracer = t
+
+	return n
+}
+
+// startSpan starts the span of a node run.
+func (n *Node) startSpan(ctx context.Context) (context.Context, *trace.Span) {
+	ctx, span := n.tracer.Start(ctx, n.ID())
+
+	span.SetAttribute(AttrKind, string(n.Kind()))
+	span.SetAttribute(AttrPath, n.Path())
+
+	if n.OnExit != "" {
+		span.SetAttribute(AttrPolicy, string(n.OnExit))
+	}
+
+	switch {
+	case n.IsCommand():
+		span.SetAttribute(AttrCommand, n.Command)
+		span.SetAttribute(AttrArgs, n.Args)
+	case n.IsPlugin():
+		span.SetAttribute(AttrType, n.Type)
+	}
+
+	return ctx, span
+}
+
+// traceAttempts wraps a command (or plugin) task to record a span per attempt. When proc is set, the process
+// joins the trace through its TRACEPARENT variable and the span records its PID and exit code.
+func (n *Node) traceAttempts(task loop.Task, proc *subprocess.Proc) loop.Task { // nolint:ireturn // wrapper
+	if n.tracer == nil {
+		return task
+	}
+
+	attempt := 0
+
+	return loop.TaskFunc(func(ctx context.Context) error {
+		attempt++
+
+		parent := trace.SpanFromContext(ctx)
+		parent.SetAttribute(AttrRestarts, attempt-1)
+
+		ctx, span := n.tracer.Start(ctx, fmt.Sprintf("%s #%d", n.ID(), attempt))
+		span.SetAttribute(AttrAttempt, attempt)
+
+		if proc != nil {
+			proc.Env = n.environ(trace.EnvTraceParent + "=" + span.TraceParent())
+		}
+
+		err := task.Run(ctx)
+
+		if proc != nil && proc.ProcessState() != nil {
+			state := proc.ProcessState()
+
+			span.SetAttribute(AttrPID, state.Pid())
+			span.SetAttribute(AttrExitCode, state.ExitCode())
+			parent.SetAttribute(AttrExitCode, state.ExitCode())
+		}
+
+		span.End(err)
+
+		return err
+	})
+}
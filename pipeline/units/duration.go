@@ -0,0 +1,85 @@
+// package units provides the value types shared by the pipeline definitions and the stream sinks.
+package units
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration that decodes the same way from YAML, JSON and TOML.
+//
+// It accepts any string understood by time.ParseDuration (eg: `1m30s`, `90s` or `1.5m`) and rejects bare numbers,
+// whose unit would be ambiguous.
+type Duration time.Duration
+
+var (
+	// errBareNumber is returned when a duration has no unit.
+	errBareNumber = errors.New("duration without a unit, eg: 90s or 1m30s")
+	// errInvalidDuration is returned when a duration cannot be parsed.
+	errInvalidDuration = errors.New("invalid duration")
+)
+
+// Duration returns the value as a time.Duration.
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// String implements fmt.Stringer.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText implements encoding.TextMarshaler (used by all encoders).
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler: null leaves the value unchanged, like for the other types.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	var str string
+
+	if err := json.Unmarshal(data, &str); err != nil {
+		// Numbers are handed over as they are, so that they get the same error as the other formats.
+		str = string(data)
+	}
+
+	return d.UnmarshalText([]byte(str))
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler (used by the TOML decoder).
+func (d *Duration) UnmarshalText(text []byte) error {
+	str := string(text)
+
+	// ParseFloat accepts nan and inf as well, which are no numbers without a unit.
+	if num, err := strconv.ParseFloat(str, 64); err == nil && str != "0" && !math.IsNaN(num) && !math.IsInf(num, 0) {
+		return errors.Wrap(errBareNumber, str)
+	}
+
+	val, err := time.ParseDuration(str)
+	if err != nil {
+		return errors.Wrap(errInvalidDuration, err.Error())
+	}
+
+	*d = Duration(val)
+
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return errors.Wrapf(errInvalidDuration, "line %d: not a scalar", value.Line)
+	}
+
+	return errors.Wrapf(d.UnmarshalText([]byte(value.Value)), "line %d", value.Line)
+}
@@ -0,0 +1,127 @@
+package pipeline
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+	"github.com/pkg/errors"
+)
+
+var (
+	// errBogusNode is returned when a node mixes commands, steps and parallel tasks.
+	errBogusNode = errors.New("a node must be either a command, a task type, a list of steps or a list of parallel tasks")
+	// errCacheNotCommand is returned when inputs or outputs are set on a node that is not a command.
+	errCacheNotCommand = errors.New("inputs and outputs are only supported by commands")
+	// errEnvInput is returned when an input declares an invalid environment variable name.
+	errEnvInput = errors.New("invalid environment variable input, eg: $GOFLAGS")
+	// errWatchRecursive is returned when a watch pattern contains **, which is only supported by the inputs.
+	errWatchRecursive = errors.New("** is not supported by the watch patterns")
+	// errEmptySink is returned when a stream sink has no path.
+	errEmptySink = errors.New("stream sink without a path")
+	// errScheduleEvery is returned when a node has both a cron schedule and an interval.
+	errScheduleEvery = errors.New("schedule and every are mutually exclusive")
+	// errNegativeDuration is returned when a timeout, a delay or an interval is negative.
+	errNegativeDuration = errors.New("negative duration")
+)
+
+// Validate checks the node tree and returns the first configuration error found.
+func (n *Node) Validate() error {
+	return n.validate(n.ID())
+}
+
+// validate checks the node and its children; path identifies the node in error messages.
+func (n *Node) validate(path string) error {
+	kinds := 0
+
+	for _, ok := range []bool{n.IsCommand(), n.IsParallel(), n.IsPlugin(), n.IsSerial()} {
+		if ok {
+			kinds++
+		}
+	}
+
+	switch {
+	case kinds > 1:
+		return errors.Wrap(errBogusNode, path)
+	case n.Timeout < 0 || n.Delay < 0 || n.Every < 0 || n.StopTimeout < 0:
+		return errors.Wrap(errNegativeDuration, path)
+	case n.Schedule != "" && n.Every != 0:
+		return errors.Wrap(errScheduleEvery, path)
+	case n.IsPlugin() && !loop.Registered(n.Type):
+		return errors.Wrapf(loop.ErrUnknownType, "%s: %s", path, n.Type)
+	case (len(n.Inputs) > 0 || len(n.Outputs) > 0) && !n.IsCommand():
+		return errors.Wrap(errCacheNotCommand, path)
+	case (n.Capture != "" || n.CaptureJSON) && !n.IsCommand():
+		return errors.Wrap(errCaptureNotCommand, path)
+	case n.Capture != "" && !captureName.MatchString(n.Capture):
+		return errors.Wrapf(errCaptureName, "%s: %s", path, n.Capture)
+	}
+
+	for _, sink := range append(append(subprocess.Sinks(nil), n.Stdout...), n.Stderr...) {
+		if sink.Path == "" {
+			return errors.Wrap(errEmptySink, path)
+		}
+
+		if err := sink.Validate(); err != nil {
+			return errors.Wrap(err, path)
+		}
+	}
+
+	for _, input := range n.Inputs {
+		if name, ok := envInput(input); ok {
+			if !envName.MatchString(name) {
+				return errors.Wrapf(errEnvInput, "%s: %s", path, input)
+			}
+
+			continue
+		}
+
+		if err := checkGlob(input); err != nil {
+			return errors.Wrapf(err, "%s: pattern %s", path, input)
+		}
+	}
+
+	for _, pattern := range n.Watch {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "%s: pattern %s", path, pattern)
+		}
+
+		if strings.Contains(pattern, "**") {
+			return errors.Wrapf(errWatchRecursive, "%s: pattern %s", path, pattern)
+		}
+	}
+
+	if err := n.OnExit.Validate(); err != nil {
+		return errors.Wrap(err, path)
+	}
+
+	if err := n.Overlap.Validate(); err != nil {
+		return errors.Wrap(err, path)
+	}
+
+	if _, err := n.schedule(); err != nil {
+		return errors.Wrap(err, path)
+	}
+
+	for name, hook := range n.Hooks.list() {
+		if err := hook.validate(fmt.Sprintf("%s.hooks.%s", path, name)); err != nil {
+			return err
+		}
+	}
+
+	for i := range n.Parallel {
+		if err := n.Parallel[i].validate(fmt.Sprintf("%s.parallel[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+
+	for i := range n.Steps {
+		if err := n.Steps[i].validate(fmt.Sprintf("%s.steps[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
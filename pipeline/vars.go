@@ -0,0 +1,123 @@
+package pipeline
+
+import (
+	"regexp"
+
+	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+)
+
+// varPattern matches the ${NAME} references (node names can appear, as in ${steps.<name>.stdout}); other dollar signs
+// (eg: $HOME in shell scripts) are left untouched.
+var varPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_.-]*)\}`)
+
+// WithVars sets variables that take precedence over the `vars` of every node (eg: from the command line).
+func WithVars(vars map[string]string) Option {
+	return func(n *Node) {
+		n.overrides = vars
+	}
+}
+
+// expandVars replaces the ${NAME} references in the commands, arguments, streams and plugin settings of the tree.
+//
+// The variables of a node apply to its subtree (and hooks) and override those of its ancestors; they can reference
+// each other and the variables of the ancestors. Unknown references are left as they are.
+func (n *Node) expandVars(inherited, overrides map[string]string) {
+	scope := make(map[string]string, len(inherited)+len(n.Vars)+len(overrides))
+	resolving := make(map[string]bool, len(n.Vars))
+
+	for name, value := range inherited {
+		if _, ok := n.Vars[name]; !ok {
+			scope[name] = value
+		}
+	}
+
+	for name, value := range overrides {
+		scope[name] = value
+	}
+
+	var resolve func(name string) (string, bool)
+
+	resolve = func(name string) (string, bool) {
+		if value, ok := scope[name]; ok {
+			return value, true
+		}
+
+		value, ok := n.Vars[name]
+
+		switch {
+		case !ok:
+			return "", false
+		case resolving[name]:
+			// A self reference (eg: PATH: ${PATH}:/opt/bin) refers to the inherited value.
+			value, ok = inherited[name]
+
+			return value, ok
+		}
+
+		resolving[name] = true
+		scope[name] = expand(value, resolve)
+
+		return scope[name], true
+	}
+
+	for name := range n.Vars {
+		resolve(name)
+	}
+
+	n.Command = expand(n.Command, resolve)
+
+	for _, sinks := range []subprocess.Sinks{n.Stderr, n.Stdout} {
+		for i := range sinks {
+			sinks[i].Path, sinks[i].Prefix = expand(sinks[i].Path, resolve), expand(sinks[i].Prefix, resolve)
+		}
+	}
+
+	for _, list := range [][]string{n.Args, n.Inputs, n.Outputs, n.Watch} {
+		for i := range list {
+			list[i] = expand(list[i], resolve)
+		}
+	}
+
+	for key, value := range n.With {
+		n.With[key] = expandValue(value, resolve)
+	}
+
+	for _, hook := range n.Hooks.list() {
+		hook.expandVars(scope, overrides)
+	}
+
+	for _, children := range [][]Node{n.Parallel, n.Steps} {
+		for i := range children {
+			children[i].expandVars(scope, overrides)
+		}
+	}
+}
+
+// expand replaces the ${NAME} references of a string.
+func expand(str string, lookup func(name string) (string, bool)) string {
+	return varPattern.ReplaceAllStringFunc(str, func(ref string) string {
+		if value, ok := lookup(varPattern.FindStringSubmatch(ref)[1]); ok {
+			return value
+		}
+
+		return ref
+	})
+}
+
+// expandValue replaces the ${NAME} references of the strings of a decoded value.
+func expandValue(value interface{}, lookup func(name string) (string, bool)) interface{} {
+	switch value := value.(type) {
+	case string:
+		return expand(value, lookup)
+	case []interface{}:
+		for i := range value {
+			value[i] = expandValue(value[i], lookup)
+		}
+	case map[string]interface{}:
+		for key := range value {
+			value[key] = expandValue(value[key], lookup)
+		}
+	}
+
+	return value
+}
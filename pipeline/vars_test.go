@@ -0,0 +1,71 @@
+package pipeline_test
+
+import (
+	"testing"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"bitbucket.org/lucacontini/z6/pipeline/subprocess"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVars(t *testing.T) {
+	t.Parallel()
+
+	const config = `
+name: root
+vars:
+  ENV: staging
+  TARGET: deploy-${ENV}
+steps:
+  - name: release
+    path: echo
+    args: ["${TARGET}", "${VERSION}", "$HOME", "${UNKNOWN}"]
+    stdout: /tmp/${ENV}.log
+  - name: prod
+    vars:
+      ENV: prod
+      TARGET: ${TARGET}-eu
+    steps:
+      - name: notify
+        type: sleep
+        with:
+          message: ${ENV}
+          list: ["${TARGET}"]
+`
+
+	tests := map[string]struct {
+		vars   map[string]string
+		args   []string
+		stdout string
+		with   map[string]interface{}
+	}{
+		"file vars": {
+			vars:   nil,
+			args:   []string{"deploy-staging", "${VERSION}", "$HOME", "${UNKNOWN}"},
+			stdout: "/tmp/staging.log",
+			with:   map[string]interface{}{"message": "prod", "list": []interface{}{"deploy-staging-eu"}},
+		},
+		"overrides": {
+			vars:   map[string]string{"ENV": "dev", "VERSION": "1.2"},
+			args:   []string{"deploy-dev", "1.2", "$HOME", "${UNKNOWN}"},
+			stdout: "/tmp/dev.log",
+			with:   map[string]interface{}{"message": "dev", "list": []interface{}{"deploy-dev-eu"}},
+		},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			node, err := pipeline.New(config, pipeline.WithVars(tt.vars))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.args, node.Steps[0].Args)
+			assert.Equal(t, subprocess.To(tt.stdout), node.Steps[0].Stdout)
+			assert.Equal(t, tt.with, node.Steps[1].Steps[0].With)
+		})
+	}
+}
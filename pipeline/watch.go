@@ -0,0 +1,119 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/loop"
+	"bitbucket.org/lucacontini/z6/pipeline/watch"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultDebounce is how long the watch mode waits for the file changes to settle.
+	DefaultDebounce = 300 * time.Millisecond
+	// DefaultGrace is how long the processes can take to exit on SIGTERM, when restarted by the watch mode.
+	DefaultGrace = 5 * time.Second
+)
+
+// watchConfig configures the watch mode.
+type watchConfig struct {
+	debounce time.Duration
+	grace    time.Duration
+}
+
+// WithWatch enables the watch mode: the nodes with watch patterns run until the context is done, and run again when
+// their files change (a running node is restarted, its processes are stopped with SIGTERM and killed after grace,
+// unless the node sets its own stopTimeout).
+// The patterns of a serial step re-run its whole serial group instead, so that the following steps run again too.
+// Changes are batched: the nodes run again once nothing changed for debounce.
+func (n *Node) WithWatch(debounce, grace time.Duration) *Node {
+	n.watching = &watchConfig{debounce: debounce, grace: grace}
+
+	return n
+}
+
+// Watched returns whether any node of the tree has watch patterns.
+func (n *Node) Watched() bool {
+	if len(n.Watch) > 0 {
+		return true
+	}
+
+	children := n.Children()
+	for i := range children {
+		if children[i].Watched() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watched wraps the task of the node so that it runs again when the watched files change, in watch mode. The task
+// runs in a loop that waits (paused) once the task returns, and is restarted through its control by the watcher.
+func (n *Node) watched(task loop.Task) loop.Task { // nolint:ireturn // wrapper
+	patterns := n.watchPatterns()
+	if n.watching == nil || n.step || len(patterns) == 0 {
+		return task
+	}
+
+	return loop.TaskFunc(func(ctx context.Context) error {
+		watcher, err := watch.New(patterns, n.watching.debounce)
+		if err != nil {
+			return errors.Wrap(err, "watch")
+		}
+
+		defer watcher.Close()
+
+		control := loop.NewControl()
+		control.Pause()
+
+		go func() {
+			for changed := range watcher.Changes() {
+				n.logger.Info("files changed, running again", zap.Strings("files", changed))
+				control.Restart()
+			}
+		}()
+
+		attempt := loop.TaskFunc(func(ctx context.Context) error {
+			err := task.Run(ctx)
+			if ctx.Err() == nil {
+				n.logger.Info("waiting for changes", zap.Strings("watch", patterns), zap.Error(err))
+			}
+
+			return err
+		})
+
+		return loop.Loop(loop.Named(n.Path(), attempt)).
+			WithControl(control).
+			WithLogger(n.logger).
+			WithObserver(n.observer).
+			WithPolicy(loop.ExitPolicyRestart).
+			Run(ctx)
+	})
+}
+
+// watchPatterns returns the patterns of the node, along with the ones of its serial steps (recursively).
+func (n *Node) watchPatterns() []string {
+	patterns := append([]string(nil), n.Watch...)
+
+	for i := range n.Steps {
+		patterns = append(patterns, n.Steps[i].watchPatterns()...)
+	}
+
+	return patterns
+}
+
+// stopTimeout returns how long the processes can take to exit on SIGTERM (see subprocess.Proc.StopTimeout): the
+// node stopTimeout if set, else the grace of the watch mode (they are killed right away by default).
+func (n *Node) stopTimeout() time.Duration {
+	switch {
+	case n.StopTimeout > 0:
+		return n.StopTimeout.Duration()
+	case n.watching != nil:
+		return n.watching.grace
+	default:
+		return 0
+	}
+}
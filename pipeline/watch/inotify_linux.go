@@ -0,0 +1,117 @@
+//go:build linux
+// +build linux
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// inotifyMask lists the watched events.
+const inotifyMask = syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotify implements notifier with the Linux inotify API.
+type inotify struct {
+	mtx     sync.Mutex
+	changed chan string
+	dirs    map[int32]string
+	done    chan struct{}
+	fd      int
+	file    *os.File
+}
+
+// newNotifier returns an inotify notifier.
+func newNotifier() (notifier, error) { // nolint:ireturn // platform specific
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Wrap(err, "inotify")
+	}
+
+	inst := &inotify{
+		mtx:     sync.Mutex{},
+		changed: make(chan string),
+		dirs:    make(map[int32]string),
+		done:    make(chan struct{}),
+		fd:      fd,
+		// A non-blocking file uses the runtime poller, so that Close interrupts Read.
+		file: os.NewFile(uintptr(fd), "inotify"),
+	}
+
+	go inst.read()
+
+	return inst, nil
+}
+
+// add implements notifier.
+func (n *inotify) add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, inotifyMask)
+	if err != nil {
+		return errors.Wrapf(err, "cannot watch %s", dir)
+	}
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	n.dirs[int32(wd)] = dir
+
+	return nil
+}
+
+// paths implements notifier.
+func (n *inotify) paths() <-chan string {
+	return n.changed
+}
+
+// close implements notifier.
+func (n *inotify) close() error {
+	close(n.done)
+
+	return errors.Wrap(n.file.Close(), "inotify")
+}
+
+// read decodes the inotify events until the notifier is closed.
+func (n *inotify) read() {
+	defer close(n.changed)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1)) // nolint:gomnd // events per read
+
+	for {
+		size, err := n.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= size; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset])) // nolint:gosec // kernel layout
+			start := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[start:start+int(event.Len)]), "\x00")
+			offset = start + int(event.Len)
+
+			n.mtx.Lock()
+			dir, ok := n.dirs[event.Wd]
+
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(n.dirs, event.Wd)
+			}
+			n.mtx.Unlock()
+
+			if !ok || name == "" {
+				continue
+			}
+
+			select {
+			case n.changed <- filepath.Join(dir, name):
+			case <-n.done:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+//go:build !linux
+// +build !linux
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// pollInterval is how often the watched directories are scanned.
+const pollInterval = 500 * time.Millisecond
+
+type (
+	// poller implements notifier by scanning the watched directories.
+	poller struct {
+		mtx     sync.Mutex
+		changed chan string
+		dirs    map[string]map[string]stamp
+		done    chan struct{}
+	}
+
+	// stamp identifies a version of a file.
+	stamp struct {
+		modTime time.Time
+		size    int64
+	}
+)
+
+// newNotifier returns a polling notifier.
+func newNotifier() (notifier, error) { // nolint:ireturn,unparam // platform specific
+	inst := &poller{
+		mtx:     sync.Mutex{},
+		changed: make(chan string),
+		dirs:    make(map[string]map[string]stamp),
+		done:    make(chan struct{}),
+	}
+
+	go inst.run()
+
+	return inst, nil
+}
+
+// add implements notifier.
+func (p *poller) add(dir string) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if _, ok := p.dirs[dir]; !ok {
+		p.dirs[dir] = scan(dir)
+	}
+
+	return nil
+}
+
+// paths implements notifier.
+func (p *poller) paths() <-chan string {
+	return p.changed
+}
+
+// close implements notifier.
+func (p *poller) close() error {
+	close(p.done)
+
+	return nil
+}
+
+// run scans the directories until the notifier is closed.
+func (p *poller) run() {
+	defer close(p.changed)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		for _, path := range p.poll() {
+			select {
+			case p.changed <- path:
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
+
+// poll returns the paths that changed since the last scan.
+func (p *poller) poll() []string {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var changed []string
+
+	for dir, before := range p.dirs {
+		after := scan(dir)
+
+		for name, stamp := range after {
+			if previous, ok := before[name]; !ok || previous != stamp {
+				changed = append(changed, filepath.Join(dir, name))
+			}
+		}
+
+		for name := range before {
+			if _, ok := after[name]; !ok {
+				changed = append(changed, filepath.Join(dir, name))
+			}
+		}
+
+		p.dirs[dir] = after
+	}
+
+	return changed
+}
+
+// scan returns the stamps of the entries of a directory.
+func scan(dir string) map[string]stamp {
+	stamps := make(map[string]stamp)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return stamps
+	}
+
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil {
+			stamps[entry.Name()] = stamp{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+
+	return stamps
+}
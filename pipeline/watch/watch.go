@@ -0,0 +1,191 @@
+// package watch notifies the changes of the files matching glob patterns.
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// Watcher notifies the changes of the files matching glob patterns (see filepath.Match); the directories matching
+	// a pattern are watched recursively. Changes are batched: a batch is sent once nothing changed for the debounce
+	// period.
+	Watcher struct {
+		changes  chan []string
+		debounce time.Duration
+		done     chan struct{}
+		notifier notifier
+		once     sync.Once
+		patterns []string
+	}
+
+	// notifier reports the changed paths of the watched directories (inotify on Linux, polling elsewhere).
+	notifier interface {
+		// add watches the entries of a directory (not recursively); adding a directory twice is harmless.
+		add(dir string) error
+		// paths returns the changed paths, closed along with the notifier.
+		paths() <-chan string
+		close() error
+	}
+)
+
+// New starts watching the patterns.
+func New(patterns []string, debounce time.Duration) (*Watcher, error) {
+	clean := make([]string, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, errors.Wrap(err, pattern)
+		}
+
+		clean = append(clean, filepath.Clean(pattern))
+	}
+
+	notifier, err := newNotifier()
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &Watcher{
+		changes:  make(chan []string),
+		debounce: debounce,
+		done:     make(chan struct{}),
+		notifier: notifier,
+		once:     sync.Once{},
+		patterns: clean,
+	}
+
+	if err := inst.refresh(); err != nil {
+		notifier.close()
+
+		return nil, err
+	}
+
+	go inst.run()
+
+	return inst, nil
+}
+
+// Changes returns the batches of changed paths, sorted. It is closed along with the watcher.
+func (w *Watcher) Changes() <-chan []string {
+	return w.changes
+}
+
+// Close stops watching.
+func (w *Watcher) Close() error {
+	var err error
+
+	w.once.Do(func() {
+		close(w.done)
+		err = w.notifier.close()
+	})
+
+	return err
+}
+
+// run batches the changes matching the patterns until the watcher is closed.
+func (w *Watcher) run() {
+	defer close(w.changes)
+
+	pending := make(map[string]struct{})
+	timer := time.NewTimer(w.debounce)
+	fire := (<-chan time.Time)(nil)
+
+	timer.Stop()
+
+	for {
+		select {
+		case path, ok := <-w.notifier.paths():
+			if !ok {
+				return
+			}
+
+			// New directories may need watching.
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				_ = w.refresh()
+			}
+
+			if !w.matches(path) {
+				continue
+			}
+
+			pending[path] = struct{}{}
+
+			timer.Stop()
+			timer.Reset(w.debounce)
+			fire = timer.C
+		case <-fire:
+			batch := make([]string, 0, len(pending))
+			for path := range pending {
+				batch = append(batch, path)
+			}
+
+			sort.Strings(batch)
+
+			pending, fire = make(map[string]struct{}), nil
+
+			select {
+			case w.changes <- batch:
+			case <-w.done:
+				return
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// refresh watches the directories where matching files can appear, and the directories matching the patterns along
+// with their subdirectories.
+func (w *Watcher) refresh() error {
+	for _, pattern := range w.patterns {
+		parents, _ := filepath.Glob(filepath.Dir(pattern))
+		matches, _ := filepath.Glob(pattern)
+
+		for _, dir := range parents {
+			if err := w.notifier.add(dir); err != nil {
+				return err
+			}
+		}
+
+		for _, match := range matches {
+			err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil || !info.IsDir() {
+					return nil // nolint:nilerr // vanished files are not watched
+				}
+
+				return w.notifier.add(path)
+			})
+			if err != nil {
+				return err // nolint:wrapcheck // already wrapped
+			}
+		}
+	}
+
+	return nil
+}
+
+// matches returns whether the path, or one of its parent directories, matches a pattern.
+func (w *Watcher) matches(path string) bool {
+	for _, pattern := range w.patterns {
+		for current := path; ; {
+			if ok, _ := filepath.Match(pattern, current); ok {
+				return true
+			}
+
+			parent := filepath.Dir(current)
+			if parent == current {
+				break
+			}
+
+			current = parent
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,71 @@
+package watch_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline/watch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// timeout is how long a batch of changes can take.
+const timeout = 5 * time.Second
+
+func TestWatcher(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	src, assets := filepath.Join(dir, "src"), filepath.Join(dir, "assets")
+
+	require.NoError(t, os.MkdirAll(src, 0o700))
+	require.NoError(t, os.MkdirAll(assets, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "main.go"), nil, 0o600))
+
+	watcher, err := watch.New([]string{filepath.Join(src, "*.go"), assets}, 50*time.Millisecond)
+	require.NoError(t, err)
+
+	defer watcher.Close()
+
+	// Debounced.
+	require.NoError(t, os.WriteFile(filepath.Join(src, "main.go"), []byte("package main"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "util.go"), nil, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "README"), nil, 0o600))
+
+	assert.Equal(t, []string{filepath.Join(src, "main.go"), filepath.Join(src, "util.go")}, next(t, watcher))
+
+	// Recursive, new directories included.
+	require.NoError(t, os.MkdirAll(filepath.Join(assets, "css"), 0o700))
+	assert.Equal(t, []string{filepath.Join(assets, "css")}, next(t, watcher))
+
+	require.NoError(t, os.WriteFile(filepath.Join(assets, "css", "site.css"), nil, 0o600))
+	assert.Equal(t, []string{filepath.Join(assets, "css", "site.css")}, next(t, watcher))
+
+	require.NoError(t, watcher.Close())
+
+	_, ok := <-watcher.Changes()
+	assert.False(t, ok)
+}
+
+func TestNewInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := watch.New([]string{"src/["}, time.Second)
+	assert.EqualError(t, err, "src/[: syntax error in pattern")
+}
+
+// next returns the next batch of changes.
+func next(t *testing.T, watcher *watch.Watcher) []string {
+	t.Helper()
+
+	select {
+	case batch := <-watcher.Changes():
+		return batch
+	case <-time.After(timeout):
+		t.Fatal("no change")
+
+		return nil
+	}
+}
@@ -0,0 +1,78 @@
+package pipeline_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/lucacontini/z6/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]func(script, pattern string) *pipeline.Builder{
+		// The step re-runs its serial group.
+		"serial step": func(script, pattern string) *pipeline.Builder {
+			return pipeline.Serial(
+				pipeline.Command("sh", "-c", script).Name("build").Watch(pattern),
+				pipeline.Command("true").Name("check"),
+			)
+		},
+		// The running daemon is restarted.
+		"daemon": func(script, pattern string) *pipeline.Builder {
+			return pipeline.Parallel(
+				pipeline.Command("sh", "-c", script+"; sleep 10").Name("serve").Watch(pattern),
+				pipeline.Command("true").Name("check"),
+			)
+		},
+	}
+
+	for name, tree := range tests {
+		tree := tree
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			counter := filepath.Join(dir, "counter")
+
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0o700))
+
+			node, err := tree("echo run >> "+counter, filepath.Join(dir, "src", "*")).
+				Name("root").Log(pipeline.LogConfig{Disabled: true}).Build()
+			require.NoError(t, err)
+			assert.True(t, node.Watched())
+
+			ctx, cancel := context.WithCancel(context.TODO())
+			done := make(chan error)
+
+			go func() {
+				done <- node.WithWatch(20*time.Millisecond, time.Second).Run(ctx)
+			}()
+
+			runs := func() int {
+				data, _ := os.ReadFile(counter)
+
+				return len(strings.Fields(string(data)))
+			}
+
+			require.Eventually(t, func() bool { return runs() == 1 }, 5*time.Second, 10*time.Millisecond)
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "main.c"), nil, 0o600))
+			require.Eventually(t, func() bool { return runs() == 2 }, 5*time.Second, 10*time.Millisecond)
+
+			cancel()
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("still running")
+			}
+		})
+	}
+}